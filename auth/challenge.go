@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Challenge is an authentication challenge from a WWW-Authenticate header,
+// as described in RFC 7235.
+type Challenge struct {
+	// Authentication scheme, such as "Digest" or "Basic"
+	Scheme string
+	// Auth parameters with lower case names
+	Params map[string]string
+	// token68 value, for schemes that use one instead of parameters
+	Token68 string
+}
+
+// ParseChallenges parses all challenges in the WWW-Authenticate headers of
+// header. A header may contain several challenges, and quoted parameter
+// values may contain commas, equal signs and escaped quotes.
+func ParseChallenges(header http.Header) ([]Challenge, error) {
+	challenges := []Challenge{}
+	for _, v := range header.Values("WWW-Authenticate") {
+		c, err := parseChallenges(v)
+		if err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, c...)
+	}
+	return challenges, nil
+}
+
+// ParseCredentials parses the credentials of an Authorization header,
+// which are written like a single challenge.
+func ParseCredentials(header string) (Challenge, error) {
+	challenges, err := parseChallenges(header)
+	if err != nil {
+		return Challenge{}, err
+	}
+	if len(challenges) != 1 {
+		return Challenge{}, fmt.Errorf("expected one set of credentials, got %d", len(challenges))
+	}
+	return challenges[0], nil
+}
+
+type scanner struct {
+	s   string
+	pos int
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.s)
+}
+
+func (s *scanner) peek() byte {
+	if s.done() {
+		return 0
+	}
+	return s.s[s.pos]
+}
+
+func (s *scanner) skipSpace() {
+	for !s.done() && (s.s[s.pos] == ' ' || s.s[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+func (s *scanner) skipSeparators() {
+	for !s.done() && (s.s[s.pos] == ' ' || s.s[s.pos] == '\t' || s.s[s.pos] == ',') {
+		s.pos++
+	}
+}
+
+func (s *scanner) token() string {
+	start := s.pos
+	for !s.done() && isTokenChar(s.s[s.pos]) {
+		s.pos++
+	}
+	return s.s[start:s.pos]
+}
+
+func (s *scanner) quotedString() (string, error) {
+	start := s.pos
+	s.pos++ // opening quote
+	var b strings.Builder
+	for !s.done() {
+		c := s.s[s.pos]
+		s.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if s.done() {
+				return "", fmt.Errorf("unterminated escape in quoted string at %d", start)
+			}
+			b.WriteByte(s.s[s.pos])
+			s.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted string at %d", start)
+}
+
+// isParam reports whether the scanner is at the start of an auth-param,
+// as opposed to the scheme of the next challenge.
+func (s *scanner) isParam() bool {
+	pos := s.pos
+	defer func() { s.pos = pos }()
+
+	if s.token() == "" {
+		return false
+	}
+	s.skipSpace()
+	return s.peek() == '='
+}
+
+func parseChallenges(header string) ([]Challenge, error) {
+	s := &scanner{s: header}
+	challenges := []Challenge{}
+
+	for {
+		s.skipSeparators()
+		if s.done() {
+			break
+		}
+
+		scheme := s.token()
+		if scheme == "" {
+			return nil, fmt.Errorf("invalid character %q at %d in challenge", s.peek(), s.pos)
+		}
+		c := Challenge{Scheme: scheme, Params: make(map[string]string)}
+		s.skipSpace()
+
+		// token68 is a single value of token characters and slashes,
+		// optionally padded with equal signs.
+		if start := s.pos; !s.done() && s.peek() != ',' && !s.isParam() {
+			for !s.done() && (isTokenChar(s.peek()) || s.peek() == '/') {
+				s.pos++
+			}
+			for !s.done() && s.peek() == '=' {
+				s.pos++
+			}
+			if s.pos > start {
+				c.Token68 = s.s[start:s.pos]
+			}
+		}
+
+		for c.Token68 == "" {
+			s.skipSeparators()
+			if s.done() || !s.isParam() {
+				break
+			}
+			name := strings.ToLower(s.token())
+			s.skipSpace()
+			s.pos++ // equal sign
+			s.skipSpace()
+
+			var value string
+			if s.peek() == '"' {
+				v, err := s.quotedString()
+				if err != nil {
+					return nil, err
+				}
+				value = v
+			} else {
+				value = s.token()
+			}
+			if _, ok := c.Params[name]; ok {
+				return nil, fmt.Errorf("duplicate parameter %q in %s challenge", name, scheme)
+			}
+			c.Params[name] = value
+			s.skipSpace()
+			if !s.done() && s.peek() != ',' {
+				return nil, fmt.Errorf("expected comma at %d in challenge", s.pos)
+			}
+		}
+
+		challenges = append(challenges, c)
+	}
+
+	return challenges, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []Challenge
+	}{
+		{
+			name:    "quoted values with commas and equal signs",
+			headers: []string{`Digest realm="a, b=c", nonce="x=y,z", qop="auth, auth-int"`},
+			want: []Challenge{
+				{Scheme: "Digest", Params: map[string]string{"realm": "a, b=c", "nonce": "x=y,z", "qop": "auth, auth-int"}},
+			},
+		},
+		{
+			name:    "escaped quotes",
+			headers: []string{`Digest realm="say \"hi\"", nonce=abc`},
+			want: []Challenge{
+				{Scheme: "Digest", Params: map[string]string{"realm": `say "hi"`, "nonce": "abc"}},
+			},
+		},
+		{
+			name:    "several challenges in one header",
+			headers: []string{`Basic realm="home", Digest realm="home", nonce="1", algorithm=SHA-256, Bearer abc/def==`},
+			want: []Challenge{
+				{Scheme: "Basic", Params: map[string]string{"realm": "home"}},
+				{Scheme: "Digest", Params: map[string]string{"realm": "home", "nonce": "1", "algorithm": "SHA-256"}},
+				{Scheme: "Bearer", Params: map[string]string{}, Token68: "abc/def=="},
+			},
+		},
+		{
+			name:    "several headers",
+			headers: []string{`Digest nonce="1", algorithm=SHA-256`, `Digest nonce="2"`},
+			want: []Challenge{
+				{Scheme: "Digest", Params: map[string]string{"nonce": "1", "algorithm": "SHA-256"}},
+				{Scheme: "Digest", Params: map[string]string{"nonce": "2"}},
+			},
+		},
+		{
+			name:    "parameter names are lower case",
+			headers: []string{`Digest Realm = "home" , NONCE=1`},
+			want: []Challenge{
+				{Scheme: "Digest", Params: map[string]string{"realm": "home", "nonce": "1"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{"Www-Authenticate": test.headers}
+			got, err := ParseChallenges(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseChallengesInvalid(t *testing.T) {
+	for _, v := range []string{
+		`Digest realm="home`,
+		`Digest realm="home\`,
+		`Digest nonce=1, nonce=2`,
+		`Digest realm="home" nonce=1`,
+		`"Digest"`,
+	} {
+		if _, err := ParseChallenges(http.Header{"Www-Authenticate": {v}}); err == nil {
+			t.Errorf("expected an error for %s", v)
+		}
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	got, err := ParseCredentials(`Digest username="Mufasa", uri="/dir/index.html?a=1,2", nc=00000001, qop=auth`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Challenge{Scheme: "Digest", Params: map[string]string{"username": "Mufasa", "uri": "/dir/index.html?a=1,2", "nc": "00000001", "qop": "auth"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	for _, v := range []string{``, `Digest username="a", Basic realm="b"`, `Digest username="a`} {
+		if _, err := ParseCredentials(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
@@ -2,25 +2,43 @@ package auth
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
-	"log"
 	"net/http"
 	"strings"
-	"time"
 )
 
+// digestAlgorithms are the supported hash algorithms, in order of
+// preference.
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SHA-512-256", sha512.New512_256},
+	{"SHA-256", sha256.New},
+	{"MD5", md5.New},
+}
+
+// DigestAuth computes Authorization headers for a digest challenge as
+// described in RFC 7616.
 type DigestAuth struct {
 	realm     string
 	nonce     string
 	opaque    string
 	qop       string
 	algorithm string
-	hash      hash.Hash
+	sess      bool
+	userhash  bool
+	hash      func() hash.Hash
 	username  string
 	password  string
+	// Number of requests made with the current nonce
+	nc uint32
 }
 
 func NewDigestAuth(username string, password string) *DigestAuth {
@@ -30,109 +48,184 @@ func NewDigestAuth(username string, password string) *DigestAuth {
 	}
 }
 
-func (a *DigestAuth) parse(header http.Header) {
-	authHeaders := header.Values("WWW-Authenticate")
-	for _, v := range authHeaders {
-		if !strings.HasPrefix(v, "Digest ") {
+// parse picks the digest challenge in header with the strongest supported
+// algorithm.
+func (a *DigestAuth) parse(header http.Header) error {
+	challenges, err := ParseChallenges(header)
+	if err != nil {
+		return err
+	}
+
+	var best *Challenge
+	bestRank := len(digestAlgorithms)
+	unsupported := []string{}
+	for i, c := range challenges {
+		if !strings.EqualFold(c.Scheme, "Digest") {
 			continue
 		}
-		v = strings.TrimPrefix(v, "Digest ")
-		for _, kv := range strings.Split(v, ",") {
-			kv := strings.Split(strings.TrimSpace(kv), "=")
-			switch kv[0] {
-			case "realm":
-				a.realm = strings.Trim(kv[1], `"`)
-			case "nonce":
-				a.nonce = strings.Trim(kv[1], `"`)
-			case "opaque":
-				a.opaque = strings.Trim(kv[1], `"`)
-			case "qop":
-				a.qop = strings.Trim(kv[1], `"`)
-			case "algorithm":
-				algorithm := strings.Trim(kv[1], `"`)
-				switch algorithm {
-				case "MD5":
-					a.algorithm = "MD5"
-					a.hash = md5.New()
-				case "SHA-256":
-					a.algorithm = "SHA-256"
-					a.hash = sha256.New()
-				default:
-					continue
-				}
+		algorithm := c.Params["algorithm"]
+		if algorithm == "" {
+			// RFC 7616 section 3.3: MD5 is the default
+			algorithm = "MD5"
+		}
+		algorithm = strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")
+		rank := -1
+		for j, alg := range digestAlgorithms {
+			if alg.name == algorithm {
+				rank = j
 			}
 		}
-		break
+		if rank < 0 {
+			unsupported = append(unsupported, c.Params["algorithm"])
+			continue
+		}
+		if rank < bestRank {
+			best, bestRank = &challenges[i], rank
+		}
+	}
+
+	if best == nil {
+		if len(unsupported) > 0 {
+			return fmt.Errorf("unsupported digest algorithm: %s", strings.Join(unsupported, ", "))
+		}
+		return fmt.Errorf("no digest challenge")
+	}
+
+	if best.Params["nonce"] == "" {
+		return fmt.Errorf("digest challenge has no nonce")
+	}
+
+	qop := ""
+	if v, ok := best.Params["qop"]; ok {
+		options := map[string]bool{}
+		for _, o := range strings.Split(v, ",") {
+			options[strings.ToLower(strings.TrimSpace(o))] = true
+		}
+		switch {
+		case options["auth"]:
+			qop = "auth"
+		case options["auth-int"]:
+			qop = "auth-int"
+		default:
+			return fmt.Errorf("unsupported qop %q", v)
+		}
+	}
+
+	algorithm := best.Params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
 	}
-	if a.algorithm == "" {
-		panic("no algorithm")
+
+	*a = DigestAuth{
+		realm:     best.Params["realm"],
+		nonce:     best.Params["nonce"],
+		opaque:    best.Params["opaque"],
+		qop:       qop,
+		algorithm: algorithm,
+		sess:      strings.HasSuffix(strings.ToUpper(algorithm), "-SESS"),
+		userhash:  strings.EqualFold(best.Params["userhash"], "true"),
+		hash:      digestAlgorithms[bestRank].hash,
+		username:  a.username,
+		password:  a.password,
 	}
+
+	return nil
 }
 
-func (a *DigestAuth) HashSum(data string) []byte {
-	a.hash.Reset()
-	io.WriteString(a.hash, data)
-	return a.hash.Sum(nil)
+func (a *DigestAuth) h(data string) string {
+	h := a.hash()
+	io.WriteString(h, data)
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (a *DigestAuth) AuthHeader(req *http.Request) string {
-	A1 := fmt.Sprintf("%s:%s:%s", a.username, a.realm, a.password)
-	A2 := fmt.Sprintf("%s:%s", req.Method, req.URL.Path)
-
-	HA1 := a.HashSum(A1)
-	HA2 := a.HashSum(A2)
-
-	cnonce := a.HashSum(time.Now().String())
-	nc := "00000001"
-
-	rawResponse := fmt.Sprintf(
-		"%x:%s:%s:%x:%s:%x",
-		HA1,
-		a.nonce,
-		nc,
-		cnonce,
-		a.qop,
-		HA2,
-	)
-
-	response := a.HashSum(rawResponse)
-
-	return fmt.Sprintf(
-		`Digest username="%s", realm="%s", nonce="%s", uri="%s", nc=%s, cnonce="%x", qop=%s, response="%x", algorithm=%s, opaque="%s"`,
-		a.username,
-		a.realm,
-		a.nonce,
-		req.URL.Path,
-		nc,
-		cnonce,
-		a.qop,
-		response,
-		a.algorithm,
-		a.opaque,
-	)
+// quote returns s as a quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
 }
 
-func (a *DigestAuth) Request(method string, url string) (*http.Request, error) {
-	log.Printf(`authenticating as user "%s"`, a.username)
-	req, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		return nil, err
+// newCnonce returns a random client nonce. It is a variable so that tests
+// can use the nonces of known responses.
+var newCnonce = func() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AuthHeader returns the value of the Authorization header for req. With
+// qop=auth-int the request body is hashed, which requires req.GetBody to be
+// set if the request has a body.
+func (a *DigestAuth) AuthHeader(req *http.Request) (string, error) {
+	if a.hash == nil {
+		return "", fmt.Errorf("no digest challenge")
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	uri := req.URL.RequestURI()
+	cnonce, err := newCnonce()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+
+	HA1 := a.h(fmt.Sprintf("%s:%s:%s", a.username, a.realm, a.password))
+	if a.sess {
+		HA1 = a.h(fmt.Sprintf("%s:%s:%s", HA1, a.nonce, cnonce))
+	}
+
+	var HA2 string
+	if a.qop == "auth-int" {
+		body := ""
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return "", fmt.Errorf("qop=auth-int requires a request body that can be read twice")
+			}
+			r, err := req.GetBody()
+			if err != nil {
+				return "", err
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return "", err
+			}
+			body = string(b)
+		}
+		HA2 = a.h(fmt.Sprintf("%s:%s:%s", req.Method, uri, a.h(body)))
+	} else {
+		HA2 = a.h(fmt.Sprintf("%s:%s", req.Method, uri))
+	}
+
+	var response string
+	if a.qop == "" {
+		response = a.h(fmt.Sprintf("%s:%s:%s", HA1, a.nonce, HA2))
+	} else {
+		response = a.h(fmt.Sprintf("%s:%s:%s:%s:%s:%s", HA1, a.nonce, nc, cnonce, a.qop, HA2))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusUnauthorized {
-		// Not unauthorized, send back the first request
-		return req, nil
+	username := a.username
+	if a.userhash {
+		username = a.h(fmt.Sprintf("%s:%s", a.username, a.realm))
 	}
 
-	a.parse(resp.Header)
-	req.Header.Set("Authorization", a.AuthHeader(req))
+	params := []string{
+		"username=" + quote(username),
+		"realm=" + quote(a.realm),
+		"nonce=" + quote(a.nonce),
+		"uri=" + quote(uri),
+		"algorithm=" + a.algorithm,
+		"response=" + quote(response),
+	}
+	if a.opaque != "" {
+		params = append(params, "opaque="+quote(a.opaque))
+	}
+	if a.qop != "" {
+		params = append(params, "qop="+a.qop, "nc="+nc, "cnonce="+quote(cnonce))
+	}
+	if a.userhash {
+		params = append(params, "userhash=true")
+	}
 
-	return req, nil
+	return "Digest " + strings.Join(params, ", "), nil
 }
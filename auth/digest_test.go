@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The example of RFC 7616 section 3.9.1
+const (
+	rfcUsername = "Mufasa"
+	rfcPassword = "Circle of Life"
+	rfcRealm    = "http-auth@example.org"
+	rfcNonce    = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	rfcOpaque   = "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"
+	rfcCnonce   = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	rfcURI      = "/dir/index.html"
+)
+
+// rfcChallenge returns the challenge of the example with extra
+// parameters.
+func rfcChallenge(params string) string {
+	return `Digest realm="` + rfcRealm + `", nonce="` + rfcNonce + `", opaque="` + rfcOpaque + `"` + params
+}
+
+// authParams parses the parameters of an Authorization header.
+func authParams(t *testing.T, header string) map[string]string {
+	t.Helper()
+	credentials, err := ParseCredentials(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.Scheme != "Digest" {
+		t.Fatalf("expected digest credentials, got %s", header)
+	}
+	return credentials.Params
+}
+
+func TestDigestAuth(t *testing.T) {
+	defer func(f func() (string, error)) { newCnonce = f }(newCnonce)
+	newCnonce = func() (string, error) { return rfcCnonce, nil }
+
+	tests := []struct {
+		name       string
+		challenges []string
+		method     string
+		body       string
+		want       map[string]string
+	}{
+		{
+			name:       "RFC 7616 SHA-256",
+			challenges: []string{rfcChallenge(`, qop="auth, auth-int", algorithm=SHA-256`), rfcChallenge(`, qop="auth, auth-int", algorithm=MD5`)},
+			method:     "GET",
+			want: map[string]string{
+				"algorithm": "SHA-256",
+				"qop":       "auth",
+				"nc":        "00000001",
+				"response":  "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+			},
+		},
+		{
+			name:       "RFC 7616 MD5",
+			challenges: []string{rfcChallenge(`, qop="auth, auth-int", algorithm=MD5`)},
+			method:     "GET",
+			want: map[string]string{
+				"algorithm": "MD5",
+				"response":  "8ca523f5e9506fed4657c9700eebdbec",
+			},
+		},
+		{
+			name:       "MD5 by default",
+			challenges: []string{rfcChallenge(`, qop="auth"`)},
+			method:     "GET",
+			want: map[string]string{
+				"algorithm": "MD5",
+				"response":  "8ca523f5e9506fed4657c9700eebdbec",
+			},
+		},
+		{
+			name:       "without qop",
+			challenges: []string{rfcChallenge(``)},
+			method:     "GET",
+			want: map[string]string{
+				"algorithm": "MD5",
+				"response":  "7b2cc3b30e75b4777ea31027084363fd",
+			},
+		},
+		{
+			name:       "session algorithm",
+			challenges: []string{rfcChallenge(`, qop="auth", algorithm=MD5-sess`)},
+			method:     "GET",
+			want: map[string]string{
+				"algorithm": "MD5-sess",
+				"response":  "e783283f46242139c486a698fec7211d",
+			},
+		},
+		{
+			name:       "hashed username",
+			challenges: []string{rfcChallenge(`, qop="auth", algorithm=SHA-256, userhash=true`)},
+			method:     "GET",
+			want: map[string]string{
+				"username": "a947aad205e80e429958a387394944c6b496301e79f89d35a4cc23b6ee12b5b6",
+				"userhash": "true",
+				"response": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+			},
+		},
+		{
+			name:       "integrity protection",
+			challenges: []string{rfcChallenge(`, qop="auth-int", algorithm=SHA-256`)},
+			method:     "POST",
+			body:       `{"on":true}`,
+			want: map[string]string{
+				"qop":      "auth-int",
+				"response": "276e7717f526ca845bed09d019ccbfe09a5d2382a295fc2c88f81144a977811a",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := NewDigestAuth(rfcUsername, rfcPassword)
+			if err := a.parse(http.Header{"Www-Authenticate": test.challenges}); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(test.method, "http://www.example.org"+rfcURI, strings.NewReader(test.body))
+			req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(test.body)), nil }
+			header, err := a.AuthHeader(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{"username": rfcUsername, "realm": rfcRealm, "nonce": rfcNonce, "opaque": rfcOpaque, "uri": rfcURI}
+			if test.challenges[0] != rfcChallenge(``) {
+				want["nc"], want["cnonce"] = "00000001", rfcCnonce
+			}
+			maps.Copy(want, test.want)
+			params := authParams(t, header)
+			for name, v := range want {
+				if params[name] != v {
+					t.Errorf("expected %s %q, got %q", name, v, params[name])
+				}
+			}
+		})
+	}
+}
+
+func TestDigestAuthNonceCount(t *testing.T) {
+	a := NewDigestAuth(rfcUsername, rfcPassword)
+	if err := a.parse(http.Header{"Www-Authenticate": {rfcChallenge(`, qop=auth`)}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"00000001", "00000002", "00000003"} {
+		header, err := a.AuthHeader(httptest.NewRequest("GET", rfcURI, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nc := authParams(t, header)["nc"]; nc != want {
+			t.Errorf("expected nc %s, got %s", want, nc)
+		}
+	}
+}
+
+func TestDigestAuthParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		challenge string
+		err       string
+	}{
+		{`Basic realm="home"`, "no digest challenge"},
+		{`Digest realm="home", nonce="1", algorithm=SHA-1`, "unsupported digest algorithm: SHA-1"},
+		{`Digest realm="home"`, "digest challenge has no nonce"},
+		{`Digest realm="home", nonce="1", qop="auth-conf"`, `unsupported qop "auth-conf"`},
+	} {
+		a := NewDigestAuth(rfcUsername, rfcPassword)
+		err := a.parse(http.Header{"Www-Authenticate": {test.challenge}})
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.challenge, test.err, err)
+		}
+	}
+}
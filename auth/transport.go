@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Transport is an http.RoundTripper that adds digest authentication to
+// requests. The challenge from the server is cached, so that only the
+// first request, and requests made after the server has invalidated the
+// nonce, need an extra round-trip.
+type Transport struct {
+	Username string
+	Password string
+
+	// The transport used to make the requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	mutex     sync.Mutex
+	challenge *DigestAuth
+}
+
+func NewTransport(username string, password string) *Transport {
+	return &Transport{
+		Username: username,
+		Password: password,
+	}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// authorize returns a copy of req with an Authorization header for
+// challenge.
+func (t *Transport) authorize(challenge *DigestAuth, req *http.Request) (*http.Request, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	header, err := challenge.AuthHeader(req)
+	if err != nil {
+		return nil, err
+	}
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", header)
+	return authReq, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	challenge := t.challenge
+	t.mutex.Unlock()
+
+	first := req
+	if challenge != nil {
+		var err error
+		if first, err = t.authorize(challenge, req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base().RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Either there was no cached challenge, or the server rejected it
+	// because the nonce is stale. Retry once with the new challenge.
+	newChallenge := NewDigestAuth(t.Username, t.Password)
+	if err := newChallenge.parse(resp.Header); err != nil {
+		// Not a challenge that can be answered, so let the caller deal
+		// with the 401 response.
+		return resp, nil
+	}
+
+	retry := req
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		retry = req.Clone(req.Context())
+		retry.Body = body
+	}
+	resp.Body.Close()
+
+	t.mutex.Lock()
+	t.challenge = newChallenge
+	t.mutex.Unlock()
+
+	authReq, err := t.authorize(newChallenge, retry)
+	if err != nil {
+		return nil, err
+	}
+	return t.base().RoundTrip(authReq)
+}
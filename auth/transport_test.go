@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// digestServer is a server with MD5 digest authentication, which records
+// what the requests it accepts have sent.
+type digestServer struct {
+	t *testing.T
+
+	mutex sync.Mutex
+	nonce int
+	// Nonce counts of accepted requests, by nonce
+	counts map[string][]int
+	// Bodies of accepted requests
+	bodies []string
+	// Number of challenges sent
+	challenges int
+}
+
+func newDigestServer(t *testing.T) (*digestServer, *httptest.Server) {
+	d := &digestServer{t: t, counts: make(map[string][]int)}
+	server := httptest.NewServer(d)
+	t.Cleanup(server.Close)
+	return d, server
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// expire makes the current nonce stale.
+func (d *digestServer) expire() {
+	d.mutex.Lock()
+	d.nonce++
+	d.mutex.Unlock()
+}
+
+func (d *digestServer) challenge(w http.ResponseWriter, stale bool) {
+	d.challenges++
+	v := fmt.Sprintf(`Digest realm="test", nonce="nonce-%d", qop="auth", algorithm=MD5`, d.nonce)
+	if stale {
+		v += ", stale=true"
+	}
+	w.Header().Set("WWW-Authenticate", v)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func (d *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		d.challenge(w, false)
+		return
+	}
+	credentials, err := ParseCredentials(header)
+	if err != nil {
+		d.t.Errorf("invalid Authorization header %q: %v", header, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p := credentials.Params
+
+	HA1 := md5Hex("user:test:secret")
+	HA2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+	response := md5Hex(strings.Join([]string{HA1, p["nonce"], p["nc"], p["cnonce"], p["qop"], HA2}, ":"))
+	if p["username"] != "user" || p["uri"] != r.URL.RequestURI() || p["response"] != response {
+		d.t.Errorf("wrong credentials in %q", header)
+		d.challenge(w, false)
+		return
+	}
+	if p["nonce"] != fmt.Sprintf("nonce-%d", d.nonce) {
+		d.challenge(w, true)
+		return
+	}
+	nc, err := strconv.ParseInt(p["nc"], 16, 64)
+	if counts := d.counts[p["nonce"]]; err != nil || len(counts) > 0 && int(nc) <= counts[len(counts)-1] {
+		d.t.Errorf("nonce count %s isn't increasing after %v", p["nc"], counts)
+	}
+	d.counts[p["nonce"]] = append(d.counts[p["nonce"]], int(nc))
+	body, _ := io.ReadAll(r.Body)
+	d.bodies = append(d.bodies, string(body))
+}
+
+func newTestClient() *http.Client {
+	return &http.Client{Transport: NewTransport("user", "secret")}
+}
+
+func get(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestTransportNonceCount(t *testing.T) {
+	d, server := newDigestServer(t)
+	client := newTestClient()
+
+	for i := 0; i < 3; i++ {
+		get(t, client, server.URL+"/nodes")
+	}
+
+	// Only the first request is challenged, and the cached challenge is
+	// answered with increasing nonce counts
+	if d.challenges != 1 {
+		t.Errorf("expected 1 challenge, got %d", d.challenges)
+	}
+	if counts := fmt.Sprint(d.counts["nonce-0"]); counts != "[1 2 3]" {
+		t.Errorf("expected nonce counts [1 2 3], got %s", counts)
+	}
+}
+
+func TestTransportStaleNonce(t *testing.T) {
+	d, server := newDigestServer(t)
+	client := newTestClient()
+
+	get(t, client, server.URL+"/nodes")
+	get(t, client, server.URL+"/nodes")
+	d.expire()
+	get(t, client, server.URL+"/nodes")
+	get(t, client, server.URL+"/nodes")
+
+	if d.challenges != 2 {
+		t.Errorf("expected 2 challenges, got %d", d.challenges)
+	}
+	if counts := fmt.Sprint(d.counts["nonce-1"]); counts != "[1 2]" {
+		t.Errorf("expected nonce counts [1 2] for the new nonce, got %s", counts)
+	}
+}
+
+func TestTransportReplaysBody(t *testing.T) {
+	d, server := newDigestServer(t)
+	client := newTestClient()
+
+	for _, body := range []string{`{"on": true}`, `{"on": false}`} {
+		resp, err := client.Post(server.URL+"/nodes/1/call", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		d.expire()
+	}
+
+	if bodies := strings.Join(d.bodies, " "); bodies != `{"on": true} {"on": false}` {
+		t.Errorf("expected the bodies to be sent after the challenges, got %s", bodies)
+	}
+}
+
+func TestTransportBodyWithoutGetBody(t *testing.T) {
+	_, server := newDigestServer(t)
+	client := newTestClient()
+
+	// A body that can't be read again isn't retried, and the caller gets
+	// the challenge
+	req, err := http.NewRequest("POST", server.URL+"/nodes/1/call", io.NopCloser(strings.NewReader("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
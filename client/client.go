@@ -0,0 +1,342 @@
+// Package client is a Go client of the goblin JSON API, as described by the
+// OpenAPI document served at /api/openapi.json. Errors returned by the API
+// are returned as *goblin.Error, so goblin.ErrorCode works on them.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+)
+
+type Client struct {
+	// URL of the goblin server, such as http://localhost:3000
+	BaseURL *url.URL
+
+	HTTPClient *http.Client
+}
+
+func NewClient(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid goblin url %q", baseURL)
+	}
+	return &Client{
+		BaseURL:    u,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// errorResponse is the error envelope of the API.
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// do sends a request to path, relative to /api/v1, with body encoded as
+// JSON unless it is nil. The JSON response is decoded into v unless v is
+// nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, v interface{}) error {
+	u := c.BaseURL.JoinPath("api/v1", path)
+	u.RawQuery = query.Encode()
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error.Code == "" {
+			return fmt.Errorf("%s %s: %s", method, u.Path, resp.Status)
+		}
+		return &goblin.Error{Code: e.Error.Code, Message: e.Error.Message}
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// setPage adds the ordering, offset and limit of a filter to query.
+func setPage(query url.Values, orderBy string, offset, limit int) {
+	if orderBy != "" {
+		query.Set("order", orderBy)
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+}
+
+// FindRooms returns the rooms matching filter, with their sensors. The id
+// of the filter is not supported, use Room instead.
+func (c *Client) FindRooms(ctx context.Context, filter goblin.RoomFilter) ([]*goblin.Room, error) {
+	query := url.Values{}
+	if v := filter.Name; v != nil {
+		query.Set("name", *v)
+	}
+	setPage(query, filter.OrderBy, filter.Offset, filter.Limit)
+
+	var resp struct {
+		Rooms []*goblin.Room `json:"rooms"`
+	}
+	if err := c.do(ctx, http.MethodGet, "rooms", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Rooms, nil
+}
+
+func (c *Client) Room(ctx context.Context, id string) (*goblin.Room, error) {
+	var room goblin.Room
+	if err := c.do(ctx, http.MethodGet, "rooms/"+url.PathEscape(id), nil, nil, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// CreateRoom creates a room. It fails with goblin.ECONFLICT if the room
+// already exists.
+func (c *Client) CreateRoom(ctx context.Context, id, name string) (*goblin.Room, error) {
+	body := struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}{id, name}
+
+	var room goblin.Room
+	if err := c.do(ctx, http.MethodPost, "rooms", nil, body, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (c *Client) UpdateRoom(ctx context.Context, id string, update goblin.RoomUpdate) (*goblin.Room, error) {
+	body := struct {
+		Name *string `json:"name,omitempty"`
+	}{update.Name}
+
+	var room goblin.Room
+	if err := c.do(ctx, http.MethodPatch, "rooms/"+url.PathEscape(id), nil, body, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (c *Client) DeleteRoom(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "rooms/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// FindSensors returns the sensors matching filter. The id of the filter is
+// not supported, use Sensor instead.
+func (c *Client) FindSensors(ctx context.Context, filter goblin.SensorFilter) ([]*goblin.Sensor, error) {
+	query := url.Values{}
+	if v := filter.RoomId; v != nil {
+		query.Set("room", *v)
+	}
+	if v := filter.Removed; v != nil {
+		query.Set("removed", strconv.FormatBool(*v))
+	}
+	setPage(query, filter.OrderBy, filter.Offset, filter.Limit)
+
+	var resp struct {
+		Sensors []*goblin.Sensor `json:"sensors"`
+	}
+	if err := c.do(ctx, http.MethodGet, "sensors", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sensors, nil
+}
+
+func (c *Client) Sensor(ctx context.Context, id string) (*goblin.Sensor, error) {
+	var sensor goblin.Sensor
+	if err := c.do(ctx, http.MethodGet, "sensors/"+url.PathEscape(id), nil, nil, &sensor); err != nil {
+		return nil, err
+	}
+	return &sensor, nil
+}
+
+func (c *Client) UpdateSensor(ctx context.Context, id string, update goblin.SensorUpdate) (*goblin.Sensor, error) {
+	body := struct {
+		Name   *string `json:"name,omitempty"`
+		RoomId *string `json:"roomId,omitempty"`
+	}{update.Name, update.RoomId}
+
+	var sensor goblin.Sensor
+	if err := c.do(ctx, http.MethodPatch, "sensors/"+url.PathEscape(id), nil, body, &sensor); err != nil {
+		return nil, err
+	}
+	return &sensor, nil
+}
+
+func (c *Client) DeleteSensor(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "sensors/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// Nodes returns the nodes of the Nexa Bridge with their current state.
+func (c *Client) Nodes(ctx context.Context) (nexa.NexaNodes, error) {
+	var resp struct {
+		Nodes nexa.NexaNodes `json:"nodes"`
+	}
+	if err := c.do(ctx, http.MethodGet, "nodes", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+func (c *Client) Node(ctx context.Context, id string) (*nexa.NexaNode, error) {
+	var node nexa.NexaNode
+	if err := c.do(ctx, http.MethodGet, "nodes/"+url.PathEscape(id), nil, nil, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// SetSwitch turns a node with the switchBinary capability on or off.
+func (c *Client) SetSwitch(ctx context.Context, id string, on bool) error {
+	body := struct {
+		On bool `json:"on"`
+	}{on}
+	return c.do(ctx, http.MethodPost, "nodes/"+url.PathEscape(id)+"/switch", nil, body, nil)
+}
+
+// SetLevel sets the level of a node with the switchLevel capability. The
+// level must be between 0 and 1.
+func (c *Client) SetLevel(ctx context.Context, id string, level float64) error {
+	body := struct {
+		Level float64 `json:"level"`
+	}{level}
+	return c.do(ctx, http.MethodPost, "nodes/"+url.PathEscape(id)+"/level", nil, body, nil)
+}
+
+// readingQuery returns the query parameters of filter.
+func readingQuery(filter goblin.ReadingFilter) (url.Values, error) {
+	query := url.Values{}
+	sensors, capabilities := slices.Clone(filter.SensorIds), slices.Clone(filter.Capabilities)
+	if v := filter.SensorId; v != nil {
+		sensors = append(sensors, *v)
+	}
+	if v := filter.Capability; v != nil {
+		capabilities = append(capabilities, *v)
+	}
+	if len(sensors) > 0 {
+		query.Set("sensor", strings.Join(sensors, ","))
+	}
+	if len(filter.RoomIds) > 0 {
+		query.Set("room", strings.Join(filter.RoomIds, ","))
+	}
+	if len(capabilities) > 0 {
+		query.Set("capability", strings.Join(capabilities, ","))
+	}
+	if v := filter.From; v != nil {
+		query.Set("from", v.Format(time.RFC3339Nano))
+	}
+	if v := filter.To; v != nil {
+		query.Set("to", v.Format(time.RFC3339Nano))
+	}
+	if v := filter.Resolution; v != nil {
+		if *v == 0 {
+			query.Set("window", "raw")
+		} else if *v < time.Second || *v%time.Second != 0 {
+			return nil, goblin.Errorf(goblin.EINVALID, "unsupported resolution %s", *v)
+		} else {
+			query.Set("window", fmt.Sprintf("%ds", *v/time.Second))
+		}
+	}
+	return query, nil
+}
+
+// FindReadings returns the readings matching filter. Both SensorId and
+// SensorIds, and Capability and Capabilities, are combined into lists that
+// match any of their values, and an empty list doesn't filter.
+func (c *Client) FindReadings(ctx context.Context, filter goblin.ReadingFilter) ([]*goblin.Reading, error) {
+	query, err := readingQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Readings   []*goblin.Reading `json:"readings"`
+		Resolution *int64            `json:"resolution"`
+	}
+	if err := c.do(ctx, http.MethodGet, "readings", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Resolution != nil {
+		for _, reading := range resp.Readings {
+			reading.Resolution = time.Duration(*resp.Resolution) * time.Second
+		}
+	}
+	return resp.Readings, nil
+}
+
+// FindEvents returns the events matching filter, and the number of events
+// that match it when ignoring its offset and limit. The API returns at
+// most 100 events unless a limit is given. The id of the filter is not
+// supported.
+func (c *Client) FindEvents(ctx context.Context, filter goblin.EventFilter) ([]*goblin.Event, int, error) {
+	query := url.Values{}
+	for name, v := range map[string]*string{
+		"sensor":     filter.SensorId,
+		"room":       filter.RoomId,
+		"capability": filter.Capability,
+		"value":      filter.Value,
+	} {
+		if v != nil {
+			query.Set(name, *v)
+		}
+	}
+	if v := filter.From; v != nil {
+		query.Set("from", v.Format(time.RFC3339Nano))
+	}
+	if v := filter.To; v != nil {
+		query.Set("to", v.Format(time.RFC3339Nano))
+	}
+	setPage(query, filter.OrderBy, filter.Offset, filter.Limit)
+
+	var resp struct {
+		Events []*goblin.Event `json:"events"`
+		N      int             `json:"n"`
+	}
+	if err := c.do(ctx, http.MethodGet, "events", query, nil, &resp); err != nil {
+		return nil, 0, err
+	}
+	return resp.Events, resp.N, nil
+}
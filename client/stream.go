@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+	"nhooyr.io/websocket"
+)
+
+// StreamFilter selects the messages of a subscription to the stream. Empty
+// lists match any value, and a message matches if its node is among the
+// nodes or is a sensor in one of the rooms when the message arrives.
+// Sensors moved by the inventory sync of the Nexa Bridge may match their
+// old room for up to a minute.
+type StreamFilter struct {
+	Nodes        []string `json:"nodes,omitempty"`
+	Rooms        []string `json:"rooms,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	SystemTypes  []string `json:"systemTypes,omitempty"`
+}
+
+// StreamFrame is a frame sent by the stream: hello, event, subscribed,
+// unsubscribed or error.
+type StreamFrame struct {
+	Type string    `json:"type"`
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Subscription that the frame responds to
+	Id string `json:"id"`
+	// Subscriptions that the message of an event matches
+	Subscriptions []string      `json:"subscriptions"`
+	Message       *nexa.Message `json:"message"`
+	Error         *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Stream is a websocket of messages from the Nexa Bridge, as JSON. It has
+// no subscriptions when it opens.
+type Stream struct {
+	conn *websocket.Conn
+}
+
+// Stream opens a stream of messages from the Nexa Bridge.
+func (c *Client) Stream(ctx context.Context) (*Stream, error) {
+	u := c.BaseURL.JoinPath("api/v1/stream")
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	// The stream is open for longer than the timeout of requests, and is
+	// canceled with ctx instead
+	var httpClient *http.Client
+	if c.HTTPClient != nil {
+		hc := *c.HTTPClient
+		hc.Timeout = 0
+		httpClient = &hc
+	}
+	conn, _, err := websocket.Dial(ctx, u.String(), &websocket.DialOptions{HTTPClient: httpClient})
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{conn}, nil
+}
+
+func (s *Stream) write(ctx context.Context, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.conn.Write(ctx, websocket.MessageText, b)
+}
+
+// Subscribe adds a subscription to the messages matching filter, or
+// replaces the subscription with the same id. The stream responds with a
+// subscribed frame.
+func (s *Stream) Subscribe(ctx context.Context, id string, filter StreamFilter) error {
+	return s.write(ctx, struct {
+		Type   string       `json:"type"`
+		Id     string       `json:"id"`
+		Filter StreamFilter `json:"filter"`
+	}{"subscribe", id, filter})
+}
+
+// Unsubscribe removes a subscription. The stream responds with an
+// unsubscribed frame.
+func (s *Stream) Unsubscribe(ctx context.Context, id string) error {
+	return s.write(ctx, struct {
+		Type string `json:"type"`
+		Id   string `json:"id"`
+	}{"unsubscribe", id})
+}
+
+// Next returns the next frame of the stream. Error frames are returned
+// along with their error as a *goblin.Error, and the stream can still be
+// read after them.
+func (s *Stream) Next(ctx context.Context) (*StreamFrame, error) {
+	_, data, err := s.conn.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var frame StreamFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, fmt.Errorf("invalid stream frame: %w", err)
+	}
+	if e := frame.Error; e != nil {
+		return &frame, &goblin.Error{Code: e.Code, Message: e.Message}
+	}
+	return &frame, nil
+}
+
+func (s *Stream) Close() error {
+	return s.conn.Close(websocket.StatusNormalClosure, "")
+}
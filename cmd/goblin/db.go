@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/maehler/goblin/sqlite"
+	"github.com/spf13/viper"
+)
+
+func database(args []string) error {
+	flags := flag.NewFlagSet("db", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the backup to, instead of the backup directory")
+	noBackup := flags.Bool("no-backup", false, "don't back up the current database before restoring")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: goblin db backup [-o file]")
+		fmt.Fprintln(flags.Output(), "       goblin db restore [-no-backup] <file>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "  backup    back up the database while it is in use")
+		fmt.Fprintln(flags.Output(), "  restore   replace the database with a backup; stop goblin first")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Backups are written to backup.dir unless -o is given. Before restoring, the")
+		fmt.Fprintln(flags.Output(), "current database is backed up to backup.dir if it is set.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	if len(args) < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	flags.Parse(args[1:])
+
+	if err := config(); err != nil {
+		return err
+	}
+	db := sqlite.NewDatabase(viper.GetString("sqlite_dsn"))
+	if err := db.Connect(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	backups := sqlite.NewBackups(db, viper.GetString("backup.dir"), viper.GetInt("backup.keep"))
+
+	switch command {
+	case "backup":
+		if *output != "" {
+			if err := db.Backup(ctx, *output); err != nil {
+				return err
+			}
+			fmt.Println(*output)
+			return nil
+		}
+		if backups.Dir == "" {
+			return fmt.Errorf("backup.dir is not set, use -o to choose a file")
+		}
+		path, err := backups.Create(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	case "restore":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		if backups.Dir != "" && !*noBackup {
+			path, err := backups.Create(ctx)
+			if err != nil {
+				return fmt.Errorf("back up current database: %w", err)
+			}
+			fmt.Printf("backed up current database to %s\n", path)
+		}
+		if err := db.Restore(ctx, flags.Arg(0)); err != nil {
+			return err
+		}
+		if err := db.MigrateUp(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("restored %s\n", flags.Arg(0))
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return nil
+}
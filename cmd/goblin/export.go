@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/export"
+	"github.com/maehler/goblin/sqlite"
+	"github.com/spf13/viper"
+)
+
+func exportReadings(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "csv", "output format, csv or jsonl")
+	output := flags.String("o", "", "file to write to instead of stdout")
+	sensors := flags.String("sensor", "", "comma-separated sensor ids")
+	rooms := flags.String("room", "", "comma-separated room ids")
+	capabilities := flags.String("capability", "", "comma-separated capabilities")
+	from := flags.String("from", "", "start time, RFC 3339 or YYYY-MM-DD")
+	to := flags.String("to", "", "end time (exclusive), RFC 3339 or YYYY-MM-DD")
+	window := flags.String("window", "", "aggregate readings into windows of this length, e.g. 15m, 1h or 1d")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: goblin export [flags]")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	filter := goblin.ReadingFilter{
+		SensorIds:    export.SplitList(*sensors),
+		RoomIds:      export.SplitList(*rooms),
+		Capabilities: export.SplitList(*capabilities),
+	}
+	if *from != "" {
+		t, err := export.ParseTime(*from)
+		if err != nil {
+			return err
+		}
+		filter.From = &t
+	}
+	if *to != "" {
+		t, err := export.ParseTime(*to)
+		if err != nil {
+			return err
+		}
+		filter.To = &t
+	}
+	var w time.Duration
+	if *window != "" {
+		if w, err = export.ParseWindow(*window); err != nil {
+			return err
+		}
+	}
+	filter.Resolution = &w
+
+	if err := config(); err != nil {
+		return err
+	}
+	db := sqlite.NewDatabase(viper.GetString("sqlite_dsn"))
+	if err := db.Open(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	buf := bufio.NewWriter(out)
+
+	writer := export.NewWriter(buf, f, w > 0)
+	err = sqlite.NewReadingService(db).StreamReadings(context.Background(), filter, writer.Write)
+	if err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/maehler/goblin/nexa/fakebridge"
+)
+
+func fakeBridge(args []string) error {
+	flags := flag.NewFlagSet("fakebridge", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8000", "address of the REST API")
+	socketPort := flags.Int("socket-port", 8887, "port of the websocket")
+	discoveryAddr := flags.String("discovery", "", "address to answer discovery requests on, e.g. 0.0.0.0:43233")
+	fixturesPath := flags.String("fixtures", "", "JSON file with rooms, nodes and scripted events")
+	interval := flags.Duration("interval", 5*time.Second, "interval between random events, 0 to disable")
+	username := flags.String("username", "nexa", "username for digest authentication")
+	password := flags.String("password", "nexa", "password for digest authentication")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: goblin fakebridge [flags]")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Point goblin at the bridge by setting nexa.address to the REST address and")
+		fmt.Fprintln(flags.Output(), "nexa.socket_port to the websocket port.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	fixtures := fakebridge.DefaultFixtures()
+	if *fixturesPath != "" {
+		var err error
+		if fixtures, err = fakebridge.LoadFixtures(*fixturesPath); err != nil {
+			return err
+		}
+	}
+
+	bridge := fakebridge.New(fixtures, *username, *password)
+	ctx := context.Background()
+
+	if *discoveryAddr != "" {
+		go func() {
+			if err := fakebridge.ServeDiscovery(ctx, *discoveryAddr); err != nil {
+				log.Println("fakebridge discovery:", err.Error())
+			}
+		}()
+	}
+
+	if len(fixtures.Events) > 0 {
+		go func() {
+			if err := bridge.Play(ctx, fixtures.Events); err != nil {
+				log.Println("fakebridge script:", err.Error())
+			}
+		}()
+	}
+
+	if *interval > 0 {
+		go bridge.RunRandom(ctx, *interval)
+	}
+
+	socketAddr := fmt.Sprintf(":%d", *socketPort)
+	go func() {
+		log.Printf("fake Nexa websocket listening on %s", socketAddr)
+		if err := http.ListenAndServe(socketAddr, bridge.SocketHandler()); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Printf("fake Nexa Bridge listening on %s", *addr)
+	return http.ListenAndServe(*addr, bridge.Handler())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/maehler/goblin/importer"
+	"github.com/maehler/goblin/sqlite"
+	"github.com/spf13/viper"
+)
+
+func importReadings(args []string) error {
+	m := importer.DefaultMapping()
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.StringVar(&m.Time, "time-column", m.Time, "column with the time of the reading")
+	flags.StringVar(&m.SensorId, "sensor-column", m.SensorId, "column with the sensor id")
+	flags.StringVar(&m.Capability, "capability-column", m.Capability, "column with the capability")
+	flags.StringVar(&m.Value, "value-column", m.Value, "column with the value")
+	flags.StringVar(&m.SensorName, "sensor-name-column", m.SensorName, "column with the name of new sensors")
+	flags.StringVar(&m.Room, "room-column", m.Room, "column with the id or name of the room of new sensors")
+	flags.StringVar(&m.DefaultSensorId, "sensor", "", "sensor id for files without a sensor column")
+	flags.StringVar(&m.DefaultCapability, "capability", "", "capability for files without a capability column")
+	flags.StringVar(&m.TimeFormat, "time-format", "", "Go layout of the time column, by default RFC 3339 and similar formats are detected")
+	timezone := flags.String("timezone", "Local", "time zone of times without one, e.g. Europe/Stockholm")
+	delimiter := flags.String("delimiter", ",", `field delimiter, use \t for tabs`)
+	dryRun := flags.Bool("dry-run", false, "show what would be imported without storing anything")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: goblin import [flags] <file.csv | ->")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Columns are matched by their header, case-insensitively. Readings that")
+		fmt.Fprintln(flags.Output(), "are already stored are skipped, and missing sensors and rooms are created.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q", *timezone)
+	}
+	m.Location = location
+
+	if *delimiter == `\t` {
+		*delimiter = "\t"
+	}
+	if utf8.RuneCountInString(*delimiter) != 1 {
+		return fmt.Errorf("delimiter must be a single character")
+	}
+	m.Comma, _ = utf8.DecodeRuneInString(*delimiter)
+
+	var in io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	if err := config(); err != nil {
+		return err
+	}
+	db := sqlite.NewDatabase(viper.GetString("sqlite_dsn"))
+	if err := db.Open(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	imp := importer.NewImporter(
+		sqlite.NewRoomService(db),
+		sqlite.NewSensorService(db),
+		sqlite.NewReadingService(db),
+	)
+	result, err := imp.Import(context.Background(), in, m, *dryRun)
+	if result != nil {
+		verb := "imported"
+		if result.DryRun {
+			verb = "would import"
+		}
+		fmt.Printf("read %d rows, %s %d readings, skipped %d duplicates\n", result.Rows, verb, result.Imported, result.Duplicates)
+		if len(result.CreatedSensors) > 0 {
+			fmt.Printf("new sensors: %s\n", strings.Join(result.CreatedSensors, ", "))
+		}
+		if len(result.CreatedRooms) > 0 {
+			fmt.Printf("new rooms: %s\n", strings.Join(result.CreatedRooms, ", "))
+		}
+	}
+	return err
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/maehler/goblin/http"
 	"github.com/maehler/goblin/nexa"
@@ -26,8 +33,19 @@ func config() error {
 	viper.SetDefault("nexa.socket_port", 8887)
 	viper.SetDefault("nexa.username", "nexa")
 	viper.SetDefault("nexa.password", "nexa")
+	viper.SetDefault("nexa.sync_interval", time.Hour)
 	viper.SetDefault("home_name", "goblin")
+	viper.SetDefault("transport", "ws")
 	viper.SetDefault("sqlite_dsn", "file:goblin.db")
+	viper.SetDefault("backup.interval", 24*time.Hour)
+	viper.SetDefault("backup.keep", 7)
+	viper.SetDefault("retention.raw.default", "30d")
+	viper.SetDefault("retention.rollups.5m", "90d")
+	viper.SetDefault("retention.rollups.1h", "2y")
+	viper.SetDefault("retention.rollups.1d", "0")
+	viper.SetDefault("writer.batch_size", 500)
+	viper.SetDefault("writer.interval", 5*time.Second)
+	viper.SetDefault("writer.queue_size", 10000)
 
 	viper.SetEnvPrefix("goblin")
 	viper.MustBindEnv("home_name")
@@ -35,49 +53,216 @@ func config() error {
 	viper.MustBindEnv("port")
 	viper.MustBindEnv("sqlite_dsn")
 
-	nexaIP, err := nexa.IdentifyNexa()
-	if err != nil {
+	if err := viper.ReadInConfig(); err != nil {
 		return err
 	}
-	log.Printf("detected Nexa at %s", nexaIP)
-	viper.SetDefault("nexa.address", nexaIP)
 
-	return viper.ReadInConfig()
+	return nil
+}
+
+// identifyNexa looks for the Nexa Bridge on the network unless its address
+// is configured.
+func identifyNexa() error {
+	if viper.GetString("nexa.address") == "" {
+		nexaIP, err := nexa.IdentifyNexa()
+		if err != nil {
+			return err
+		}
+		log.Printf("detected Nexa at %s", nexaIP)
+		viper.Set("nexa.address", nexaIP)
+	}
+
+	return nil
 }
 
-// TODO: save temperature and humidity to the database
+const usage = `usage: goblin [command] [flags]
+
+commands:
+  serve        run the goblin server (default)
+  migrate      show, apply or revert database migrations
+  db           back up or restore the database
+  export       export readings as CSV or JSON Lines
+  import       import readings from a CSV file
+  fakebridge   run a simulated Nexa Bridge
+`
 
 func main() {
-	if err := config(); err != nil {
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = serve()
+	case "migrate":
+		err = migrate(args)
+	case "db":
+		err = database(args)
+	case "export":
+		err = exportReadings(args)
+	case "import":
+		err = importReadings(args)
+	case "fakebridge":
+		err = fakeBridge(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+func serve() error {
+	if err := config(); err != nil {
+		return err
+	}
 	log.Printf("using config file %s", viper.ConfigFileUsed())
+
+	// The configuration is validated before anything is started
+	retention, err := retentionPolicy()
+	if err != nil {
+		return err
+	}
+	writerInterval := viper.GetDuration("writer.interval")
+	if writerInterval <= 0 {
+		return fmt.Errorf("writer interval must be positive")
+	}
+	backupDir := viper.GetString("backup.dir")
+	backupInterval := viper.GetDuration("backup.interval")
+	if backupDir != "" && backupInterval <= 0 {
+		return fmt.Errorf("backup interval must be positive")
+	}
+
+	if err := identifyNexa(); err != nil {
+		return err
+	}
 	db := sqlite.NewDatabase(viper.GetString("sqlite_dsn"))
 	if err := db.Open(); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	log.Printf("connecting to Nexa at %s", viper.GetString("nexa.address"))
 
+	// The address may include the port of the REST API, which is not
+	// used for the websocket
+	nexaHost := viper.GetString("nexa.address")
+	if host, _, err := net.SplitHostPort(nexaHost); err == nil {
+		nexaHost = host
+	}
+
 	nexaConfig := nexa.NewNexaConfig()
 	nexaConfig.Username = viper.GetString("nexa.username")
 	nexaConfig.Password = viper.GetString("nexa.password")
-	nexaConfig.WebsocketHost = viper.GetString("nexa.address")
+	nexaConfig.WebsocketHost = nexaHost
 	nexaConfig.WebsocketPort = viper.GetInt("nexa.socket_port")
 	nxa := nexa.NewNexa(nexaConfig)
-	go nxa.InitSockets()
+	go nxa.Run(context.Background())
 
 	server := http.NewServer(
 		http.WithName(viper.GetString("home_name")),
 		http.WithHost(viper.GetString("host")),
 		http.WithPort(viper.GetInt("port")),
+		http.WithTransport(viper.GetString("transport")),
 	)
 
-	server.RoomService = sqlite.NewRoomService(db)
-	server.SensorService = sqlite.NewSensorService(db)
-	server.NexaService = nexa.NewNexaService(nxa)
+	nexaService := nexa.NewNexaService(nxa)
+	roomService := sqlite.NewRoomService(db)
+	sensorService := sqlite.NewSensorService(db)
+	readingService := sqlite.NewReadingService(db)
 
-	if err := server.Serve(); err != nil {
-		log.Fatal(err)
+	server.RoomService = roomService
+	server.SensorService = sensorService
+	server.ReadingService = readingService
+	server.EventService = sqlite.NewEventService(db)
+	server.NexaService = nexaService
+
+	syncInterval := viper.GetDuration("nexa.sync_interval")
+	if syncInterval <= 0 {
+		return fmt.Errorf("nexa sync interval must be positive")
+	}
+	inventory := nexa.NewInventory(&nexaService, roomService, sensorService)
+	go inventory.Run(context.Background(), nxa, syncInterval)
+
+	server.Messages = nxa.Bus.Subscribe().C
+
+	// Readings and events from the Nexa Bridge are written in batches,
+	// and the last batch is written when goblin is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	writer := sqlite.NewWriter(db)
+	writer.BatchSize = viper.GetInt("writer.batch_size")
+	writer.Interval = writerInterval
+	writer.QueueSize = viper.GetInt("writer.queue_size")
+	written := make(chan struct{})
+	go func() {
+		writer.Run(ctx)
+		close(written)
+	}()
+
+	// The recorders stop with the writer, and are waited for before the
+	// database is closed. What they record after the writer's final batch
+	// is written immediately.
+	var recording sync.WaitGroup
+	recorder := nexa.NewRecorder(writer, sensorService)
+	recordings := nxa.Bus.Subscribe(
+		nexa.WithFilter(nexa.IsReading),
+		nexa.WithBufferSize(1024),
+	)
+	recording.Add(1)
+	go func() {
+		defer recording.Done()
+		recorder.Run(ctx, recordings.C)
+	}()
+
+	eventRecorder := nexa.NewEventRecorder(writer, sensorService)
+	events := nxa.Bus.Subscribe(
+		nexa.WithFilter(nexa.IsEvent),
+		nexa.WithBufferSize(1024),
+	)
+	recording.Add(1)
+	go func() {
+		defer recording.Done()
+		eventRecorder.Run(ctx, events.C)
+	}()
+
+	downsampler := sqlite.NewDownsampler(db, retention)
+	go downsampler.Run(context.Background(), 5*time.Minute)
+
+	if backupDir != "" {
+		backups := sqlite.NewBackups(db, backupDir, viper.GetInt("backup.keep"))
+		go backups.Run(context.Background(), backupInterval)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve()
+	}()
+
+	select {
+	case err := <-errs:
+		stop()
+		recording.Wait()
+		<-written
+		db.Close()
+		return err
+	case <-ctx.Done():
+		log.Println("shutting down")
+		recording.Wait()
+		<-written
+		stats := writer.Stats()
+		log.Printf("wrote %d readings and events in %d batches, %d failed, %d dropped and %d still queued", stats.Written, stats.Batches, stats.Failed, stats.Dropped, stats.Queued)
+		hubStats := server.HubStats()
+		log.Printf("sent %d messages to %d websocket subscribers, %d evicted and %d still connected", hubStats.Messages, hubStats.Subscribed, hubStats.Evicted, hubStats.Connected)
+		streamStats := server.StreamStats()
+		log.Printf("sent %d messages to %d stream clients, %d evicted and %d still connected", streamStats.Messages, streamStats.Subscribed, streamStats.Evicted, streamStats.Connected)
+		return db.Close()
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/maehler/goblin/sqlite"
+	"github.com/spf13/viper"
+)
+
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := flags.Int("n", 1, "number of migrations to revert with down")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: goblin migrate status|up|down [-n steps]")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "  status   list migrations and whether they have been applied")
+		fmt.Fprintln(flags.Output(), "  up       apply all pending migrations")
+		fmt.Fprintln(flags.Output(), "  down     revert the most recently applied migrations")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	if len(args) < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	flags.Parse(args[1:])
+
+	if err := config(); err != nil {
+		return err
+	}
+	db := sqlite.NewDatabase(viper.GetString("sqlite_dsn"))
+	if err := db.Connect(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch command {
+	case "status":
+		return migrationStatus(ctx, db)
+	case "up":
+		if err := db.MigrateUp(ctx); err != nil {
+			return err
+		}
+	case "down":
+		if err := db.MigrateDown(ctx, *steps); err != nil {
+			return err
+		}
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return migrationStatus(ctx, db)
+}
+
+func migrationStatus(ctx context.Context, db *sqlite.DB) error {
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tSTATUS\tAPPLIED AT\tREVERSIBLE")
+	for _, m := range migrations {
+		status, appliedAt := "applied", "-"
+		switch {
+		case m.Pending():
+			status = "pending"
+		case m.Unknown():
+			status = "unknown"
+		case m.Modified():
+			status = "modified"
+		}
+		if !m.Pending() && !m.AppliedAt.IsZero() {
+			appliedAt = m.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		reversible := "no"
+		if m.Down != "" {
+			reversible = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, status, appliedAt, reversible)
+	}
+
+	return w.Flush()
+}
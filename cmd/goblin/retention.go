@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin/sqlite"
+	"github.com/spf13/viper"
+)
+
+// parseRetention parses a duration that, in addition to the units of
+// time.ParseDuration, may be given in whole days (d), weeks (w) or years
+// (y). Zero keeps readings forever.
+func parseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, fmt.Errorf("invalid retention %q", s)
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q", s)
+	}
+	return d, nil
+}
+
+// resolutionKey returns the config key of a rollup resolution, such as 5m,
+// 1h or 1d.
+func resolutionKey(res time.Duration) string {
+	switch {
+	case res%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", res/(24*time.Hour))
+	case res%time.Hour == 0:
+		return fmt.Sprintf("%dh", res/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", res/time.Minute)
+	}
+}
+
+// retentionPolicy reads the retention policy from the retention section of
+// the config.
+func retentionPolicy() (sqlite.RetentionPolicy, error) {
+	policy := sqlite.RetentionPolicy{
+		Raw:     make(map[string]time.Duration),
+		Rollups: make(map[time.Duration]time.Duration),
+	}
+
+	var err error
+	if policy.DefaultRaw, err = parseRetention(viper.GetString("retention.raw.default")); err != nil {
+		return policy, err
+	}
+	for capability := range viper.GetStringMap("retention.raw") {
+		if capability == "default" {
+			continue
+		}
+		if policy.Raw[capability], err = parseRetention(viper.GetString("retention.raw." + capability)); err != nil {
+			return policy, err
+		}
+	}
+
+	for _, res := range sqlite.Resolutions {
+		if policy.Rollups[res], err = parseRetention(viper.GetString("retention.rollups." + resolutionKey(res))); err != nil {
+			return policy, err
+		}
+	}
+
+	return policy, nil
+}
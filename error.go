@@ -0,0 +1,60 @@
+package goblin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes of the errors returned by the services. They are independent
+// of the implementation, so that callers such as the HTTP API can tell
+// them apart.
+const (
+	ECONFLICT = "conflict"
+	EINTERNAL = "internal"
+	EINVALID  = "invalid"
+	ENOTFOUND = "not_found"
+)
+
+// Error is an error with a code and a message that can be shown to users.
+// Errors without a code are internal, and their message should not be
+// shown.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an Error with code and a formatted message.
+func Errorf(code string, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// ErrorCode returns the code of err, or EINTERNAL if it isn't an Error. It
+// returns an empty string for a nil error.
+func ErrorCode(err error) string {
+	var e *Error
+	if err == nil {
+		return ""
+	} else if errors.As(err, &e) {
+		return e.Code
+	}
+	return EINTERNAL
+}
+
+// ErrorMessage returns the message of err, or a generic message if it isn't
+// an Error. It returns an empty string for a nil error.
+func ErrorMessage(err error) string {
+	var e *Error
+	if err == nil {
+		return ""
+	} else if errors.As(err, &e) {
+		return e.Message
+	}
+	return "Internal error."
+}
@@ -0,0 +1,46 @@
+package goblin
+
+import (
+	"context"
+	"time"
+)
+
+// Event is a discrete event, such as a door opening, a button being pressed
+// or the sun setting.
+type Event struct {
+	Id int `json:"id"`
+	// Sensor that the event comes from, or empty for system events such
+	// as sun transitions
+	SensorId string `json:"sensorId"`
+	// Capability of the sensor, or the type of a system event
+	Capability string `json:"capability"`
+	Value      string `json:"value"`
+	// Value before the event, or empty if it isn't known
+	PrevValue string    `json:"prevValue"`
+	Time      time.Time `json:"time"`
+}
+
+type EventService interface {
+	CreateEvent(context.Context, *Event) error
+	FindEvents(context.Context, EventFilter) ([]*Event, error)
+	// CountEvents returns the number of events matching the filter,
+	// ignoring its ordering, offset and limit.
+	CountEvents(context.Context, EventFilter) (int, error)
+}
+
+type EventFilter struct {
+	Id *int
+	// Sensor of the events. An empty string matches system events.
+	SensorId   *string
+	RoomId     *string
+	Capability *string
+	Value      *string
+	From       *time.Time
+	To         *time.Time
+
+	// Column to order by, "time" or "id", optionally prefixed with "-"
+	// for descending order
+	OrderBy string
+	Offset  int
+	Limit   int
+}
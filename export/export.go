@@ -0,0 +1,174 @@
+// Package export writes readings as CSV or JSON Lines for analysis in other
+// tools.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+type Format string
+
+const (
+	CSV       Format = "csv"
+	JSONLines Format = "jsonl"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, JSONLines:
+		return f, nil
+	case "ndjson":
+		return JSONLines, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, must be csv or jsonl", s)
+}
+
+func (f Format) ContentType() string {
+	if f == JSONLines {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ParseTime parses a time given either as RFC 3339 or as a date, which is
+// midnight UTC.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, must be RFC 3339 or YYYY-MM-DD", s)
+}
+
+// ParseWindow parses the length of an aggregation window. In addition to
+// the units of time.ParseDuration, it may be given in whole days (d).
+func ParseWindow(s string) (time.Duration, error) {
+	var window time.Duration
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		window = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+	}
+	if window < time.Second || window%time.Second != 0 {
+		return 0, fmt.Errorf("window must be a whole number of seconds")
+	}
+	return window, nil
+}
+
+// SplitList splits comma-separated values, so that lists can be given
+// either as repeated values or as a single comma-separated one. It returns
+// nil if there are no values.
+func SplitList(values ...string) []string {
+	var list []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// Writer writes readings in a format. Aggregated readings also get their
+// minimum and maximum values.
+type Writer struct {
+	format     Format
+	aggregated bool
+	csv        *csv.Writer
+	json       *json.Encoder
+	header     bool
+}
+
+func NewWriter(w io.Writer, format Format, aggregated bool) *Writer {
+	writer := &Writer{
+		format:     format,
+		aggregated: aggregated,
+	}
+	if format == JSONLines {
+		writer.json = json.NewEncoder(w)
+	} else {
+		writer.csv = csv.NewWriter(w)
+	}
+	return writer
+}
+
+type row struct {
+	Time       string   `json:"time"`
+	SensorId   string   `json:"sensor_id"`
+	Capability string   `json:"capability"`
+	Value      float64  `json:"value"`
+	Min        *float64 `json:"min,omitempty"`
+	Max        *float64 `json:"max,omitempty"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (w *Writer) writeHeader() error {
+	if w.header || w.csv == nil {
+		return nil
+	}
+	w.header = true
+	header := []string{"time", "sensor_id", "capability", "value"}
+	if w.aggregated {
+		header = append(header, "min", "max")
+	}
+	return w.csv.Write(header)
+}
+
+func (w *Writer) Write(reading *goblin.Reading) error {
+	r := row{
+		Time:       reading.Time.UTC().Format(time.RFC3339Nano),
+		SensorId:   reading.SensorId,
+		Capability: reading.Capability,
+		Value:      reading.Value,
+	}
+	if w.aggregated {
+		r.Min, r.Max = &reading.Min, &reading.Max
+	}
+
+	if w.json != nil {
+		return w.json.Encode(r)
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	record := []string{r.Time, r.SensorId, r.Capability, formatFloat(r.Value)}
+	if w.aggregated {
+		record = append(record, formatFloat(reading.Min), formatFloat(reading.Max))
+	}
+	return w.csv.Write(record)
+}
+
+// Flush writes any buffered data. CSV output always gets a header, even if
+// there are no readings.
+func (w *Writer) Flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
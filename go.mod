@@ -3,6 +3,7 @@ module github.com/maehler/goblin
 go 1.22.2
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.23
 	github.com/spf13/viper v1.19.0
 	nhooyr.io/websocket v1.8.11
 )
@@ -11,7 +12,6 @@ require (
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
-	github.com/mattn/go-sqlite3 v1.14.23 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.2 // indirect
 	github.com/sagikazarmark/locafero v0.6.0 // indirect
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/maehler/goblin"
+)
+
+// Error codes of the API in addition to those of goblin.
+const (
+	eBADGATEWAY       = "bad_gateway"
+	eNOTACCEPTABLE    = "not_acceptable"
+	eUNSUPPORTEDMEDIA = "unsupported_media_type"
+	eREQUESTTOOLARGE  = "request_too_large"
+)
+
+const jsonContentType = "application/json"
+
+// apiMaxBodySize is the largest request body that the API accepts.
+const apiMaxBodySize = 1 << 20
+
+// errorStatus maps error codes to HTTP status codes.
+var errorStatus = map[string]int{
+	goblin.ECONFLICT:  http.StatusConflict,
+	goblin.EINVALID:   http.StatusBadRequest,
+	goblin.ENOTFOUND:  http.StatusNotFound,
+	goblin.EINTERNAL:  http.StatusInternalServerError,
+	eBADGATEWAY:       http.StatusBadGateway,
+	eNOTACCEPTABLE:    http.StatusNotAcceptable,
+	eUNSUPPORTEDMEDIA: http.StatusUnsupportedMediaType,
+	eREQUESTTOOLARGE:  http.StatusRequestEntityTooLarge,
+}
+
+// apiErrorResponse is the body of every error response of the API.
+//
+//	{"error": {"code": "not_found", "message": "room with id 1 not found"}}
+type apiErrorResponse struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiRoute is a route of the JSON API with a pattern of http.ServeMux.
+type apiRoute struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// apiRoutes returns the routes of the JSON API that are described by
+// openapi.json.
+func (s *server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{"GET /api/v1/rooms", s.apiRoomsHandler},
+		{"POST /api/v1/rooms", s.apiCreateRoomHandler},
+		{"GET /api/v1/rooms/{id}", s.apiRoomHandler},
+		{"PATCH /api/v1/rooms/{id}", s.apiUpdateRoomHandler},
+		{"DELETE /api/v1/rooms/{id}", s.apiDeleteRoomHandler},
+
+		{"GET /api/v1/sensors", s.apiSensorsHandler},
+		{"GET /api/v1/sensors/{id}", s.apiSensorHandler},
+		{"PATCH /api/v1/sensors/{id}", s.apiUpdateSensorHandler},
+		{"DELETE /api/v1/sensors/{id}", s.apiDeleteSensorHandler},
+
+		{"GET /api/v1/nodes", s.apiNodesHandler},
+		{"GET /api/v1/nodes/{id}", s.apiNodeHandler},
+		{"POST /api/v1/nodes/{id}/switch", s.apiSwitchHandler},
+		{"POST /api/v1/nodes/{id}/level", s.apiLevelHandler},
+
+		{"GET /api/v1/readings", s.apiReadingsHandler},
+		{"GET /api/v1/events", s.apiEventsHandler},
+		{"GET /api/v1/stream", s.apiStreamHandler},
+	}
+}
+
+// registerAPI adds the routes of the JSON API to the server.
+func (s *server) registerAPI() {
+	for _, route := range s.apiRoutes() {
+		s.mux.HandleFunc(route.pattern, route.handler)
+	}
+
+	s.mux.HandleFunc("GET /api/openapi.json", s.openAPIHandler)
+
+	// Other pages under /api get a JSON error rather than a static file
+	s.mux.HandleFunc("GET /api/", func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, r, goblin.Errorf(goblin.ENOTFOUND, "no route for %s %s", r.Method, r.URL.Path))
+	})
+}
+
+// apiError writes err in the error envelope of the API. The messages of
+// internal errors are logged rather than sent to the client.
+func apiError(w http.ResponseWriter, r *http.Request, err error) {
+	code, message := goblin.ErrorCode(err), goblin.ErrorMessage(err)
+	if code == goblin.EINTERNAL {
+		log.Printf("error in %s %s: %s", r.Method, r.URL.Path, err.Error())
+	}
+
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, apiErrorResponse{apiErrorBody{Code: code, Message: message}})
+}
+
+// writeJSON writes v as the JSON body of a response with status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %s", err.Error())
+	}
+}
+
+// negotiate returns the media type of offers that the client prefers
+// according to its Accept header, or an empty string if it accepts none of
+// them. Offers are in order of preference, and clients without an Accept
+// header get the first one.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The quality of the most specific range that matches offer
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(strings.Join(accept, ","), ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			s := 0
+			switch {
+			case mediaType == offer:
+				s = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+				s = 1
+			case mediaType == "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s < specificity {
+				continue
+			}
+			partQ := 1.0
+			if v, ok := params["q"]; ok {
+				if partQ, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			q, specificity = partQ, s
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptJSON reports whether the client accepts a JSON response, and
+// responds with an error if it doesn't.
+func acceptJSON(w http.ResponseWriter, r *http.Request) bool {
+	if negotiate(r, jsonContentType) == "" {
+		apiError(w, r, goblin.Errorf(eNOTACCEPTABLE, "responses are only available as %s", jsonContentType))
+		return false
+	}
+	return true
+}
+
+// decodeJSON decodes the JSON body of r into v. It responds with an error
+// and returns false if the body isn't JSON or doesn't match v.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != jsonContentType {
+		apiError(w, r, goblin.Errorf(eUNSUPPORTEDMEDIA, "request body must be %s", jsonContentType))
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			apiError(w, r, goblin.Errorf(eREQUESTTOOLARGE, "request body is larger than %d bytes", maxBytesError.Limit))
+		} else {
+			apiError(w, r, goblin.Errorf(goblin.EINVALID, "invalid request body: %s", err.Error()))
+		}
+		return false
+	}
+	return true
+}
+
+// queryInt returns the integer query parameter name, or zero if it isn't
+// set.
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, goblin.Errorf(goblin.EINVALID, "%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// queryPage returns the offset and limit query parameters.
+func queryPage(r *http.Request) (offset, limit int, err error) {
+	if offset, err = queryInt(r, "offset"); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = queryInt(r, "limit"); err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// queryString returns a pointer to the query parameter name, or nil if it
+// isn't given.
+func queryString(r *http.Request, name string) *string {
+	q := r.URL.Query()
+	if !q.Has(name) {
+		return nil
+	}
+	v := q.Get(name)
+	return &v
+}
+
+// bridgeError wraps an error from the Nexa Bridge. Errors with a code,
+// such as nodes that the bridge doesn't have, keep it, and other errors are
+// the bridge failing.
+func bridgeError(err error) error {
+	var e *goblin.Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return goblin.Errorf(eBADGATEWAY, "nexa bridge: %s", err.Error())
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/export"
+)
+
+// apiEventsLimit is the number of events returned when no limit is given.
+const apiEventsLimit = 100
+
+type apiEventsResponse struct {
+	Events []*goblin.Event `json:"events"`
+	// Number of events matching the filter, ignoring offset and limit
+	N int `json:"n"`
+}
+
+// apiEventsHandler lists stored events, most recent first unless another
+// order is given. An empty sensor parameter lists system events, such as
+// sun transitions.
+//
+//	GET /api/v1/events?sensor=101&capability=doorOpen&value=true&from=2024-01-01&order=-time&limit=10
+func (s *server) apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	filter := goblin.EventFilter{
+		SensorId:   queryString(r, "sensor"),
+		RoomId:     queryString(r, "room"),
+		Capability: queryString(r, "capability"),
+		Value:      queryString(r, "value"),
+		OrderBy:    q.Get("order"),
+	}
+	if filter.OrderBy == "" {
+		filter.OrderBy = "-time"
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := export.ParseTime(v)
+		if err != nil {
+			apiError(w, r, goblin.Errorf(goblin.EINVALID, "%s", err.Error()))
+			return
+		}
+		filter.From = &from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := export.ParseTime(v)
+		if err != nil {
+			apiError(w, r, goblin.Errorf(goblin.EINVALID, "%s", err.Error()))
+			return
+		}
+		filter.To = &to
+	}
+	var err error
+	if filter.Offset, filter.Limit, err = queryPage(r); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = apiEventsLimit
+	}
+
+	events, err := s.EventService.FindEvents(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	n, err := s.EventService.CountEvents(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiEventsResponse{events, n})
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+)
+
+type apiNodesResponse struct {
+	Nodes nexa.NexaNodes `json:"nodes"`
+}
+
+// apiNodesHandler lists the nodes of the Nexa Bridge with their current
+// state.
+//
+//	GET /api/v1/nodes
+func (s *server) apiNodesHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	nodes, err := s.NexaService.Nodes()
+	if err != nil {
+		apiError(w, r, bridgeError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, apiNodesResponse{nodes})
+}
+
+// apiNodeHandler returns a node of the Nexa Bridge with its current state.
+//
+//	GET /api/v1/nodes/{id}
+func (s *server) apiNodeHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	node, err := s.NexaService.Node(r.PathValue("id"))
+	if err != nil {
+		apiError(w, r, bridgeError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, node)
+}
+
+type apiSwitchRequest struct {
+	On *bool `json:"on"`
+}
+
+// apiSwitchHandler turns a node on or off.
+//
+//	POST /api/v1/nodes/{id}/switch {"on": true}
+func (s *server) apiSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiSwitchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.On == nil {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "on is required"))
+		return
+	}
+
+	if err := s.NexaService.SetSwitch(r.PathValue("id"), *req.On); err != nil {
+		apiError(w, r, bridgeError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiLevelRequest struct {
+	Level *float64 `json:"level"`
+}
+
+// apiLevelHandler sets the level of a dimmer, between 0 and 1.
+//
+//	POST /api/v1/nodes/{id}/level {"level": 0.5}
+func (s *server) apiLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiLevelRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Level == nil || *req.Level < 0 || *req.Level > 1 {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "level must be between 0 and 1"))
+		return
+	}
+
+	if err := s.NexaService.SetLevel(r.PathValue("id"), *req.Level); err != nil {
+		apiError(w, r, bridgeError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
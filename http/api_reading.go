@@ -0,0 +1,97 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/export"
+)
+
+// apiReadingsHandler returns stored readings. Without a window, the
+// resolution is picked from the span between from and to, and window=raw
+// returns raw readings. The response is JSON unless the client prefers
+// CSV or JSON Lines:
+//
+//	GET /api/v1/readings?sensor=101&capability=temperature&from=2024-01-01&window=1h
+//
+//	{"readings": [{"sensorId": "101", "capability": "temperature", "time": "...",
+//	"value": 21.5, "min": 21, "max": 22}], "resolution": 3600}
+//
+// The resolution is in seconds, where 0 is raw readings. It is null if
+// it was picked automatically and there are no readings.
+func (s *server) apiReadingsHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := negotiate(r, jsonContentType, "text/csv", "application/x-ndjson")
+	if contentType == "" {
+		apiError(w, r, goblin.Errorf(eNOTACCEPTABLE, "readings are available as %s, text/csv or application/x-ndjson", jsonContentType))
+		return
+	}
+
+	filter, err := readingFilter(r)
+	if err != nil {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "%s", err.Error()))
+		return
+	}
+
+	fail := func(err error) { apiError(w, r, err) }
+	switch contentType {
+	case "text/csv":
+		s.streamReadings(w, r, export.CSV, filter, fail)
+		return
+	case "application/x-ndjson":
+		s.streamReadings(w, r, export.JSONLines, filter, fail)
+		return
+	}
+
+	// The readings are written as they are read, so the resolution
+	// follows them
+	var resolution *int64
+	if v := filter.Resolution; v != nil {
+		seconds := int64(v.Seconds())
+		resolution = &seconds
+	}
+	rc := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	rows := 0
+	err = s.ReadingService.StreamReadings(r.Context(), filter, func(reading *goblin.Reading) error {
+		if rows == 0 {
+			seconds := int64(reading.Resolution.Seconds())
+			resolution = &seconds
+			w.Header().Set("Content-Type", jsonContentType)
+			w.Write([]byte(`{"readings":[`))
+		} else {
+			w.Write([]byte(","))
+		}
+		// Encode ends each reading with a newline, which keeps the
+		// response readable
+		if err := encoder.Encode(reading); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if rows == 0 {
+			apiError(w, r, err)
+			return
+		}
+		log.Printf("error writing readings after %d rows: %s", rows, err.Error())
+		return
+	}
+
+	if rows == 0 {
+		w.Header().Set("Content-Type", jsonContentType)
+		w.Write([]byte(`{"readings":[`))
+	}
+	res := "null"
+	if resolution != nil {
+		res = strconv.FormatInt(*resolution, 10)
+	}
+	fmt.Fprintf(w, "],\"resolution\":%s}\n", res)
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/maehler/goblin"
+)
+
+type apiRoomsResponse struct {
+	Rooms []*goblin.Room `json:"rooms"`
+}
+
+// apiRoomsHandler lists the rooms with their sensors.
+//
+//	GET /api/v1/rooms?name=Kitchen&order=-name&offset=0&limit=10
+func (s *server) apiRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	filter := goblin.RoomFilter{
+		Name:    queryString(r, "name"),
+		OrderBy: r.URL.Query().Get("order"),
+	}
+	var err error
+	if filter.Offset, filter.Limit, err = queryPage(r); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	rooms, err := s.RoomService.FindRooms(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiRoomsResponse{rooms})
+}
+
+// apiRoomHandler returns a room with its sensors.
+//
+//	GET /api/v1/rooms/{id}
+func (s *server) apiRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	room, err := s.RoomService.RoomById(r.Context(), r.PathValue("id"))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+type apiCreateRoomRequest struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// apiCreateRoomHandler creates a room that isn't on the Nexa Bridge.
+//
+//	POST /api/v1/rooms {"id": "garage", "name": "Garage"}
+func (s *server) apiCreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	var req apiCreateRoomRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Id == "" {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "room id is required"))
+		return
+	}
+	if req.Name == "" {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "room name is required"))
+		return
+	}
+
+	// CreateRoom renames existing rooms, which isn't what a client
+	// creating a room expects
+	if _, err := s.RoomService.RoomById(r.Context(), req.Id); err == nil {
+		apiError(w, r, goblin.Errorf(goblin.ECONFLICT, "room with id %s already exists", req.Id))
+		return
+	} else if goblin.ErrorCode(err) != goblin.ENOTFOUND {
+		apiError(w, r, err)
+		return
+	}
+
+	if err := s.RoomService.CreateRoom(r.Context(), &goblin.Room{Id: req.Id, Name: req.Name}); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	room, err := s.RoomService.RoomById(r.Context(), req.Id)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/rooms/"+room.Id)
+	writeJSON(w, http.StatusCreated, room)
+}
+
+type apiUpdateRoomRequest struct {
+	Name *string `json:"name"`
+}
+
+// apiUpdateRoomHandler renames a room.
+//
+//	PATCH /api/v1/rooms/{id} {"name": "Garage"}
+func (s *server) apiUpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	var req apiUpdateRoomRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "room name cannot be empty"))
+		return
+	}
+
+	room, err := s.RoomService.UpdateRoom(r.Context(), r.PathValue("id"), goblin.RoomUpdate{Name: req.Name})
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+// apiDeleteRoomHandler deletes a room. Its sensors are kept without a
+// room.
+//
+//	DELETE /api/v1/rooms/{id}
+func (s *server) apiDeleteRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.RoomService.DeleteRoom(r.Context(), r.PathValue("id")); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.sensorRooms.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
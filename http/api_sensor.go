@@ -0,0 +1,110 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/maehler/goblin"
+)
+
+type apiSensorsResponse struct {
+	Sensors []*goblin.Sensor `json:"sensors"`
+}
+
+// apiSensorsHandler lists the sensors. Sensors without a room are listed
+// with an empty room parameter.
+//
+//	GET /api/v1/sensors?room=kitchen&removed=false&order=name&offset=0&limit=10
+func (s *server) apiSensorsHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	filter := goblin.SensorFilter{
+		RoomId:  queryString(r, "room"),
+		OrderBy: r.URL.Query().Get("order"),
+	}
+	if v := r.URL.Query().Get("removed"); v != "" {
+		removed, err := strconv.ParseBool(v)
+		if err != nil {
+			apiError(w, r, goblin.Errorf(goblin.EINVALID, "removed must be true or false"))
+			return
+		}
+		filter.Removed = &removed
+	}
+	var err error
+	if filter.Offset, filter.Limit, err = queryPage(r); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	sensors, err := s.SensorService.FindSensors(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiSensorsResponse{sensors})
+}
+
+// apiSensorHandler returns a sensor.
+//
+//	GET /api/v1/sensors/{id}
+func (s *server) apiSensorHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	sensor, err := s.SensorService.SensorById(r.Context(), r.PathValue("id"))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sensor)
+}
+
+type apiUpdateSensorRequest struct {
+	Name *string `json:"name"`
+	// An empty room removes the sensor from its room
+	RoomId *string `json:"roomId"`
+}
+
+// apiUpdateSensorHandler renames a sensor or moves it to another room.
+//
+//	PATCH /api/v1/sensors/{id} {"name": "Fridge", "roomId": "kitchen"}
+func (s *server) apiUpdateSensorHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptJSON(w, r) {
+		return
+	}
+
+	var req apiUpdateSensorRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "sensor name cannot be empty"))
+		return
+	}
+
+	sensor, err := s.SensorService.UpdateSensor(r.Context(), r.PathValue("id"), goblin.SensorUpdate{
+		Name:   req.Name,
+		RoomId: req.RoomId,
+	})
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.sensorRooms.invalidate()
+	writeJSON(w, http.StatusOK, sensor)
+}
+
+// apiDeleteSensorHandler deletes a sensor with its readings and events.
+//
+//	DELETE /api/v1/sensors/{id}
+func (s *server) apiDeleteSensorHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.SensorService.DeleteSensor(r.Context(), r.PathValue("id")); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.sensorRooms.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
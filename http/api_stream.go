@@ -0,0 +1,343 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+	"nhooyr.io/websocket"
+)
+
+// streamRequest is a frame sent by a client of the stream, which adds or
+// removes a subscription with the id chosen by the client.
+type streamRequest struct {
+	Type   string       `json:"type"`
+	Id     string       `json:"id"`
+	Filter streamFilter `json:"filter"`
+
+	// Set if the frame couldn't be decoded
+	err error
+}
+
+// streamFilter selects the messages of a subscription. Empty lists match
+// any value, and a message matches if its node is among the nodes or is a
+// sensor in one of the rooms when the message arrives.
+type streamFilter struct {
+	Nodes        []string `json:"nodes,omitempty"`
+	Rooms        []string `json:"rooms,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	SystemTypes  []string `json:"systemTypes,omitempty"`
+}
+
+// streamFrame is a frame sent to a client of the stream. Every frame has
+// the sequence number of the latest message the client has been sent, or
+// of the message it carries, and the time it was sent.
+type streamFrame struct {
+	// hello, event, subscribed, unsubscribed or error
+	Type string    `json:"type"`
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Subscription that the frame responds to
+	Id string `json:"id,omitempty"`
+	// Subscriptions that the message of an event matches
+	Subscriptions []string        `json:"subscriptions,omitempty"`
+	Message       json.RawMessage `json:"message,omitempty"`
+	Error         *apiErrorBody   `json:"error,omitempty"`
+}
+
+// streamSubscription is a filter as sets, where nil sets match anything.
+// A message matches the nodes and rooms if it matches either of them.
+type streamSubscription struct {
+	nodes        map[string]bool
+	rooms        map[string]bool
+	capabilities map[string]bool
+	systemTypes  map[string]bool
+}
+
+// matches reports whether msg matches the subscription, where room returns
+// the room of a sensor.
+func (sub *streamSubscription) matches(msg *nexa.Message, room func(string) string) bool {
+	if sub.nodes != nil || sub.rooms != nil {
+		node := sub.nodes[msg.SourceNode] || sub.nodes[msg.NodeId]
+		if !node && sub.rooms != nil {
+			for _, id := range []string{msg.SourceNode, msg.NodeId} {
+				if id != "" && sub.rooms[room(id)] {
+					node = true
+					break
+				}
+			}
+		}
+		if !node {
+			return false
+		}
+	}
+	if sub.capabilities != nil && !sub.capabilities[msg.Capability] {
+		return false
+	}
+	if sub.systemTypes != nil && !sub.systemTypes[msg.SystemType] {
+		return false
+	}
+	return true
+}
+
+func stringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// newStreamSubscription returns the subscription of filter, after checking
+// that its rooms exist.
+func (s *server) newStreamSubscription(ctx context.Context, filter streamFilter) (*streamSubscription, error) {
+	sub := &streamSubscription{
+		nodes:        stringSet(filter.Nodes),
+		rooms:        stringSet(filter.Rooms),
+		capabilities: stringSet(filter.Capabilities),
+		systemTypes:  stringSet(filter.SystemTypes),
+	}
+	for _, roomId := range filter.Rooms {
+		if _, err := s.RoomService.RoomById(ctx, roomId); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// sensorRoomsTTL is how long the rooms of the sensors are cached for the
+// stream. Sensors that are moved by the inventory sync or another goblin
+// process are matched by their new rooms after this long at most.
+const sensorRoomsTTL = time.Minute
+
+// sensorRooms caches the room of every sensor, so that the stream can
+// match messages by the rooms that their sensors are in when they arrive.
+// The API invalidates the cache when it changes sensors or rooms. It is
+// safe to use from multiple goroutines.
+type sensorRooms struct {
+	mutex    sync.Mutex
+	rooms    map[string]string
+	loadedAt time.Time
+}
+
+// room returns the room of a sensor, or an empty string if it has no room
+// or isn't known. The rooms are loaded from service if they are stale.
+func (c *sensorRooms) room(ctx context.Context, service goblin.SensorService, sensorId string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.rooms == nil || time.Since(c.loadedAt) > sensorRoomsTTL {
+		sensors, err := service.FindSensors(ctx, goblin.SensorFilter{})
+		if err != nil {
+			return "", err
+		}
+		c.rooms = make(map[string]string, len(sensors))
+		for _, sensor := range sensors {
+			c.rooms[sensor.Id] = sensor.RoomId
+		}
+		c.loadedAt = time.Now()
+	}
+	return c.rooms[sensorId], nil
+}
+
+// invalidate makes the next lookup load the rooms again.
+func (c *sensorRooms) invalidate() {
+	c.mutex.Lock()
+	c.rooms = nil
+	c.mutex.Unlock()
+}
+
+// sensorRoom returns the room of a sensor for matching stream messages.
+// Sensors whose room can't be looked up are treated as being in no room.
+func (s *server) sensorRoom(ctx context.Context, sensorId string) string {
+	room, err := s.sensorRooms.room(ctx, s.SensorService, sensorId)
+	if err != nil {
+		log.Printf("error looking up the room of sensor %s: %s", sensorId, err.Error())
+	}
+	return room
+}
+
+// publish queues msg as JSON for the clients of the stream. Unlike the
+// dashboard, the stream has every message, whether there is a template for
+// it or not.
+func (s *server) publish(msg *nexa.Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.stream.broadcast("", string(b), msg)
+	return nil
+}
+
+// StreamStats returns counters of the clients of the JSON stream.
+func (s *server) StreamStats() HubStats {
+	return s.stream.Stats()
+}
+
+// handleStreamRequest adds or removes a subscription, and returns the
+// frame that responds to the request.
+func (s *server) handleStreamRequest(ctx context.Context, req streamRequest, subscriptions map[string]*streamSubscription) streamFrame {
+	frame := streamFrame{Id: req.Id}
+	err := req.err
+	switch {
+	case err != nil:
+	case req.Id == "":
+		err = goblin.Errorf(goblin.EINVALID, "subscription id is required")
+	case req.Type == "subscribe":
+		var sub *streamSubscription
+		if sub, err = s.newStreamSubscription(ctx, req.Filter); err == nil {
+			subscriptions[req.Id] = sub
+			frame.Type = "subscribed"
+		}
+	case req.Type == "unsubscribe":
+		if _, ok := subscriptions[req.Id]; !ok {
+			err = goblin.Errorf(goblin.ENOTFOUND, "subscription %q not found", req.Id)
+		} else {
+			delete(subscriptions, req.Id)
+			frame.Type = "unsubscribed"
+		}
+	default:
+		err = goblin.Errorf(goblin.EINVALID, "unknown request type %q", req.Type)
+	}
+
+	if err != nil {
+		if goblin.ErrorCode(err) == goblin.EINTERNAL {
+			log.Printf("error in stream request %+v: %s", req, err.Error())
+		}
+		frame.Type = "error"
+		frame.Error = &apiErrorBody{Code: goblin.ErrorCode(err), Message: goblin.ErrorMessage(err)}
+	}
+	return frame
+}
+
+// streamEvents sends the messages from the Nexa Bridge that match the
+// subscriptions of the client as JSON, until the connection closes.
+func (s *server) streamEvents(ctx context.Context, c *websocket.Conn, ip string) error {
+	subscriber := s.stream.subscribe(ip, nil)
+	defer s.stream.unsubscribe(subscriber)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	requests := make(chan streamRequest)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := c.Read(ctx)
+			if err != nil {
+				return
+			}
+			var req streamRequest
+			if err := json.Unmarshal(data, &req); err != nil {
+				req.err = goblin.Errorf(goblin.EINVALID, "invalid request: %s", err.Error())
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	subscriptions := make(map[string]*streamSubscription)
+	var seq uint64
+	for {
+		var frame streamFrame
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-subscriber.evicted:
+			c.Close(websocket.StatusTryAgainLater, "client too slow")
+			return nil
+		case req := <-requests:
+			frame = s.handleStreamRequest(ctx, req, subscriptions)
+			frame.Seq, frame.Time = seq, time.Now()
+		case msg := <-subscriber.messages:
+			seq = msg.Seq
+			// The stream has no state, so its snapshot only tells the
+			// client where it starts
+			if msg.Snapshot {
+				frame = streamFrame{Type: "hello", Seq: msg.Seq, Time: msg.Time}
+				break
+			}
+			m, ok := msg.Value.(*nexa.Message)
+			if !ok {
+				continue
+			}
+			frame = streamFrame{Type: "event", Seq: msg.Seq, Time: msg.Time, Message: json.RawMessage(msg.Data)}
+			room := func(sensorId string) string { return s.sensorRoom(ctx, sensorId) }
+			for id, sub := range subscriptions {
+				if sub.matches(m, room) {
+					frame.Subscriptions = append(frame.Subscriptions, id)
+				}
+			}
+			if len(frame.Subscriptions) == 0 {
+				continue
+			}
+			sort.Strings(frame.Subscriptions)
+		}
+
+		b, err := json.Marshal(frame)
+		if err != nil {
+			return err
+		}
+		if err := writeFrame(ctx, c, b); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFrame writes a JSON frame to c, giving up after a few seconds.
+func writeFrame(ctx context.Context, c *websocket.Conn, b []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return c.Write(ctx, websocket.MessageText, b)
+}
+
+// apiStreamHandler upgrades the request to a websocket that streams the
+// messages of the Nexa Bridge as JSON. The client gets a hello frame, and
+// then events for the messages that match any of its subscriptions:
+//
+//	GET /api/v1/stream
+//
+//	> {"type": "subscribe", "id": "temps", "filter": {"rooms": ["1"], "capabilities": ["temperature"]}}
+//	< {"type": "subscribed", "seq": 41, "time": "...", "id": "temps"}
+//	< {"type": "event", "seq": 42, "time": "...", "subscriptions": ["temps"], "message": {...}}
+//	> {"type": "unsubscribe", "id": "temps"}
+//
+// Sequence numbers are shared by all clients, so a client only sees the
+// numbers of the messages it subscribes to.
+func (s *server) apiStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		apiError(w, r, goblin.Errorf(goblin.EINVALID, "the stream is a websocket, connect with a websocket client"))
+		return
+	}
+
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Printf("error accepting stream from %s: %s", r.RemoteAddr, err.Error())
+		return
+	}
+	defer c.CloseNow()
+
+	err = s.streamEvents(r.Context(), c, r.RemoteAddr)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
+		websocket.CloseStatus(err) == websocket.StatusGoingAway {
+		return
+	}
+	if err != nil {
+		log.Printf("error streaming to %s: %v", r.RemoteAddr, err)
+	}
+}
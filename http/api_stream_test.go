@@ -0,0 +1,48 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+)
+
+func TestStreamSubscriptionRooms(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+
+	if _, err := s.newStreamSubscription(ctx, streamFilter{Rooms: []string{"9"}}); goblin.ErrorCode(err) != goblin.ENOTFOUND {
+		t.Fatalf("expected a subscription to an unknown room to be not found, got %v", err)
+	}
+
+	sub, err := s.newStreamSubscription(ctx, streamFilter{Rooms: []string{"1"}, Capabilities: []string{"temperature", "notificationContact"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := func(id string) string { return s.sensorRoom(ctx, id) }
+	thermometer := &nexa.Message{SourceNode: "101", Capability: "temperature"}
+	door := &nexa.Message{SourceNode: "201", Capability: "notificationContact"}
+	if !sub.matches(thermometer, room) {
+		t.Error("expected a sensor in the room to match")
+	}
+	if sub.matches(door, room) {
+		t.Error("expected a sensor without a room not to match")
+	}
+
+	// Sensors that are moved after the subscription is made match their
+	// new room
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/sensors/201", strings.NewReader(`{"roomId": "1"}`))
+	req.Header.Set("Content-Type", jsonContentType)
+	s.mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error moving sensor: %d %s", w.Code, w.Body)
+	}
+	if !sub.matches(door, room) {
+		t.Error("expected a sensor moved to the room to match")
+	}
+}
@@ -0,0 +1,423 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+)
+
+// chartRange is a span of time that charts can show, ending now.
+type chartRange struct {
+	Name string
+	Span time.Duration
+	// Time between labels on the x axis, and their format
+	Tick   time.Duration
+	Layout string
+}
+
+var chartRanges = []chartRange{
+	{"24h", 24 * time.Hour, 4 * time.Hour, "15:04"},
+	{"7d", 7 * 24 * time.Hour, 24 * time.Hour, "Mon 2"},
+	{"30d", 30 * 24 * time.Hour, 7 * 24 * time.Hour, "Jan 2"},
+	{"1y", 365 * 24 * time.Hour, 0, "Jan"},
+}
+
+// parseChartRange returns the chart range with name, or the first one if
+// name is empty.
+func parseChartRange(name string) (chartRange, error) {
+	if name == "" {
+		return chartRanges[0], nil
+	}
+	for _, r := range chartRanges {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return chartRange{}, goblin.Errorf(goblin.EINVALID, "unknown range %q", name)
+}
+
+// Size of charts in SVG units, and the margins around the plot for the axis
+// labels.
+const (
+	chartWidth  = 800
+	chartHeight = 240
+
+	chartLeft   = 48
+	chartRight  = 12
+	chartTop    = 12
+	chartBottom = 28
+)
+
+// chartColors are the colors of the series of a chart, in order.
+var chartColors = []string{"#2563eb", "#dc2626", "#16a34a", "#d97706", "#7c3aed", "#0891b2"}
+
+// chartUnits are the units shown on the y axis for capabilities.
+var chartUnits = map[string]string{
+	"temperature": "˚C",
+	"humidity":    "%",
+}
+
+// chart is a line chart of readings of one capability, with one series per
+// sensor.
+type chart struct {
+	// Id of the chart element, and the URL that renders it
+	Id  string
+	URL string
+
+	Title  string
+	Range  string
+	Ranges []string
+	// htmx trigger that reloads the chart when one of its series gets a
+	// new reading over the websocket
+	Trigger string
+
+	Width, Height int
+	// Corners of the plot area
+	Left, Right, Top, Bottom float64
+
+	Series []chartSeries
+	XTicks []chartTick
+	YTicks []chartTick
+}
+
+// HasReadings reports whether any series of the chart has readings.
+func (c *chart) HasReadings() bool {
+	for _, series := range c.Series {
+		if series.Line != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type chartSeries struct {
+	Name  string
+	Color string
+	// SVG path of the line through the values, and of the band between the
+	// minimum and maximum of each window for aggregated readings
+	Line string
+	Band string
+}
+
+type chartTick struct {
+	Pos   float64
+	Label string
+}
+
+// chartRefreshDelay is how long a chart waits after the last websocket
+// message of one of its series before it is reloaded. Readings are written
+// to the database in batches, so a reading isn't stored when its message
+// arrives.
+const chartRefreshDelay = 10 * time.Second
+
+// chartTrigger returns an htmx trigger for the websocket messages and
+// Server-Sent Events that update capability of any of the sensors. They
+// are matched by the ids of the elements they swap, which are the sensor
+// and capability. Snapshots have the elements of every sensor without
+// anything having changed, so they don't reload the chart.
+func chartTrigger(capability string, sensorIds []string) string {
+	var ws, sse []string
+	for _, id := range sensorIds {
+		b, _ := json.Marshal(fmt.Sprintf(`id="%s-%s"`, id, capability))
+		ws = append(ws, fmt.Sprintf("detail.message.includes(%s)", b))
+		sse = append(sse, fmt.Sprintf("detail.data.includes(%s)", b))
+	}
+	wsSnapshot, _ := json.Marshal(snapshotAttribute)
+	sseSnapshot, _ := json.Marshal(sseSnapshotEvent)
+	delay := int(chartRefreshDelay.Seconds())
+	return fmt.Sprintf("htmx:wsAfterMessage[!detail.message.includes(%s) && (%s)] from:body delay:%ds, "+
+		"htmx:sseMessage[detail.type !== %s && (%s)] from:body delay:%ds",
+		wsSnapshot, strings.Join(ws, " || "), delay, sseSnapshot, strings.Join(sse, " || "), delay)
+}
+
+// newChart returns a chart of the readings of capability from the sensors
+// in names, which maps their ids to the names of the series. The chart is
+// identified by key and rendered at path.
+func (s *server) newChart(ctx context.Context, key, path, capability string, names map[string]string, r chartRange, now time.Time) (*chart, error) {
+	sensorIds := make([]string, 0, len(names))
+	for id := range names {
+		sensorIds = append(sensorIds, id)
+	}
+	sort.Strings(sensorIds)
+
+	from := now.Add(-r.Span)
+	readings, err := s.ReadingService.FindReadings(ctx, goblin.ReadingFilter{
+		SensorIds:    sensorIds,
+		Capabilities: []string{capability},
+		From:         &from,
+		To:           &now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	title := capability
+	if unit := chartUnits[capability]; unit != "" {
+		title = fmt.Sprintf("%s (%s)", capability, unit)
+	}
+	c := &chart{
+		Id:      fmt.Sprintf("chart-%s-%s", key, capability),
+		URL:     path,
+		Title:   title,
+		Range:   r.Name,
+		Trigger: chartTrigger(capability, sensorIds),
+		Width:   chartWidth,
+		Height:  chartHeight,
+		Left:    chartLeft,
+		Right:   chartWidth - chartRight,
+		Top:     chartTop,
+		Bottom:  chartHeight - chartBottom,
+	}
+	for _, r := range chartRanges {
+		c.Ranges = append(c.Ranges, r.Name)
+	}
+
+	// Scale the y axis to the values with some headroom, over a range of
+	// at least one unit so that flat series don't fill the chart with noise
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, reading := range readings {
+		lo, hi = math.Min(lo, reading.Min), math.Max(hi, reading.Max)
+	}
+	if len(readings) == 0 {
+		lo, hi = 0, 1
+	}
+	if hi-lo < 1 {
+		mid := (hi + lo) / 2
+		lo, hi = mid-0.5, mid+0.5
+	}
+	step := niceStep((hi - lo) / 4)
+	lo, hi = math.Floor(lo/step)*step, math.Ceil(hi/step)*step
+
+	// Positions are rounded to a tenth, which is plenty for the size of
+	// the charts and keeps the markup small
+	x := func(t time.Time) float64 {
+		f := float64(t.Sub(from)) / float64(r.Span)
+		return math.Round((c.Left+math.Max(0, math.Min(1, f))*(c.Right-c.Left))*10) / 10
+	}
+	y := func(v float64) float64 {
+		return math.Round((c.Bottom-(v-lo)/(hi-lo)*(c.Bottom-c.Top))*10) / 10
+	}
+
+	for v := lo; v <= hi+step/2; v += step {
+		c.YTicks = append(c.YTicks, chartTick{y(v), formatTick(v, step)})
+	}
+	for _, t := range timeTicks(from, now, r) {
+		c.XTicks = append(c.XTicks, chartTick{x(t), t.Format(r.Layout)})
+	}
+
+	bySensor := make(map[string][]*goblin.Reading)
+	for _, reading := range readings {
+		bySensor[reading.SensorId] = append(bySensor[reading.SensorId], reading)
+	}
+	// Lines are broken where readings are missing
+	gap := r.Span / 24
+	for i, id := range sensorIds {
+		series := chartSeries{
+			Name:  names[id],
+			Color: chartColors[i%len(chartColors)],
+		}
+		var segment []*goblin.Reading
+		flush := func() {
+			if len(segment) == 0 {
+				return
+			}
+			var line, upper, lower []string
+			for _, reading := range segment {
+				// Aggregated readings are drawn in the middle of their
+				// windows
+				px := x(reading.Time.Add(reading.Resolution / 2))
+				line = append(line, fmt.Sprintf("%g %g", px, y(reading.Value)))
+				upper = append(upper, fmt.Sprintf("%g %g", px, y(reading.Max)))
+				lower = append([]string{fmt.Sprintf("%g %g", px, y(reading.Min))}, lower...)
+			}
+			series.Line += "M" + strings.Join(line, " L") + " "
+			if segment[0].Resolution > 0 {
+				series.Band += "M" + strings.Join(append(upper, lower...), " L") + " Z "
+			}
+			segment = segment[:0]
+		}
+		for _, reading := range bySensor[id] {
+			if n := len(segment); n > 0 && reading.Time.Sub(segment[n-1].Time) > max(gap, 2*reading.Resolution) {
+				flush()
+			}
+			segment = append(segment, reading)
+		}
+		flush()
+		series.Line = strings.TrimSpace(series.Line)
+		series.Band = strings.TrimSpace(series.Band)
+		c.Series = append(c.Series, series)
+	}
+
+	return c, nil
+}
+
+// chartCapabilities returns the capabilities of nodes with numeric values,
+// which are stored as readings, in the order they first appear. The nodes
+// with each capability are mapped from their ids to their names.
+func chartCapabilities(nodes nexa.NexaNodes) ([]string, map[string]map[string]string) {
+	var capabilities []string
+	names := make(map[string]map[string]string)
+	for _, node := range nodes {
+		for _, capability := range node.Capabilities {
+			event := node.LastEvents[capability]
+			if event == nil {
+				continue
+			}
+			if _, err := event.FloatValue(); err != nil {
+				continue
+			}
+			if names[capability] == nil {
+				capabilities = append(capabilities, capability)
+				names[capability] = make(map[string]string)
+			}
+			names[capability][node.Id] = node.Name
+		}
+	}
+	return capabilities, names
+}
+
+// nodeCharts returns the charts of all numeric capabilities of nodes over
+// r. The charts are identified by key and rendered below path.
+func (s *server) nodeCharts(ctx context.Context, key, path string, nodes nexa.NexaNodes, r chartRange) ([]*chart, error) {
+	now := time.Now()
+	capabilities, names := chartCapabilities(nodes)
+	charts := make([]*chart, 0, len(capabilities))
+	for _, capability := range capabilities {
+		c, err := s.newChart(ctx, key, path+"/charts/"+capability, capability, names[capability], r, now)
+		if err != nil {
+			return nil, err
+		}
+		charts = append(charts, c)
+	}
+	return charts, nil
+}
+
+// renderChart renders the chart of the capability in the path of r for
+// nodes. The range is given by the range parameter, and defaults to the
+// shortest one.
+func (s *server) renderChart(w http.ResponseWriter, r *http.Request, key string, nodes nexa.NexaNodes) {
+	chartRange, err := parseChartRange(r.URL.Query().Get("range"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: %s", goblin.ErrorMessage(err))))
+		return
+	}
+
+	capability := r.PathValue("capability")
+	_, names := chartCapabilities(nodes)
+	if names[capability] == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("error: no readings of %q", capability)))
+		return
+	}
+
+	c, err := s.newChart(r.Context(), key, r.URL.Path, capability, names[capability], chartRange, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
+		return
+	}
+	if err := s.templates.ExecuteTemplate(w, "chart", c); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error executing template: %s", err.Error())
+	}
+}
+
+// deviceChartHandler renders a chart of a capability of a device.
+//
+//	GET /devices/101/charts/temperature?range=7d
+func (s *server) deviceChartHandler(w http.ResponseWriter, r *http.Request) {
+	device, err := s.NexaService.Node(r.PathValue("id"))
+	if err != nil {
+		deviceError(w, r.PathValue("id"), err)
+		return
+	}
+	s.renderChart(w, r, "device-"+device.Id, nexa.NexaNodes{device})
+}
+
+// roomChartHandler renders a chart of a capability of all devices in a
+// room.
+//
+//	GET /rooms/1/charts/temperature?range=30d
+func (s *server) roomChartHandler(w http.ResponseWriter, r *http.Request) {
+	room, err := s.nexaRoom(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
+		return
+	} else if room == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("error: room %q not found", r.PathValue("id"))))
+		return
+	}
+	s.renderChart(w, r, "room-"+room.Id, room.Nodes)
+}
+
+// chartURL returns the URL of a chart at path with range r.
+func chartURL(path string, r string) string {
+	return path + "?" + url.Values{"range": {r}}.Encode()
+}
+
+// niceStep returns a round step of at least step: 1, 2 or 5 times a power
+// of ten.
+func niceStep(step float64) float64 {
+	magnitude := math.Pow(10, math.Floor(math.Log10(step)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*magnitude >= step {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatTick formats v with as many decimals as step needs.
+func formatTick(v, step float64) string {
+	decimals := 0
+	if step < 1 {
+		decimals = int(math.Ceil(-math.Log10(step)))
+	}
+	return fmt.Sprintf("%.*f", decimals, v)
+}
+
+// timeTicks returns the times between from and to that get a label on the
+// x axis of r, in local time: every Tick from midnight, or the first of
+// every month if Tick is zero.
+func timeTicks(from, to time.Time, r chartRange) []time.Time {
+	from, to = from.Local(), to.Local()
+	t := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	if r.Tick == 0 {
+		t = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.Local)
+	} else if r.Tick >= 7*24*time.Hour {
+		// Weeks start on Monday
+		t = t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	}
+
+	var ticks []time.Time
+	for ; t.Before(to); t = nextTick(t, r) {
+		if !t.Before(from) {
+			ticks = append(ticks, t)
+		}
+	}
+	return ticks
+}
+
+func nextTick(t time.Time, r chartRange) time.Time {
+	switch {
+	case r.Tick == 0:
+		return t.AddDate(0, 1, 0)
+	case r.Tick%(24*time.Hour) == 0:
+		// Days aren't always 24 hours long
+		return t.AddDate(0, 0, int(r.Tick/(24*time.Hour)))
+	}
+	return t.Add(r.Tick)
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/export"
+)
+
+// exportFlushRows is the number of rows written between flushes of an
+// export, so that clients receive rows as they are read.
+const exportFlushRows = 1000
+
+// readingFilter returns the reading filter of a request. Lists may be given
+// as repeated or comma-separated parameters. The resolution is only set if
+// a window is given, where "raw" returns raw readings.
+func readingFilter(r *http.Request) (goblin.ReadingFilter, error) {
+	q := r.URL.Query()
+	filter := goblin.ReadingFilter{
+		SensorIds:    export.SplitList(q["sensor"]...),
+		RoomIds:      export.SplitList(q["room"]...),
+		Capabilities: export.SplitList(q["capability"]...),
+	}
+
+	if v := q.Get("from"); v != "" {
+		from, err := export.ParseTime(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.From = &from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := export.ParseTime(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.To = &to
+	}
+
+	if v := q.Get("window"); v == "raw" {
+		var window time.Duration
+		filter.Resolution = &window
+	} else if v != "" {
+		window, err := export.ParseWindow(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Resolution = &window
+	}
+
+	return filter, nil
+}
+
+// exportFilter returns the reading filter of an export request, which is
+// raw unless a window is given.
+func exportFilter(r *http.Request) (goblin.ReadingFilter, error) {
+	filter, err := readingFilter(r)
+	if err != nil {
+		return filter, err
+	}
+	if filter.Resolution == nil {
+		var window time.Duration
+		filter.Resolution = &window
+	}
+	return filter, nil
+}
+
+// exportHandler streams readings as CSV or JSON Lines.
+//
+//	GET /export?format=csv&sensor=101&capability=temperature&from=2024-01-01&window=1h
+func (s *server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := export.CSV
+	if v := r.URL.Query().Get("format"); v != "" {
+		var err error
+		if format, err = export.ParseFormat(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("error: %s", err.Error())))
+			return
+		}
+	}
+
+	filter, err := exportFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: %s", err.Error())))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="readings.%s"`, format))
+	s.streamReadings(w, r, format, filter, func(err error) {
+		w.Header().Del("Content-Disposition")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("error: %s", err.Error())))
+	})
+}
+
+// streamReadings writes the readings matching filter in format, flushing
+// the response as rows are written. If reading fails before the first row,
+// fail is called to respond with the error instead.
+func (s *server) streamReadings(w http.ResponseWriter, r *http.Request, format export.Format, filter goblin.ReadingFilter, fail func(error)) {
+	rc := http.NewResponseController(w)
+	aggregated := filter.Resolution == nil || *filter.Resolution > 0
+	writer := export.NewWriter(w, format, aggregated)
+	rows := 0
+
+	err := s.ReadingService.StreamReadings(r.Context(), filter, func(reading *goblin.Reading) error {
+		if rows == 0 {
+			w.Header().Set("Content-Type", format.ContentType())
+		}
+		if err := writer.Write(reading); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if rows == 0 {
+			fail(err)
+			return
+		}
+		// The response has already started, so the client gets a
+		// truncated export
+		log.Printf("error exporting readings after %d rows: %s", rows, err.Error())
+		return
+	}
+
+	if rows == 0 {
+		w.Header().Set("Content-Type", format.ContentType())
+	}
+	if err := writer.Flush(); err != nil {
+		log.Printf("error exporting readings: %s", err.Error())
+	}
+}
@@ -0,0 +1,230 @@
+package http
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// subscriberQueueSize is the number of messages that are queued for a
+// subscriber before it is considered too slow and is evicted.
+const subscriberQueueSize = 64
+
+// hubHistorySize is the number of recent messages that are kept for
+// subscribers that resume where they left off.
+const hubHistorySize = 256
+
+// hubMessage is a message queued for a subscriber.
+type hubMessage struct {
+	// Sequence number of the message. Snapshots have the sequence number
+	// of the last message they include.
+	Seq      uint64
+	Snapshot bool
+	// When the message was broadcast, or the snapshot was taken
+	Time time.Time
+	Data string
+	// What Data was made from, for subscribers that filter messages
+	Value any
+}
+
+// subscriber is a websocket or Server-Sent Events client that messages are
+// broadcast to.
+type subscriber struct {
+	messages chan hubMessage
+	ip       string
+	// Closed when the subscriber is evicted
+	evicted chan struct{}
+}
+
+// HubStats are counters of the subscribers of a hub.
+type HubStats struct {
+	// Subscribers that are connected
+	Connected int
+	// Subscribers that have connected, evicted because they fell behind,
+	// and messages queued for subscribers
+	Subscribed uint64
+	Evicted    uint64
+	Messages   uint64
+	// Snapshots sent to subscribers that asked for them after missing
+	// messages
+	Resyncs uint64
+}
+
+// hub broadcasts messages to subscribers without waiting for them. Every
+// subscriber has a bounded queue, and a subscriber whose queue is full is
+// evicted rather than holding up the others.
+//
+// The hub keeps the latest message of every key, such as a capability of a
+// node, and subscribers get a snapshot of those before any broadcasts.
+// Messages are numbered so that subscribers can tell if they have missed
+// any, and the most recent ones are kept so that subscribers can resume
+// after reconnecting. A hub is safe to use from multiple goroutines.
+type hub struct {
+	queueSize int
+
+	mutex       sync.Mutex
+	subscribers map[*subscriber]struct{}
+	seq         uint64
+	state       map[string]string
+	// Ring buffer of recent messages, indexed by their sequence numbers
+	history [hubHistorySize]hubMessage
+
+	subscribed atomic.Uint64
+	evicted    atomic.Uint64
+	messages   atomic.Uint64
+	resyncs    atomic.Uint64
+}
+
+func newHub(queueSize int) *hub {
+	return &hub{
+		queueSize:   queueSize,
+		subscribers: make(map[*subscriber]struct{}),
+		state:       make(map[string]string),
+	}
+}
+
+// subscribe adds a subscriber, which receives messages until it is
+// unsubscribed or evicted. A subscriber that resumes after last receives
+// the messages since then, if they are still kept, and other subscribers
+// start with a snapshot.
+func (h *hub) subscribe(ip string, last *uint64) *subscriber {
+	s := &subscriber{
+		messages: make(chan hubMessage, h.queueSize),
+		ip:       ip,
+		evicted:  make(chan struct{}),
+	}
+	h.mutex.Lock()
+	h.subscribers[s] = struct{}{}
+	replay, ok := h.replay(last)
+	if !ok {
+		replay = []hubMessage{h.snapshot()}
+	}
+	for _, msg := range replay {
+		s.messages <- msg
+	}
+	n := len(h.subscribers)
+	h.mutex.Unlock()
+	h.subscribed.Add(1)
+	if ok {
+		log.Printf("added subscriber %s, %d connected, resuming with %d messages", ip, n, len(replay))
+	} else {
+		log.Printf("added subscriber %s, %d connected", ip, n)
+	}
+	return s
+}
+
+// replay returns the messages after last. It reports false if last is nil,
+// the messages are no longer kept, or there are too many of them to queue.
+// The caller must hold the mutex.
+func (h *hub) replay(last *uint64) ([]hubMessage, bool) {
+	// Sequence numbers start over when goblin restarts
+	if last == nil || *last > h.seq {
+		return nil, false
+	}
+	n := h.seq - *last
+	if n > hubHistorySize || n > uint64(h.queueSize) {
+		return nil, false
+	}
+	messages := make([]hubMessage, 0, n)
+	for seq := *last + 1; seq <= h.seq; seq++ {
+		messages = append(messages, h.history[seq%hubHistorySize])
+	}
+	return messages, true
+}
+
+// unsubscribe removes a subscriber. It is a no-op for subscribers that
+// have been evicted.
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mutex.Lock()
+	_, ok := h.subscribers[s]
+	delete(h.subscribers, s)
+	n := len(h.subscribers)
+	h.mutex.Unlock()
+	if ok {
+		log.Printf("removed subscriber %s, %d connected", s.ip, n)
+	}
+}
+
+// snapshot returns the latest messages of all keys, ordered by key. The
+// caller must hold the mutex.
+func (h *hub) snapshot() hubMessage {
+	keys := make([]string, 0, len(h.state))
+	for key := range h.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var data strings.Builder
+	for _, key := range keys {
+		data.WriteString(h.state[key])
+	}
+	return hubMessage{Seq: h.seq, Snapshot: true, Time: time.Now(), Data: data.String()}
+}
+
+// resync queues a snapshot for a subscriber, after the messages that are
+// already queued.
+func (h *hub) resync(s *subscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.subscribers[s]; ok {
+		h.send(s, h.snapshot())
+		h.resyncs.Add(1)
+	}
+}
+
+// seed sets the message of key unless it already has one, so that the
+// initial state doesn't replace newer broadcasts.
+func (h *hub) seed(key, msg string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.state[key]; !ok {
+		h.state[key] = msg
+	}
+}
+
+// broadcast queues data, made from value, for all subscribers, and evicts
+// those whose queues are full. Unless key is empty, data replaces the
+// message of key in the snapshots.
+func (h *hub) broadcast(key, data string, value any) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if key != "" {
+		h.state[key] = data
+	}
+	h.seq++
+	m := hubMessage{Seq: h.seq, Time: time.Now(), Data: data, Value: value}
+	h.history[h.seq%hubHistorySize] = m
+	for s := range h.subscribers {
+		h.send(s, m)
+	}
+}
+
+// send queues a message for a subscriber, or evicts it if its queue is
+// full. The caller must hold the mutex.
+func (h *hub) send(s *subscriber, msg hubMessage) {
+	select {
+	case s.messages <- msg:
+		h.messages.Add(1)
+	default:
+		delete(h.subscribers, s)
+		close(s.evicted)
+		h.evicted.Add(1)
+		log.Printf("evicted subscriber %s after %d queued messages, %d connected", s.ip, h.queueSize, len(h.subscribers))
+	}
+}
+
+func (h *hub) Stats() HubStats {
+	h.mutex.Lock()
+	n := len(h.subscribers)
+	h.mutex.Unlock()
+	return HubStats{
+		Connected:  n,
+		Subscribed: h.subscribed.Load(),
+		Evicted:    h.evicted.Load(),
+		Messages:   h.messages.Load(),
+		Resyncs:    h.resyncs.Load(),
+	}
+}
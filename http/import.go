@@ -0,0 +1,117 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/importer"
+)
+
+// importMaxMemory is the part of an upload that is kept in memory, the rest
+// is stored in temporary files.
+const importMaxMemory = 32 << 20
+
+// importMapping returns the column mapping of an import request. Form
+// values that are not set keep the default mapping.
+func importMapping(r *http.Request) (importer.Mapping, error) {
+	m := importer.DefaultMapping()
+	columns := map[string]*string{
+		"time_column":        &m.Time,
+		"sensor_column":      &m.SensorId,
+		"capability_column":  &m.Capability,
+		"value_column":       &m.Value,
+		"sensor_name_column": &m.SensorName,
+		"room_column":        &m.Room,
+		"sensor":             &m.DefaultSensorId,
+		"capability":         &m.DefaultCapability,
+		"time_format":        &m.TimeFormat,
+	}
+	for key, field := range columns {
+		if v := r.FormValue(key); v != "" {
+			*field = v
+		}
+	}
+
+	if v := r.FormValue("timezone"); v != "" {
+		location, err := time.LoadLocation(v)
+		if err != nil {
+			return m, fmt.Errorf("invalid timezone %q", v)
+		}
+		m.Location = location
+	}
+
+	if v := r.FormValue("delimiter"); v != "" {
+		if v == `\t` {
+			v = "\t"
+		}
+		if utf8.RuneCountInString(v) != 1 {
+			return m, fmt.Errorf("delimiter must be a single character")
+		}
+		m.Comma, _ = utf8.DecodeRuneInString(v)
+	}
+
+	return m, nil
+}
+
+// importHandler imports readings from an uploaded CSV file and responds
+// with a summary of the import.
+//
+//	POST /import (multipart/form-data with file, dry_run and mapping fields)
+func (s *server) importHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(importMaxMemory); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: %s", err.Error())))
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: %s", err.Error())))
+		return
+	}
+	defer file.Close()
+
+	mapping, err := importMapping(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: %s", err.Error())))
+		return
+	}
+
+	dryRun := false
+	if v := r.FormValue("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("error: invalid dry_run value %q", v)))
+			return
+		}
+	}
+
+	imp := importer.NewImporter(s.RoomService, s.SensorService, s.ReadingService)
+	result, err := imp.Import(r.Context(), file, mapping, dryRun)
+	if err != nil {
+		// Only invalid files are the fault of the client
+		status := http.StatusBadRequest
+		if goblin.ErrorCode(err) != goblin.EINVALID {
+			log.Printf("error importing readings: %s", err.Error())
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(fmt.Sprintf("error: %s", goblin.ErrorMessage(err))))
+		return
+	}
+	// The import may have created sensors and rooms
+	s.sensorRooms.invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("error writing import result: %s", err.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/importer"
+)
+
+// failingReadings is a reading service that fails to store readings.
+type failingReadings struct {
+	goblin.ReadingService
+}
+
+func (failingReadings) CreateReadings(ctx context.Context, readings []*goblin.Reading) (int, error) {
+	return 0, errors.New("disk I/O error")
+}
+
+// importRequest returns a request to import csv with the form values.
+func importRequest(t *testing.T, csv string, values map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, v := range values {
+		if err := form.WriteField(key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if csv != "" {
+		file, err := form.CreateFormFile("file", "readings.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(csv))
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestImportHandler(t *testing.T) {
+	const valid = "time,sensor_id,capability,value\n2024-01-02T00:00:00Z,101,temperature,21\n"
+
+	tests := []struct {
+		name   string
+		csv    string
+		values map[string]string
+		// Stores readings with a failing service
+		failing bool
+		status  int
+		body    string
+	}{
+		{name: "import", csv: valid, status: http.StatusOK},
+		{name: "dry run", csv: valid, values: map[string]string{"dry_run": "true"}, status: http.StatusOK},
+		{name: "no file", status: http.StatusBadRequest, body: "error: http: no such file"},
+		{name: "invalid dry run", csv: valid, values: map[string]string{"dry_run": "maybe"}, status: http.StatusBadRequest, body: `error: invalid dry_run value "maybe"`},
+		{name: "invalid timezone", csv: valid, values: map[string]string{"timezone": "Mars/Olympus"}, status: http.StatusBadRequest, body: `error: invalid timezone "Mars/Olympus"`},
+		{name: "invalid file", csv: "time,value\n", status: http.StatusBadRequest, body: `error: no sensor id column "sensor_id" and no default sensor id`},
+		{name: "invalid row", csv: "time,sensor_id,capability,value\nnow,101,temperature,21\n", status: http.StatusBadRequest, body: `error: line 2: invalid time "now"`},
+		{name: "service failure", csv: valid, failing: true, status: http.StatusInternalServerError, body: "error: Internal error."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if test.failing {
+				s.ReadingService = failingReadings{s.ReadingService}
+			}
+			w := httptest.NewRecorder()
+			s.mux.ServeHTTP(w, importRequest(t, test.csv, test.values))
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+			if test.body != "" && w.Body.String() != test.body {
+				t.Errorf("expected body %q, got %q", test.body, w.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var result importer.Result
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Imported != 1 || result.DryRun != (test.values["dry_run"] == "true") {
+				t.Errorf("expected 1 imported reading, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestImportHandlerInvalidatesSensorRooms(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	if room := s.sensorRoom(ctx, "301"); room != "" {
+		t.Fatalf("expected an unknown sensor to have no room, got %q", room)
+	}
+
+	w := httptest.NewRecorder()
+	csv := "time,sensor_id,capability,value,room\n2024-01-02T00:00:00Z,301,humidity,45,Kitchen\n"
+	s.mux.ServeHTTP(w, importRequest(t, csv, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if room := s.sensorRoom(ctx, "301"); room != "1" {
+		t.Errorf("expected the imported sensor to be in room 1, got %q", room)
+	}
+}
@@ -0,0 +1,20 @@
+package http
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes the routes returned by apiRoutes, with paths
+// relative to /api/v1. It must be updated with the handlers.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the OpenAPI 3 document of the API.
+//
+//	GET /api/openapi.json
+func (s *server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,425 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/inmem"
+	"github.com/maehler/goblin/nexa"
+	"github.com/maehler/goblin/nexa/fakebridge"
+	"nhooyr.io/websocket"
+)
+
+// apiPrefix is the path of the server in openapi.json.
+const apiPrefix = "/api/v1"
+
+// openAPIDocument is the part of openapi.json that the tests use. Schemas
+// are kept as decoded JSON.
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse `json:"responses"`
+		Schemas   map[string]map[string]any  `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema map[string]any `json:"schema"`
+	} `json:"content"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("invalid openapi.json: %s", err)
+	}
+	return &doc
+}
+
+// operations returns the operations of the document as route patterns,
+// such as "GET /api/v1/rooms/{id}".
+func (doc *openAPIDocument) operations() map[string]openAPIOperation {
+	operations := make(map[string]openAPIOperation)
+	for path, item := range doc.Paths {
+		for method, raw := range item {
+			if method == "parameters" {
+				continue
+			}
+			var op openAPIOperation
+			json.Unmarshal(raw, &op)
+			operations[strings.ToUpper(method)+" "+apiPrefix+path] = op
+		}
+	}
+	return operations
+}
+
+// response returns the response of an operation with status, following
+// references to shared responses.
+func (doc *openAPIDocument) response(op openAPIOperation, status int) (openAPIResponse, bool) {
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if ok && response.Ref != "" {
+		response, ok = doc.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return response, ok
+}
+
+// validate checks a decoded JSON value against a schema. It supports the
+// parts of OpenAPI schemas that openapi.json uses.
+func (doc *openAPIDocument) validate(path string, schema map[string]any, value any) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := doc.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+		return doc.validate(path, resolved, value)
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: unexpected null", path)
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			found = found || v == value
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case nil:
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", path, value)
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing property %s", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, v := range object {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				switch additional := schema["additionalProperties"].(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s: unexpected property %s", path, name)
+					}
+					continue
+				case map[string]any:
+					property = additional
+				default:
+					continue
+				}
+			}
+			if err := doc.validate(path+"."+name, property, v); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", path, value)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, v := range array {
+			if err := doc.validate(fmt.Sprintf("%s[%d]", path, i), items, v); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %T", path, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			return fmt.Errorf("%s: expected an integer, got %v", path, value)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s: expected a number, got %T", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", path, value)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported type %v", path, schema["type"])
+	}
+	return nil
+}
+
+// validateResponse checks that the status of a response is documented for
+// the operation, and that its body matches the schema of the status.
+func (doc *openAPIDocument) validateResponse(op openAPIOperation, status int, header http.Header, body []byte) error {
+	response, ok := doc.response(op, status)
+	if !ok {
+		return fmt.Errorf("undocumented status %d: %s", status, body)
+	}
+	if len(response.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("unexpected body for status %d: %s", status, body)
+		}
+		return nil
+	}
+
+	contentType := header.Get("Content-Type")
+	content, ok := response.Content[contentType]
+	if !ok {
+		return fmt.Errorf("undocumented content type %q for status %d", contentType, status)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON for status %d: %s", status, err)
+	}
+	return doc.validate("body", content.Schema, value)
+}
+
+// TestOpenAPIRoutes checks that openapi.json describes the routes of the
+// API and nothing else.
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	operations := doc.operations()
+
+	s := &server{}
+	registered := make(map[string]bool)
+	for _, route := range s.apiRoutes() {
+		registered[route.pattern] = true
+		if _, ok := operations[route.pattern]; !ok {
+			t.Errorf("%s is not in openapi.json", route.pattern)
+		}
+	}
+	for pattern := range operations {
+		if !registered[pattern] {
+			t.Errorf("%s is in openapi.json but isn't registered", pattern)
+		}
+	}
+}
+
+// newTestServer returns a server with in-memory services and a fake Nexa
+// Bridge, seeded with rooms, sensors, readings and events.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	ctx := context.Background()
+
+	bridge := httptest.NewServer(fakebridge.New(fakebridge.DefaultFixtures(), "nexa", "nexa").Handler())
+	t.Cleanup(bridge.Close)
+	u, err := url.Parse(bridge.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := inmem.NewDB()
+	s := NewServer()
+	s.RoomService = inmem.NewRoomService(db)
+	s.SensorService = inmem.NewSensorService(db)
+	s.ReadingService = inmem.NewReadingService(db)
+	s.EventService = inmem.NewEventService(db)
+	s.NexaService = nexa.NewNexaService(nexa.NewNexa(&nexa.NexaConfig{URL: *u, Username: "nexa", Password: "nexa"}))
+
+	for _, room := range []goblin.Room{goblin.NewRoom("1", "Kitchen"), goblin.NewRoom("2", "Bedroom")} {
+		if err := s.RoomService.CreateRoom(ctx, &room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sensor := range []*goblin.Sensor{
+		{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "1", Capabilities: []string{"temperature"}},
+		{Id: "201", Name: "Door", SensorType: "notificationContact", Capabilities: []string{"notificationContact"}},
+	} {
+		if err := s.SensorService.CreateSensor(ctx, sensor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		reading := &goblin.Reading{SensorId: "101", Capability: "temperature", Time: start.Add(time.Duration(i) * time.Minute), Value: 20 + float64(i)/10}
+		if err := s.ReadingService.CreateReading(ctx, reading); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, event := range []*goblin.Event{
+		{SensorId: "201", Capability: "notificationContact", Value: "true", PrevValue: "false", Time: start},
+		{Capability: "sunset", Value: "true", Time: start.Add(time.Hour)},
+	} {
+		if err := s.EventService.CreateEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// TestOpenAPIResponses calls every route of the API and checks the
+// responses against openapi.json.
+func TestOpenAPIResponses(t *testing.T) {
+	doc := loadOpenAPI(t)
+	operations := doc.operations()
+
+	tests := []struct {
+		operation string
+		target    string
+		header    http.Header
+		body      string
+		status    int
+	}{
+		{"GET /api/v1/rooms", "/api/v1/rooms", nil, "", http.StatusOK},
+		{"GET /api/v1/rooms", "/api/v1/rooms?limit=-1", nil, "", http.StatusBadRequest},
+		{"GET /api/v1/rooms", "/api/v1/rooms", http.Header{"Accept": {"text/csv"}}, "", http.StatusNotAcceptable},
+		{"POST /api/v1/rooms", "/api/v1/rooms", nil, `{"id": "3", "name": "Attic"}`, http.StatusCreated},
+		{"POST /api/v1/rooms", "/api/v1/rooms", nil, `{"id": "1", "name": "Kitchen"}`, http.StatusConflict},
+		{"POST /api/v1/rooms", "/api/v1/rooms", nil, `{"id": "3"}`, http.StatusBadRequest},
+		{"POST /api/v1/rooms", "/api/v1/rooms", http.Header{"Content-Type": {"text/plain"}}, `{}`, http.StatusUnsupportedMediaType},
+		{"POST /api/v1/rooms", "/api/v1/rooms", nil, `{"name": "` + strings.Repeat("a", apiMaxBodySize) + `"}`, http.StatusRequestEntityTooLarge},
+		{"GET /api/v1/rooms/{id}", "/api/v1/rooms/1", nil, "", http.StatusOK},
+		{"GET /api/v1/rooms/{id}", "/api/v1/rooms/9", nil, "", http.StatusNotFound},
+		{"PATCH /api/v1/rooms/{id}", "/api/v1/rooms/1", nil, `{"name": "Pantry"}`, http.StatusOK},
+		{"PATCH /api/v1/rooms/{id}", "/api/v1/rooms/9", nil, `{"name": "Pantry"}`, http.StatusNotFound},
+		{"DELETE /api/v1/rooms/{id}", "/api/v1/rooms/2", nil, "", http.StatusNoContent},
+		{"DELETE /api/v1/rooms/{id}", "/api/v1/rooms/9", nil, "", http.StatusNotFound},
+		{"GET /api/v1/sensors", "/api/v1/sensors", nil, "", http.StatusOK},
+		{"GET /api/v1/sensors", "/api/v1/sensors?room=1&removed=false", nil, "", http.StatusOK},
+		{"GET /api/v1/sensors", "/api/v1/sensors?removed=maybe", nil, "", http.StatusBadRequest},
+		{"GET /api/v1/sensors/{id}", "/api/v1/sensors/101", nil, "", http.StatusOK},
+		{"GET /api/v1/sensors/{id}", "/api/v1/sensors/999", nil, "", http.StatusNotFound},
+		{"PATCH /api/v1/sensors/{id}", "/api/v1/sensors/201", nil, `{"name": "Front door", "roomId": "2"}`, http.StatusOK},
+		{"PATCH /api/v1/sensors/{id}", "/api/v1/sensors/101", nil, `{"name": ""}`, http.StatusBadRequest},
+		{"PATCH /api/v1/sensors/{id}", "/api/v1/sensors/101", nil, `{"roomId": "9"}`, http.StatusNotFound},
+		{"DELETE /api/v1/sensors/{id}", "/api/v1/sensors/101", nil, "", http.StatusNoContent},
+		{"DELETE /api/v1/sensors/{id}", "/api/v1/sensors/999", nil, "", http.StatusNotFound},
+		{"GET /api/v1/nodes", "/api/v1/nodes", nil, "", http.StatusOK},
+		{"GET /api/v1/nodes/{id}", "/api/v1/nodes/101", nil, "", http.StatusOK},
+		{"GET /api/v1/nodes/{id}", "/api/v1/nodes/999", nil, "", http.StatusNotFound},
+		{"POST /api/v1/nodes/{id}/switch", "/api/v1/nodes/102/switch", nil, `{"on": true}`, http.StatusNoContent},
+		{"POST /api/v1/nodes/{id}/switch", "/api/v1/nodes/102/switch", nil, `{}`, http.StatusBadRequest},
+		{"POST /api/v1/nodes/{id}/switch", "/api/v1/nodes/999/switch", nil, `{"on": true}`, http.StatusNotFound},
+		{"POST /api/v1/nodes/{id}/level", "/api/v1/nodes/102/level", nil, `{"level": 0.5}`, http.StatusNoContent},
+		{"POST /api/v1/nodes/{id}/level", "/api/v1/nodes/102/level", nil, `{"level": 2}`, http.StatusBadRequest},
+		{"GET /api/v1/readings", "/api/v1/readings?sensor=101&capability=temperature", nil, "", http.StatusOK},
+		{"GET /api/v1/readings", "/api/v1/readings?sensor=101&from=2024-01-01&window=5m", nil, "", http.StatusOK},
+		{"GET /api/v1/readings", "/api/v1/readings?sensor=999&from=2024-01-01", nil, "", http.StatusOK},
+		{"GET /api/v1/readings", "/api/v1/readings?from=yesterday", nil, "", http.StatusBadRequest},
+		{"GET /api/v1/readings", "/api/v1/readings", http.Header{"Accept": {"text/html"}}, "", http.StatusNotAcceptable},
+		{"GET /api/v1/events", "/api/v1/events", nil, "", http.StatusOK},
+		{"GET /api/v1/events", "/api/v1/events?sensor=&order=time", nil, "", http.StatusOK},
+		{"GET /api/v1/events", "/api/v1/events?to=tomorrow", nil, "", http.StatusBadRequest},
+		{"GET /api/v1/stream", "/api/v1/stream", nil, "", http.StatusBadRequest},
+	}
+
+	tested := make(map[string]bool)
+	for _, test := range tests {
+		method, _, _ := strings.Cut(test.operation, " ")
+		t.Run(fmt.Sprintf("%s %s %d", method, test.target, test.status), func(t *testing.T) {
+			op, ok := operations[test.operation]
+			if !ok {
+				t.Fatalf("%s is not in openapi.json", test.operation)
+			}
+			tested[test.operation] = true
+
+			s := newTestServer(t)
+			req := httptest.NewRequest(method, test.target, strings.NewReader(test.body))
+			if test.body != "" {
+				req.Header.Set("Content-Type", jsonContentType)
+			}
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			s.mux.ServeHTTP(w, req)
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+			if err := doc.validateResponse(op, w.Code, w.Header(), w.Body.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("GET /api/v1/stream 101", func(t *testing.T) {
+		op := operations["GET /api/v1/stream"]
+		tested["GET /api/v1/stream"] = true
+		s := newTestServer(t)
+		ts := httptest.NewServer(s.mux)
+		defer ts.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c, resp, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")+"/api/v1/stream", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.CloseNow()
+		if _, ok := doc.response(op, resp.StatusCode); !ok {
+			t.Fatalf("undocumented status %d", resp.StatusCode)
+		}
+
+		requests := []string{
+			`{"type": "subscribe", "id": "temps", "filter": {"rooms": ["1"], "capabilities": ["temperature"]}}`,
+			`{"type": "unsubscribe", "id": "lamps"}`,
+		}
+		for _, r := range requests {
+			if err := c.Write(ctx, websocket.MessageText, []byte(r)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		header := http.Header{"Content-Type": {jsonContentType}}
+		var types []string
+		for range len(requests) + 1 {
+			_, data, err := c.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.validateResponse(op, resp.StatusCode, header, data); err != nil {
+				t.Fatal(err)
+			}
+			var frame streamFrame
+			json.Unmarshal(data, &frame)
+			types = append(types, frame.Type)
+		}
+		sort.Strings(types)
+		if strings.Join(types, " ") != "error hello subscribed" {
+			t.Fatalf("expected hello, subscribed and error frames, got %v", types)
+		}
+	})
+
+	for pattern := range operations {
+		if !tested[pattern] {
+			t.Errorf("%s isn't tested", pattern)
+		}
+	}
+}
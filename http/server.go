@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
-	"sync"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/maehler/goblin"
@@ -26,23 +30,45 @@ var templateFS embed.FS
 //go:embed all:static
 var static embed.FS
 
-type subscriber struct {
-	messages chan string
-	ip       string
-}
-
 type templateHandler struct {
+	// Templates shared by all pages, such as the fragments that are sent
+	// to subscribers
 	templates *template.Template
+	// Pages by name, each with the shared templates and its own blocks of
+	// the layout
+	pages map[string]*template.Template
 }
 
-func newTemplateHandler(fs fs.FS, name string) *templateHandler {
+func newTemplateHandler(fsys fs.FS, name string) *templateHandler {
 	t := template.New("")
 	t.Funcs(template.FuncMap{
 		"has":      hasString,
 		"homeName": func() string { return name },
+		"truthy":   truthy,
+		"percent":  percent,
+		"chartURL": chartURL,
 	})
-	t = template.Must(t.ParseFS(fs, "templates/*.tmpl"))
-	return &templateHandler{t}
+	t = template.Must(t.ParseFS(fsys, "templates/*.tmpl"))
+
+	files, err := fs.Glob(fsys, "templates/pages/*.tmpl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	pages := make(map[string]*template.Template)
+	for _, file := range files {
+		page := template.Must(t.Clone())
+		pages[strings.TrimSuffix(path.Base(file), ".tmpl")] = template.Must(page.ParseFS(fsys, file))
+	}
+	return &templateHandler{t, pages}
+}
+
+// executePage writes the page with name in the layout.
+func (t templateHandler) executePage(w io.Writer, name string, data any) error {
+	page, ok := t.pages[name]
+	if !ok {
+		return fmt.Errorf("page %q not found", name)
+	}
+	return page.ExecuteTemplate(w, "layout.tmpl", data)
 }
 
 func (t templateHandler) HasTemplate(name string) bool {
@@ -55,16 +81,28 @@ func (t templateHandler) HasTemplate(name string) bool {
 }
 
 type server struct {
-	host            string
-	port            int
-	mux             *http.ServeMux
-	subscriberMutex sync.Mutex
-	subscribers     map[subscriber]bool
+	host string
+	port int
+	mux  *http.ServeMux
+	hub  *hub
+	// Hub of the JSON stream of the API
+	stream *hub
+	// Rooms of the sensors, for subscriptions to the stream
+	sensorRooms sensorRooms
 	*templateHandler
 
-	RoomService   goblin.RoomService
-	SensorService goblin.SensorService
-	NexaService   nexa.NexaService
+	// How pages get their updates, unless the transport parameter says
+	// otherwise: ws or sse
+	defaultTransport string
+
+	RoomService    goblin.RoomService
+	SensorService  goblin.SensorService
+	ReadingService goblin.ReadingService
+	EventService   goblin.EventService
+	NexaService    nexa.NexaService
+
+	// Messages from the Nexa Bridge that are broadcast to subscribers
+	Messages <-chan nexa.Message
 }
 
 func hasString(slice []string, value string) bool {
@@ -76,85 +114,227 @@ func hasString(slice []string, value string) bool {
 	return false
 }
 
-func (s *server) nodesHandler(w http.ResponseWriter, r *http.Request) {
-	nodes, err := s.NexaService.Nodes()
+// truthy reports whether a capability value represents an "on" state. The
+// Nexa Bridge reports binary values either as booleans or as numbers.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case string:
+		on, _ := strconv.ParseBool(v)
+		return on
+	}
+	return false
+}
+
+// percent converts a level between 0 and 1 to a percentage.
+func percent(level float64) int {
+	return int(level*100 + 0.5)
+}
+
+func (s *server) roomsHandler(w http.ResponseWriter, r *http.Request) {
+	rooms, err := s.NexaService.Rooms()
 	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
 		return
 	}
-	w.Write([]byte(fmt.Sprintf("%+v", nodes)))
+	if err := s.executePage(w, "rooms", M{"rooms": rooms, "time": time.Now(), "transport": s.transport(r)}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("error executing template:", err.Error())
+	}
 }
 
-func (s *server) roomsHandler(w http.ResponseWriter, r *http.Request) {
+// nexaRoom returns the room with id and its nodes, or nil if there is no
+// such room.
+func (s *server) nexaRoom(id string) (*nexa.NexaRoom, error) {
 	rooms, err := s.NexaService.Rooms()
+	if err != nil {
+		return nil, err
+	}
+	for i := range rooms {
+		if rooms[i].Id == id {
+			return &rooms[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// roomHandler shows the devices of a room, with charts of their readings.
+func (s *server) roomHandler(w http.ResponseWriter, r *http.Request) {
+	room, err := s.nexaRoom(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
+		return
+	} else if room == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("error: room %q not found", r.PathValue("id"))))
+		return
+	}
+	charts, err := s.nodeCharts(r.Context(), "room-"+room.Id, "/rooms/"+room.Id, room.Nodes, chartRanges[0])
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
 		return
 	}
-	if err := s.templates.ExecuteTemplate(w, "layout.tmpl", M{"rooms": rooms, "time": time.Now()}); err != nil {
+	if err := s.executePage(w, "room", M{"room": room, "charts": charts, "time": time.Now(), "transport": s.transport(r)}); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("error executing template:", err.Error())
 	}
 }
 
+// deviceError writes an error from looking up or controlling a device on
+// the Nexa Bridge. Devices that the bridge doesn't have are not found, other
+// errors are failures of the bridge.
+func deviceError(w http.ResponseWriter, id string, err error) {
+	if goblin.ErrorCode(err) == goblin.ENOTFOUND {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("error: device %q not found", id)))
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
+}
+
+// deviceHandler shows the state of a device, with charts of its readings.
 func (s *server) deviceHandler(w http.ResponseWriter, r *http.Request) {
 	device, err := s.NexaService.Node(r.PathValue("id"))
+	if err != nil {
+		deviceError(w, r.PathValue("id"), err)
+		return
+	}
+	var room *nexa.NexaRoom
+	if device.RoomId != "" {
+		if room, err = s.nexaRoom(device.RoomId); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
+			return
+		}
+	}
+	charts, err := s.nodeCharts(r.Context(), "device-"+device.Id, "/devices/"+device.Id, nexa.NexaNodes{device}, chartRanges[0])
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("error: %+v", err.Error())))
 		return
 	}
-	if err := s.templates.ExecuteTemplate(w, "device", device); err != nil {
+	if err := s.executePage(w, "device", M{"device": device, "room": room, "charts": charts, "time": time.Now(), "transport": s.transport(r)}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error executing template: %s", err.Error())
+	}
+}
+
+func (s *server) switchHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	on, err := strconv.ParseBool(r.FormValue("on"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: invalid switch value %q", r.FormValue("on"))))
+		return
+	}
+	if err := s.NexaService.SetSwitch(id, on); err != nil {
+		deviceError(w, id, err)
+		return
+	}
+	msg := &nexa.Message{SourceNode: id, Capability: "switchBinary", Value: on, Time: time.Now()}
+	if err := s.templates.ExecuteTemplate(w, "switchBinary", msg); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("error executing template: %s", err.Error())
 	}
 }
 
-func (s *server) addSubscriber(subscriber *subscriber) {
-	s.subscriberMutex.Lock()
-	s.subscribers[*subscriber] = true
-	s.subscriberMutex.Unlock()
-	log.Printf("added subscriber: %s", subscriber.ip)
+func (s *server) levelHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	level, err := strconv.Atoi(r.FormValue("level"))
+	if err != nil || level < 0 || level > 100 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("error: invalid level %q", r.FormValue("level"))))
+		return
+	}
+	if err := s.NexaService.SetLevel(id, float64(level)/100); err != nil {
+		deviceError(w, id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *server) removeSubscriber(subscriber *subscriber) {
-	s.subscriberMutex.Lock()
-	delete(s.subscribers, *subscriber)
-	s.subscriberMutex.Unlock()
-	log.Printf("removed subscriber: %s", subscriber.ip)
+// HubStats returns counters of the websocket subscribers.
+func (s *server) HubStats() HubStats {
+	return s.hub.Stats()
 }
 
 func (s *server) subscribe(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	var c *websocket.Conn
-	subscriber := &subscriber{
-		messages: make(chan string),
-		ip:       r.RemoteAddr,
-	}
-	s.addSubscriber(subscriber)
-	defer s.removeSubscriber(subscriber)
-
 	c, err := websocket.Accept(w, r, nil)
 	if err != nil {
 		return err
 	}
 	defer c.CloseNow()
 
-	ctx = c.CloseRead(ctx)
+	subscriber := s.hub.subscribe(r.RemoteAddr, nil)
+	defer s.hub.unsubscribe(subscriber)
+
+	// Clients ask for a new snapshot when they have missed messages
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := c.Read(ctx)
+			if err != nil {
+				return
+			}
+			var req struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil || req.Type != "resync" {
+				log.Printf("ignoring websocket message from %s: %q", subscriber.ip, data)
+				continue
+			}
+			s.hub.resync(subscriber)
+		}
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-subscriber.evicted:
+			// htmx reconnects when told to try again later. A client that
+			// has stopped reading won't get the close frame, which isn't
+			// worth logging on top of the eviction
+			c.Close(websocket.StatusTryAgainLater, "client too slow")
+			return nil
 		case msg := <-subscriber.messages:
-			ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-			defer cancel()
-			if err := c.Write(ctx, websocket.MessageText, []byte(msg)); err != nil {
+			if err := writeMessage(ctx, c, msg); err != nil {
 				return err
 			}
 		}
 	}
 }
 
+// snapshotAttribute marks the sequence number element of websocket
+// messages that are snapshots.
+const snapshotAttribute = "data-snapshot"
+
+// writeMessage writes a message to c, giving up after a few seconds. The
+// message is preceded by an element with its sequence number, which is
+// swapped into the page like the rest of the message.
+func writeMessage(ctx context.Context, c *websocket.Conn, msg hubMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	snapshot := ""
+	if msg.Snapshot {
+		snapshot = " " + snapshotAttribute
+	}
+	data := fmt.Sprintf(`<div id="ws-seq" hx-swap-oob="true" data-seq="%d"%s hidden></div>%s`, msg.Seq, snapshot, msg.Data)
+	return c.Write(ctx, websocket.MessageText, []byte(data))
+}
+
 func (s *server) subscribeHandler(w http.ResponseWriter, r *http.Request) {
 	err := s.subscribe(r.Context(), w, r)
 	if errors.Is(err, context.Canceled) {
@@ -171,11 +351,14 @@ func (s *server) subscribeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) broadcast(msg *nexa.Message) error {
-	var useTemplate string
+	// The latest message of each key is part of the snapshots
+	var useTemplate, key string
 	if msg.Capability != "" {
 		useTemplate = msg.Capability
+		key = msg.Id() + "-" + msg.Capability
 	} else if msg.SystemType == "time" {
 		useTemplate = msg.Subtype
+		key = "time-" + msg.Subtype
 	}
 
 	if !s.HasTemplate(useTemplate) {
@@ -188,20 +371,40 @@ func (s *server) broadcast(msg *nexa.Message) error {
 		return err
 	}
 
-	s.subscriberMutex.Lock()
-	defer s.subscriberMutex.Unlock()
-	for subscriber := range s.subscribers {
-		subscriber.messages <- htmlMsg.String()
-	}
+	s.hub.broadcast(key, htmlMsg.String(), msg)
+	return nil
+}
 
+// seedSnapshot renders the last events of all nodes into the snapshots
+// that subscribers get when they connect, for capabilities that haven't
+// been broadcast yet.
+func (s *server) seedSnapshot() error {
+	nodes, err := s.NexaService.Nodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		for capability, event := range node.LastEvents {
+			if event == nil || !s.HasTemplate(capability) {
+				continue
+			}
+			var htmlMsg bytes.Buffer
+			if err := s.templates.ExecuteTemplate(&htmlMsg, capability, event); err != nil {
+				log.Printf("error rendering %s of node %s: %s", capability, node.Id, err.Error())
+				continue
+			}
+			s.hub.seed(node.Id+"-"+capability, htmlMsg.String())
+		}
+	}
 	return nil
 }
 
 type options struct {
-	name     *string
-	database *string
-	host     *string
-	port     *int
+	name      *string
+	database  *string
+	host      *string
+	port      *int
+	transport *string
 }
 
 type Option func(*options) error
@@ -236,6 +439,30 @@ func WithPort(port int) Option {
 	}
 }
 
+// WithTransport sets how pages get their updates by default, either over a
+// websocket (ws) or as Server-Sent Events (sse).
+func WithTransport(transport string) Option {
+	return func(options *options) error {
+		if !validTransport(transport) {
+			return fmt.Errorf("transport must be ws or sse")
+		}
+		options.transport = &transport
+		return nil
+	}
+}
+
+func validTransport(transport string) bool {
+	return transport == "ws" || transport == "sse"
+}
+
+// transport returns how the page requested by r gets its updates.
+func (s *server) transport(r *http.Request) string {
+	if v := r.URL.Query().Get("transport"); validTransport(v) {
+		return v
+	}
+	return s.defaultTransport
+}
+
 func NewServer(opts ...Option) *server {
 	options := options{}
 	for _, o := range opts {
@@ -267,23 +494,38 @@ func NewServer(opts ...Option) *server {
 		port = 3000
 	}
 
+	transport := "ws"
+	if options.transport != nil {
+		transport = *options.transport
+	}
+
 	s := &server{
-		host:            host,
-		port:            port,
-		mux:             http.NewServeMux(),
-		subscribers:     make(map[subscriber]bool),
-		subscriberMutex: sync.Mutex{},
-		templateHandler: newTemplateHandler(templateFS, name),
+		host:             host,
+		port:             port,
+		mux:              http.NewServeMux(),
+		hub:              newHub(subscriberQueueSize),
+		stream:           newHub(subscriberQueueSize),
+		templateHandler:  newTemplateHandler(templateFS, name),
+		defaultTransport: transport,
 	}
 
 	// Pages
 	s.mux.HandleFunc("GET /{$}", s.roomsHandler)
-
-	// API
+	s.mux.HandleFunc("GET /rooms/{id}", s.roomHandler)
 	s.mux.HandleFunc("GET /devices/{id}", s.deviceHandler)
 
-	// Websockets
+	// API
+	s.mux.HandleFunc("GET /rooms/{id}/charts/{capability}", s.roomChartHandler)
+	s.mux.HandleFunc("GET /devices/{id}/charts/{capability}", s.deviceChartHandler)
+	s.mux.HandleFunc("POST /devices/{id}/switch", s.switchHandler)
+	s.mux.HandleFunc("POST /devices/{id}/level", s.levelHandler)
+	s.mux.HandleFunc("GET /export", s.exportHandler)
+	s.mux.HandleFunc("POST /import", s.importHandler)
+	s.registerAPI()
+
+	// Websockets and Server-Sent Events
 	s.mux.HandleFunc("GET /ws", s.subscribeHandler)
+	s.mux.HandleFunc("GET /events", s.eventsHandler)
 
 	// Static files
 	staticFS, err := fs.Sub(static, "static")
@@ -299,27 +541,24 @@ func NewServer(opts ...Option) *server {
 func (s *server) Serve() error {
 	log.Printf("Starting server on %s:%d", s.host, s.port)
 
-	if s.NexaService.Nexa != nil {
-		go func(messages chan nexa.Message) {
+	go func() {
+		if err := s.seedSnapshot(); err != nil {
+			log.Printf("error seeding websocket snapshot: %s", err.Error())
+		}
+	}()
+
+	if s.Messages != nil {
+		go func(messages <-chan nexa.Message) {
 			for msg := range messages {
-				log.Printf("broadcasting to %d subscribers: %s", len(s.subscribers), msg)
+				log.Printf("broadcasting to %d subscribers: %s", s.hub.Stats().Connected, msg)
+				if err := s.publish(&msg); err != nil {
+					log.Println("publish error:", err.Error())
+				}
 				if err := s.broadcast(&msg); err != nil {
 					log.Println("broadcast error:", err.Error())
 				}
 			}
-		}(s.NexaService.Nexa.Messages)
-	}
-
-	// Setting up rooms and sensors in the database
-	rooms, err := s.NexaService.Rooms()
-	if err != nil {
-		return err
-	}
-	for _, room := range rooms {
-		r := goblin.NewRoom(room.Id, room.Name)
-		if err := s.RoomService.CreateRoom(context.Background(), &r); err != nil {
-			log.Println(err)
-		}
+		}(s.Messages)
 	}
 
 	return http.ListenAndServe(
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeviceNotFound(t *testing.T) {
+	s := newTestServer(t)
+	for _, test := range []struct {
+		method string
+		target string
+		form   string
+	}{
+		{http.MethodGet, "/devices/999", ""},
+		{http.MethodGet, "/devices/999/charts/temperature", ""},
+		{http.MethodPost, "/devices/999/switch", "on=true"},
+		{http.MethodPost, "/devices/999/level", "level=50"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		s.mux.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected status %d, got %d: %s", test.method, test.target, http.StatusNotFound, w.Code, w.Body)
+		}
+	}
+}
+
+func TestFormatEventSnapshot(t *testing.T) {
+	event := formatEvent(hubMessage{Seq: 3, Snapshot: true, Data: "<div></div>"})
+	if !strings.Contains(event, "event: "+sseSnapshotEvent+"\n") {
+		t.Errorf("snapshot isn't a %s event: %q", sseSnapshotEvent, event)
+	}
+	event = formatEvent(hubMessage{Seq: 4, Data: "<div></div>"})
+	if strings.Contains(event, "event: ") {
+		t.Errorf("message has an event type: %q", event)
+	}
+}
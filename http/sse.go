@@ -0,0 +1,97 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseHeartbeat is how often a comment is sent on an idle event stream,
+// which keeps proxies from closing it.
+const sseHeartbeat = 15 * time.Second
+
+// eventsHandler streams the messages that are broadcast to websocket
+// subscribers as Server-Sent Events, for browsers and proxies that can't
+// keep a websocket open. Events are numbered like websocket messages, and
+// a client that reconnects with the Last-Event-ID header gets the events
+// it missed, or a snapshot if they are no longer kept.
+//
+//	GET /events
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	var last *uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if seq, err := strconv.ParseUint(v, 10, 64); err == nil {
+			last = &seq
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		log.Printf("error: event stream can't be flushed: %s", err.Error())
+		return
+	}
+
+	subscriber := s.hub.subscribe(r.RemoteAddr, last)
+	defer s.hub.unsubscribe(subscriber)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var event string
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscriber.evicted:
+			// The client reconnects by itself, and resumes if the events
+			// it missed are still kept
+			return
+		case <-heartbeat.C:
+			event = ": heartbeat\n\n"
+		case msg := <-subscriber.messages:
+			event = formatEvent(msg)
+		}
+
+		// Writes to a client that has stopped reading give up after a few
+		// seconds, like writes to websockets
+		rc.SetWriteDeadline(time.Now().Add(3 * time.Second))
+		_, err := io.WriteString(w, event)
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Printf("error writing event stream to %s: %s", subscriber.ip, err.Error())
+			return
+		}
+	}
+}
+
+// sseSnapshotEvent is the type of the events that are snapshots. Other
+// events have the default type, message.
+const sseSnapshotEvent = "snapshot"
+
+// formatEvent formats a message as an event with its sequence number as
+// id. Every line of the message is a data field of its own.
+func formatEvent(msg hubMessage) string {
+	var event strings.Builder
+	fmt.Fprintf(&event, "id: %d\n", msg.Seq)
+	if msg.Snapshot {
+		fmt.Fprintf(&event, "event: %s\n", sseSnapshotEvent)
+	}
+	for _, line := range strings.Split(msg.Data, "\n") {
+		event.WriteString("data: ")
+		event.WriteString(strings.TrimSuffix(line, "\r"))
+		event.WriteString("\n")
+	}
+	event.WriteString("\n")
+	return event.String()
+}
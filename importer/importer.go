@@ -0,0 +1,435 @@
+// Package importer reads historical readings from CSV files into goblin's
+// services.
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// DefaultBatchSize is the number of readings that are stored at a time.
+const DefaultBatchSize = 1000
+
+// Mapping describes which CSV columns hold which fields. Columns are named
+// by their header. The default mapping reads files written by goblin
+// export.
+type Mapping struct {
+	Time       string
+	SensorId   string
+	Capability string
+	Value      string
+	// Optional columns with the name of the sensor and the room it is in,
+	// used when sensors and rooms are created.
+	SensorName string
+	Room       string
+
+	// Used for files that don't have a sensor id or capability column,
+	// such as the log of a single thermometer.
+	DefaultSensorId   string
+	DefaultCapability string
+
+	// Layout of the time column as for time.Parse. If empty, RFC 3339,
+	// "2006-01-02 15:04:05", "2006-01-02 15:04" and Unix seconds are
+	// tried.
+	TimeFormat string
+	// Location of times without a time zone. Defaults to local time.
+	Location *time.Location
+	// Field delimiter. Defaults to a comma.
+	Comma rune
+}
+
+func DefaultMapping() Mapping {
+	return Mapping{
+		Time:       "time",
+		SensorId:   "sensor_id",
+		Capability: "capability",
+		Value:      "value",
+		SensorName: "sensor_name",
+		Room:       "room",
+		Location:   time.Local,
+		Comma:      ',',
+	}
+}
+
+// timeLayouts are tried in order when Mapping.TimeFormat is empty.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+func (m Mapping) parseTime(s string) (time.Time, error) {
+	location := m.Location
+	if location == nil {
+		location = time.Local
+	}
+	if m.TimeFormat != "" {
+		return time.ParseInLocation(m.TimeFormat, s, location)
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, location); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, goblin.Errorf(goblin.EINVALID, "invalid time %q", s)
+}
+
+// parseValue parses a number with either a decimal point or a decimal
+// comma, as written by spreadsheets in many locales.
+func parseValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return 0, goblin.Errorf(goblin.EINVALID, "invalid value %q", s)
+	}
+	return v, nil
+}
+
+// Result summarises an import.
+type Result struct {
+	DryRun bool `json:"dryRun"`
+	// Number of rows read, not counting the header
+	Rows int `json:"rows"`
+	// Number of readings stored, or that would be stored in a dry run
+	Imported int `json:"imported"`
+	// Number of readings that were already stored or appear more than
+	// once in the file
+	Duplicates int `json:"duplicates"`
+	// Ids of the sensors and rooms that were created
+	CreatedSensors []string `json:"createdSensors"`
+	CreatedRooms   []string `json:"createdRooms"`
+}
+
+// Importer stores readings from CSV files, creating the sensors and rooms
+// that they refer to.
+type Importer struct {
+	RoomService    goblin.RoomService
+	SensorService  goblin.SensorService
+	ReadingService goblin.ReadingService
+	BatchSize      int
+}
+
+func NewImporter(roomService goblin.RoomService, sensorService goblin.SensorService, readingService goblin.ReadingService) *Importer {
+	return &Importer{
+		RoomService:    roomService,
+		SensorService:  sensorService,
+		ReadingService: readingService,
+		BatchSize:      DefaultBatchSize,
+	}
+}
+
+// columns holds the indices of the mapped columns, -1 if missing.
+type columns struct {
+	time, sensorId, capability, value, sensorName, room int
+}
+
+func (m Mapping) columns(header []string) (columns, error) {
+	index := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return i
+			}
+		}
+		return -1
+	}
+	c := columns{
+		time:       index(m.Time),
+		sensorId:   index(m.SensorId),
+		capability: index(m.Capability),
+		value:      index(m.Value),
+		sensorName: index(m.SensorName),
+		room:       index(m.Room),
+	}
+
+	if c.time < 0 {
+		return c, goblin.Errorf(goblin.EINVALID, "no time column %q", m.Time)
+	}
+	if c.value < 0 {
+		return c, goblin.Errorf(goblin.EINVALID, "no value column %q", m.Value)
+	}
+	if c.sensorId < 0 && m.DefaultSensorId == "" {
+		return c, goblin.Errorf(goblin.EINVALID, "no sensor id column %q and no default sensor id", m.SensorId)
+	}
+	if c.capability < 0 && m.DefaultCapability == "" {
+		return c, goblin.Errorf(goblin.EINVALID, "no capability column %q and no default capability", m.Capability)
+	}
+	return c, nil
+}
+
+// field returns the value of column i in record, or fallback if the column
+// is missing or empty.
+func field(record []string, i int, fallback string) string {
+	if i < 0 || i >= len(record) {
+		return fallback
+	}
+	if v := strings.TrimSpace(record[i]); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// importer holds the state of a single import.
+type importer struct {
+	*Importer
+	dryRun  bool
+	result  *Result
+	sensors map[string]bool
+	rooms   map[string]string
+	seen    map[seriesTime]bool
+}
+
+type seriesTime struct {
+	sensorId, capability string
+	time                 int64
+}
+
+// Import reads readings from r according to the mapping and stores them in
+// batches. Sensors and rooms that don't exist are created. In a dry run,
+// nothing is stored but the result tells what would have been. The import
+// stops at the first invalid row; the batches before it have been stored.
+func (i *Importer) Import(ctx context.Context, r io.Reader, m Mapping, dryRun bool) (*Result, error) {
+	reader := csv.NewReader(r)
+	if m.Comma != 0 {
+		reader.Comma = m.Comma
+	}
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, goblin.Errorf(goblin.EINVALID, "read header: %s", err.Error())
+	}
+	cols, err := m.columns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := i.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	imp := &importer{
+		Importer: i,
+		dryRun:   dryRun,
+		result: &Result{
+			DryRun:         dryRun,
+			CreatedSensors: []string{},
+			CreatedRooms:   []string{},
+		},
+		sensors: make(map[string]bool),
+		rooms:   make(map[string]string),
+		seen:    make(map[seriesTime]bool),
+	}
+
+	batch := make([]*goblin.Reading, 0, batchSize)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imp.result, goblin.Errorf(goblin.EINVALID, "%s", err.Error())
+		}
+		imp.result.Rows++
+		line, _ := reader.FieldPos(0)
+
+		reading := &goblin.Reading{
+			SensorId:   field(record, cols.sensorId, m.DefaultSensorId),
+			Capability: field(record, cols.capability, m.DefaultCapability),
+		}
+		if reading.SensorId == "" || reading.Capability == "" {
+			return imp.result, goblin.Errorf(goblin.EINVALID, "line %d: missing sensor id or capability", line)
+		}
+		if reading.Time, err = m.parseTime(field(record, cols.time, "")); err != nil {
+			return imp.result, goblin.Errorf(goblin.EINVALID, "line %d: %s", line, err.Error())
+		}
+		if reading.Value, err = parseValue(field(record, cols.value, "")); err != nil {
+			return imp.result, goblin.Errorf(goblin.EINVALID, "line %d: %s", line, err.Error())
+		}
+
+		key := seriesTime{reading.SensorId, reading.Capability, reading.Time.UnixMilli()}
+		if imp.seen[key] {
+			imp.result.Duplicates++
+			continue
+		}
+		imp.seen[key] = true
+
+		roomName := field(record, cols.room, "")
+		sensorName := field(record, cols.sensorName, reading.SensorId)
+		if err := imp.ensureSensor(ctx, reading, sensorName, roomName); err != nil {
+			return imp.result, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		batch = append(batch, reading)
+		if len(batch) == batchSize {
+			if err := imp.store(ctx, batch); err != nil {
+				return imp.result, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := imp.store(ctx, batch); err != nil {
+		return imp.result, err
+	}
+
+	return imp.result, nil
+}
+
+// ensureRoom returns the id of the room with the id or name, creating it
+// if it doesn't exist.
+func (imp *importer) ensureRoom(ctx context.Context, room string) (string, error) {
+	if id, ok := imp.rooms[room]; ok {
+		return id, nil
+	}
+
+	rooms, err := imp.RoomService.FindRooms(ctx, goblin.RoomFilter{Id: &room})
+	if err != nil {
+		return "", err
+	}
+	if len(rooms) == 0 {
+		if rooms, err = imp.RoomService.FindRooms(ctx, goblin.RoomFilter{Name: &room}); err != nil {
+			return "", err
+		}
+	}
+
+	id := room
+	if len(rooms) > 0 {
+		id = rooms[0].Id
+	} else {
+		imp.result.CreatedRooms = append(imp.result.CreatedRooms, room)
+		if !imp.dryRun {
+			r := goblin.NewRoom(room, room)
+			if err := imp.RoomService.CreateRoom(ctx, &r); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	imp.rooms[room] = id
+	return id, nil
+}
+
+// ensureSensor creates the sensor of reading if it doesn't exist.
+func (imp *importer) ensureSensor(ctx context.Context, reading *goblin.Reading, name, room string) error {
+	if _, ok := imp.sensors[reading.SensorId]; ok {
+		return nil
+	}
+
+	sensors, err := imp.SensorService.FindSensors(ctx, goblin.SensorFilter{Id: &reading.SensorId})
+	if err != nil {
+		return err
+	}
+	exists := len(sensors) > 0
+	imp.sensors[reading.SensorId] = exists
+	if exists {
+		return nil
+	}
+
+	roomId := ""
+	if room != "" {
+		if roomId, err = imp.ensureRoom(ctx, room); err != nil {
+			return err
+		}
+	}
+
+	imp.result.CreatedSensors = append(imp.result.CreatedSensors, reading.SensorId)
+	if imp.dryRun {
+		return nil
+	}
+	return imp.SensorService.CreateSensor(ctx, &goblin.Sensor{
+		Id:           reading.SensorId,
+		Name:         name,
+		SensorType:   reading.Capability,
+		RoomId:       roomId,
+		Capabilities: []string{reading.Capability},
+	})
+}
+
+// store stores a batch of readings. In a dry run, the readings that are
+// already stored are looked up instead.
+func (imp *importer) store(ctx context.Context, batch []*goblin.Reading) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if !imp.dryRun {
+		n, err := imp.ReadingService.CreateReadings(ctx, batch)
+		if err != nil {
+			return err
+		}
+		imp.result.Imported += n
+		imp.result.Duplicates += len(batch) - n
+		return nil
+	}
+
+	existing, err := imp.existing(ctx, batch)
+	if err != nil {
+		return err
+	}
+	for _, reading := range batch {
+		if existing[seriesTime{reading.SensorId, reading.Capability, reading.Time.UnixMilli()}] {
+			imp.result.Duplicates++
+		} else {
+			imp.result.Imported++
+		}
+	}
+	return nil
+}
+
+// existing returns the readings in batch that are already stored.
+func (imp *importer) existing(ctx context.Context, batch []*goblin.Reading) (map[seriesTime]bool, error) {
+	type series struct{ sensorId, capability string }
+	type span struct{ from, to time.Time }
+	spans := make(map[series]span)
+	for _, r := range batch {
+		// Sensors that are created by the import have no readings
+		if !imp.sensors[r.SensorId] {
+			continue
+		}
+		k := series{r.SensorId, r.Capability}
+		s, ok := spans[k]
+		if !ok || r.Time.Before(s.from) {
+			s.from = r.Time
+		}
+		if !ok || r.Time.After(s.to) {
+			s.to = r.Time
+		}
+		spans[k] = s
+	}
+
+	existing := make(map[seriesTime]bool)
+	raw := time.Duration(0)
+	for k, s := range spans {
+		to := s.to.Add(time.Millisecond)
+		readings, err := imp.ReadingService.FindReadings(ctx, goblin.ReadingFilter{
+			SensorId:   &k.sensorId,
+			Capability: &k.capability,
+			From:       &s.from,
+			To:         &to,
+			Resolution: &raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range readings {
+			existing[seriesTime{r.SensorId, r.Capability, r.Time.UnixMilli()}] = true
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,271 @@
+package importer_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/importer"
+	"github.com/maehler/goblin/inmem"
+)
+
+type services struct {
+	rooms    *inmem.RoomService
+	sensors  *inmem.SensorService
+	readings *inmem.ReadingService
+}
+
+// newServices returns services with a thermometer in the kitchen, which
+// has readings at midnight and a minute past midnight on 2024-01-01.
+func newServices(t *testing.T) services {
+	t.Helper()
+	ctx := context.Background()
+	db := inmem.NewDB()
+	s := services{inmem.NewRoomService(db), inmem.NewSensorService(db), inmem.NewReadingService(db)}
+
+	room := goblin.NewRoom("1", "Kitchen")
+	if err := s.rooms.CreateRoom(ctx, &room); err != nil {
+		t.Fatal(err)
+	}
+	sensor := &goblin.Sensor{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "1", Capabilities: []string{"temperature"}}
+	if err := s.sensors.CreateSensor(ctx, sensor); err != nil {
+		t.Fatal(err)
+	}
+	for i, value := range []float64{20, 20.5} {
+		reading := &goblin.Reading{SensorId: "101", Capability: "temperature", Time: time.Date(2024, 1, 1, 0, i, 0, 0, time.UTC), Value: value}
+		if err := s.readings.CreateReading(ctx, reading); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// storedReadings returns the stored readings formatted as "sensor
+// capability time value", sorted.
+func (s services) storedReadings(t *testing.T) []string {
+	t.Helper()
+	readings, err := s.readings.FindReadings(context.Background(), goblin.ReadingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]string, 0, len(readings))
+	for _, r := range readings {
+		stored = append(stored, fmt.Sprintf("%s %s %s %g", r.SensorId, r.Capability, r.Time.UTC().Format(time.RFC3339), r.Value))
+	}
+	slices.Sort(stored)
+	return stored
+}
+
+// storedSensors returns the sensors formatted as "id name room".
+func (s services) storedSensors(t *testing.T) []string {
+	t.Helper()
+	sensors, err := s.sensors.FindSensors(context.Background(), goblin.SensorFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]string, 0, len(sensors))
+	for _, sensor := range sensors {
+		stored = append(stored, sensor.Id+" "+sensor.Name+" "+sensor.RoomId)
+	}
+	slices.Sort(stored)
+	return stored
+}
+
+// The readings that newServices stores
+var (
+	midnight       = "101 temperature 2024-01-01T00:00:00Z 20"
+	minuteMidnight = "101 temperature 2024-01-01T00:01:00Z 20.5"
+	thermometer    = "101 Thermometer 1"
+)
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		mapping func(m *importer.Mapping)
+		dryRun  bool
+		want    importer.Result
+		// Readings and sensors stored after the import
+		readings []string
+		sensors  []string
+	}{
+		{
+			name: "default mapping",
+			csv: "time,sensor_id,capability,value,sensor_name,room\n" +
+				"2024-01-01T00:02:00Z,101,temperature,21,,\n" +
+				"2024-01-01T00:00:00Z,301,humidity,45,Hygrometer,Bathroom\n" +
+				"2024-01-01T00:00:00Z,302,temperature,19,,Kitchen\n",
+			want: importer.Result{Rows: 3, Imported: 3, CreatedSensors: []string{"301", "302"}, CreatedRooms: []string{"Bathroom"}},
+			readings: []string{
+				midnight, minuteMidnight,
+				"101 temperature 2024-01-01T00:02:00Z 21",
+				"301 humidity 2024-01-01T00:00:00Z 45",
+				"302 temperature 2024-01-01T00:00:00Z 19",
+			},
+			sensors: []string{thermometer, "301 Hygrometer Bathroom", "302 302 1"},
+		},
+		{
+			name: "custom columns",
+			csv: "Tid;Temperatur\n" +
+				"02.01.2024 10:30;21,5\n" +
+				"02.01.2024 11:30;-3\n",
+			mapping: func(m *importer.Mapping) {
+				m.Time, m.Value = "tid", "temperatur"
+				m.DefaultSensorId, m.DefaultCapability = "101", "temperature"
+				m.TimeFormat = "02.01.2006 15:04"
+				m.Location = time.FixedZone("CET", 3600)
+				m.Comma = ';'
+			},
+			want: importer.Result{Rows: 2, Imported: 2, CreatedSensors: []string{}, CreatedRooms: []string{}},
+			readings: []string{
+				midnight, minuteMidnight,
+				"101 temperature 2024-01-02T09:30:00Z 21.5",
+				"101 temperature 2024-01-02T10:30:00Z -3",
+			},
+			sensors: []string{thermometer},
+		},
+		{
+			name: "time formats",
+			csv: "time,value\n" +
+				"2024-01-02T00:00:00+01:00,1\n" +
+				"2024-01-02T01:00:00,2\n" +
+				"2024-01-02 02:00:00,3\n" +
+				"2024-01-02 03:00,4\n" +
+				"1704168000,5\n",
+			mapping: func(m *importer.Mapping) {
+				m.DefaultSensorId, m.DefaultCapability = "101", "temperature"
+				m.Location = time.UTC
+			},
+			want: importer.Result{Rows: 5, Imported: 5, CreatedSensors: []string{}, CreatedRooms: []string{}},
+			readings: []string{
+				midnight, minuteMidnight,
+				"101 temperature 2024-01-01T23:00:00Z 1",
+				"101 temperature 2024-01-02T01:00:00Z 2",
+				"101 temperature 2024-01-02T02:00:00Z 3",
+				"101 temperature 2024-01-02T03:00:00Z 4",
+				"101 temperature 2024-01-02T04:00:00Z 5",
+			},
+			sensors: []string{thermometer},
+		},
+		{
+			name: "duplicates",
+			csv: "time,sensor_id,capability,value\n" +
+				"2024-01-01T00:00:00Z,101,temperature,30\n" +
+				"2024-01-01T00:02:00Z,101,temperature,22\n" +
+				"2024-01-01T00:02:00Z,101,temperature,23\n" +
+				"2024-01-01T00:00:00Z,101,humidity,40\n",
+			want: importer.Result{Rows: 4, Imported: 2, Duplicates: 2, CreatedSensors: []string{}, CreatedRooms: []string{}},
+			readings: []string{
+				"101 humidity 2024-01-01T00:00:00Z 40",
+				midnight, minuteMidnight,
+				"101 temperature 2024-01-01T00:02:00Z 22",
+			},
+			sensors: []string{thermometer},
+		},
+		{
+			name: "dry run",
+			csv: "time,sensor_id,capability,value,sensor_name,room\n" +
+				"2024-01-01T00:01:00Z,101,temperature,30,,\n" +
+				"2024-01-01T00:02:00Z,101,temperature,22,,\n" +
+				"2024-01-01T00:02:00Z,101,temperature,23,,\n" +
+				"2024-01-01T00:00:00Z,301,humidity,45,Hygrometer,Bathroom\n",
+			dryRun:   true,
+			want:     importer.Result{DryRun: true, Rows: 4, Imported: 2, Duplicates: 2, CreatedSensors: []string{"301"}, CreatedRooms: []string{"Bathroom"}},
+			readings: []string{midnight, minuteMidnight},
+			sensors:  []string{thermometer},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newServices(t)
+			mapping := importer.DefaultMapping()
+			if test.mapping != nil {
+				test.mapping(&mapping)
+			}
+			imp := importer.NewImporter(s.rooms, s.sensors, s.readings)
+			// Small batches so that duplicates span batches
+			imp.BatchSize = 2
+
+			result, err := imp.Import(context.Background(), strings.NewReader(test.csv), mapping, test.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*result, test.want) {
+				t.Errorf("expected result %+v, got %+v", test.want, *result)
+			}
+			if readings := s.storedReadings(t); !reflect.DeepEqual(readings, test.readings) {
+				t.Errorf("expected readings %q, got %q", test.readings, readings)
+			}
+			if sensors := s.storedSensors(t); !reflect.DeepEqual(sensors, test.sensors) {
+				t.Errorf("expected sensors %q, got %q", test.sensors, sensors)
+			}
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		mapping func(m *importer.Mapping)
+		err     string
+	}{
+		{
+			name: "empty file",
+			csv:  "",
+			err:  "read header: EOF",
+		},
+		{
+			name: "no time column",
+			csv:  "sensor_id,capability,value\n",
+			err:  `no time column "time"`,
+		},
+		{
+			name: "no sensor column",
+			csv:  "time,capability,value\n",
+			err:  `no sensor id column "sensor_id" and no default sensor id`,
+		},
+		{
+			name: "invalid time",
+			csv:  "time,sensor_id,capability,value\n2024-01-01T00:02:00Z,101,temperature,21\nyesterday,101,temperature,21\n",
+			err:  `line 3: invalid time "yesterday"`,
+		},
+		{
+			name:    "time not in the time format",
+			csv:     "time,sensor_id,capability,value\n2024-01-01T00:02:00Z,101,temperature,21\n",
+			mapping: func(m *importer.Mapping) { m.TimeFormat = "02.01.2006" },
+			err:     "line 2: ",
+		},
+		{
+			name: "invalid value",
+			csv:  "time,sensor_id,capability,value\n2024-01-01T00:02:00Z,101,temperature,warm\n",
+			err:  `line 2: invalid value "warm"`,
+		},
+		{
+			name: "missing capability",
+			csv:  "time,sensor_id,capability,value\n2024-01-01T00:02:00Z,101,,21\n",
+			err:  "line 2: missing sensor id or capability",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newServices(t)
+			mapping := importer.DefaultMapping()
+			if test.mapping != nil {
+				test.mapping(&mapping)
+			}
+			imp := importer.NewImporter(s.rooms, s.sensors, s.readings)
+			_, err := imp.Import(context.Background(), strings.NewReader(test.csv), mapping, false)
+			if goblin.ErrorCode(err) != goblin.EINVALID || !strings.HasPrefix(goblin.ErrorMessage(err), test.err) {
+				t.Errorf("expected an invalid error starting with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Package inmem implements the goblin services in memory, for tests and
+// demos that shouldn't touch disk. The services behave like those in the
+// sqlite package, including their filters, ordering and errors, but
+// readings are aggregated on the fly rather than rolled up.
+package inmem
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// DB holds the data shared by the services. It is safe for concurrent use.
+type DB struct {
+	mutex    sync.RWMutex
+	rooms    map[string]*goblin.Room
+	sensors  map[string]*goblin.Sensor
+	readings map[readingKey]float64
+	events   []*goblin.Event
+	eventId  int
+
+	// Sensors whose names and rooms have been edited, and sensors that
+	// have been deleted, which are kept or skipped when they are synced
+	edited  map[string]sensorEdits
+	deleted map[string]bool
+}
+
+type sensorEdits struct {
+	name, room bool
+}
+
+type readingKey struct {
+	sensorId   string
+	capability string
+	// Unix milliseconds, the precision of stored times
+	time int64
+}
+
+func NewDB() *DB {
+	return &DB{
+		rooms:    make(map[string]*goblin.Room),
+		sensors:  make(map[string]*goblin.Sensor),
+		readings: make(map[readingKey]float64),
+		edited:   make(map[string]sensorEdits),
+		deleted:  make(map[string]bool),
+	}
+}
+
+// normalizeTime returns t as it is stored: in UTC with millisecond
+// precision.
+func normalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+// parseOrderBy returns the column and direction of orderBy, which is a
+// column name optionally prefixed with "-" for descending order. Only the
+// columns in allowed may be used, and the first of them is the default.
+func parseOrderBy(orderBy string, allowed ...string) (string, bool, error) {
+	if orderBy == "" {
+		return allowed[0], false, nil
+	}
+	column, desc := strings.CutPrefix(orderBy, "-")
+	for _, c := range allowed {
+		if c == column {
+			return column, desc, nil
+		}
+	}
+	return "", false, goblin.Errorf(goblin.EINVALID, "cannot order by %q", orderBy)
+}
+
+// sortBy sorts items by the key of each item, in descending order if desc
+// is set. Items with equal keys keep their order.
+func sortBy[T any](items []T, desc bool, key func(T) string) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if desc {
+			return key(items[i]) > key(items[j])
+		}
+		return key(items[i]) < key(items[j])
+	})
+}
+
+// limitOffset returns the part of items selected by limit and offset,
+// which are ignored if they are zero.
+func limitOffset[T any](items []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return items[:0]
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,115 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+
+	"github.com/maehler/goblin"
+)
+
+type EventService struct {
+	db *DB
+}
+
+func NewEventService(db *DB) *EventService {
+	return &EventService{db}
+}
+
+func (s *EventService) CreateEvent(ctx context.Context, event *goblin.Event) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if event.Capability == "" {
+		return goblin.Errorf(goblin.EINVALID, "event has no capability")
+	}
+	if event.SensorId != "" {
+		if _, ok := s.db.sensors[event.SensorId]; !ok {
+			return goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", event.SensorId)
+		}
+	}
+
+	s.db.eventId++
+	event.Id = s.db.eventId
+
+	stored := *event
+	stored.Time = normalizeTime(event.Time)
+	s.db.events = append(s.db.events, &stored)
+	return nil
+}
+
+func (s *EventService) FindEvents(ctx context.Context, filter goblin.EventFilter) ([]*goblin.Event, error) {
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+
+	return s.db.findEvents(filter)
+}
+
+func (s *EventService) CountEvents(ctx context.Context, filter goblin.EventFilter) (int, error) {
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+
+	n := 0
+	for _, event := range s.db.events {
+		if s.db.matchEvent(event, filter) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (db *DB) matchEvent(event *goblin.Event, filter goblin.EventFilter) bool {
+	if v := filter.Id; v != nil && event.Id != *v {
+		return false
+	}
+	if v := filter.SensorId; v != nil && event.SensorId != *v {
+		return false
+	}
+	if v := filter.RoomId; v != nil {
+		sensor, ok := db.sensors[event.SensorId]
+		if !ok || sensor.RoomId != *v {
+			return false
+		}
+	}
+	if v := filter.Capability; v != nil && event.Capability != *v {
+		return false
+	}
+	if v := filter.Value; v != nil && event.Value != *v {
+		return false
+	}
+	if v := filter.From; v != nil && event.Time.Before(normalizeTime(*v)) {
+		return false
+	}
+	if v := filter.To; v != nil && !event.Time.Before(normalizeTime(*v)) {
+		return false
+	}
+	return true
+}
+
+func (db *DB) findEvents(filter goblin.EventFilter) ([]*goblin.Event, error) {
+	column, desc, err := parseOrderBy(filter.OrderBy, "time", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*goblin.Event, 0)
+	for _, event := range db.events {
+		if db.matchEvent(event, filter) {
+			e := *event
+			events = append(events, &e)
+		}
+	}
+
+	// Events at the same time are in the order they were stored
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if desc {
+			a, b = b, a
+		}
+		if column == "time" && !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.Id < b.Id
+	})
+
+	return limitOffset(events, filter.Limit, filter.Offset), nil
+}
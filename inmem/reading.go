@@ -0,0 +1,263 @@
+package inmem
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+type ReadingService struct {
+	db *DB
+}
+
+func NewReadingService(db *DB) *ReadingService {
+	return &ReadingService{db}
+}
+
+func (s *ReadingService) CreateReading(ctx context.Context, reading *goblin.Reading) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if err := s.db.checkReading(reading); err != nil {
+		return err
+	}
+
+	key := newReadingKey(reading)
+	if _, ok := s.db.readings[key]; ok {
+		return goblin.Errorf(goblin.ECONFLICT, "reading of %s %s at %s already exists", reading.SensorId, reading.Capability, reading.Time.Format(time.RFC3339Nano))
+	}
+	s.db.readings[key] = reading.Value
+	return nil
+}
+
+// CreateReadings stores readings, skipping readings that are already stored
+// for the same sensor, capability and time. Either all or none of the
+// readings are stored. It returns the number of readings that were stored.
+func (s *ReadingService) CreateReadings(ctx context.Context, readings []*goblin.Reading) (int, error) {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	for _, reading := range readings {
+		if err := s.db.checkReading(reading); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for _, reading := range readings {
+		key := newReadingKey(reading)
+		if _, ok := s.db.readings[key]; ok {
+			continue
+		}
+		s.db.readings[key] = reading.Value
+		n++
+	}
+
+	return n, nil
+}
+
+func (s *ReadingService) FindReadings(ctx context.Context, filter goblin.ReadingFilter) ([]*goblin.Reading, error) {
+	readings := make([]*goblin.Reading, 0)
+	err := s.StreamReadings(ctx, filter, func(reading *goblin.Reading) error {
+		readings = append(readings, reading)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// StreamReadings calls fn for each reading matching filter, in the same
+// order as FindReadings. The matching readings are collected before fn is
+// called, so fn may use the other services. It stops at the first error
+// returned by fn.
+func (s *ReadingService) StreamReadings(ctx context.Context, filter goblin.ReadingFilter, fn func(*goblin.Reading) error) error {
+	readings, err := s.db.findReadings(filter)
+	if err != nil {
+		return err
+	}
+
+	for _, reading := range readings {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(reading); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newReadingKey(reading *goblin.Reading) readingKey {
+	return readingKey{
+		sensorId:   reading.SensorId,
+		capability: reading.Capability,
+		time:       normalizeTime(reading.Time).UnixMilli(),
+	}
+}
+
+// checkReading returns an error if reading can't be stored.
+func (db *DB) checkReading(reading *goblin.Reading) error {
+	if reading.Capability == "" {
+		return goblin.Errorf(goblin.EINVALID, "reading has no capability")
+	}
+	if _, ok := db.sensors[reading.SensorId]; !ok {
+		return goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", reading.SensorId)
+	}
+	return nil
+}
+
+func (db *DB) findReadings(filter goblin.ReadingFilter) ([]*goblin.Reading, error) {
+	var res time.Duration
+	if v := filter.Resolution; v != nil {
+		res = *v
+		if res < 0 || res%time.Second != 0 {
+			return nil, goblin.Errorf(goblin.EINVALID, "unsupported resolution %s", res)
+		}
+	} else if filter.From != nil {
+		to := time.Now()
+		if filter.To != nil {
+			to = *filter.To
+		}
+		res = goblin.ResolutionForSpan(to.Sub(*filter.From))
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if res == 0 {
+		return db.rawReadings(filter), nil
+	}
+	return db.aggregatedReadings(filter, res), nil
+}
+
+// matchReading reports whether the reading with key matches the conditions
+// of filter other than the time range.
+func (db *DB) matchReading(key readingKey, filter goblin.ReadingFilter) bool {
+	if v := filter.SensorId; v != nil && key.sensorId != *v {
+		return false
+	}
+	if v := filter.Capability; v != nil && key.capability != *v {
+		return false
+	}
+	if v := filter.SensorIds; v != nil && !slices.Contains(v, key.sensorId) {
+		return false
+	}
+	if v := filter.RoomIds; v != nil {
+		sensor, ok := db.sensors[key.sensorId]
+		if !ok || sensor.RoomId == "" || !slices.Contains(v, sensor.RoomId) {
+			return false
+		}
+	}
+	if v := filter.Capabilities; v != nil && !slices.Contains(v, key.capability) {
+		return false
+	}
+	return true
+}
+
+// inRange reports whether the time t, in Unix milliseconds, is within from
+// and to, which are ignored if nil.
+func inRange(t int64, from, to *time.Time) bool {
+	if from != nil && t < normalizeTime(*from).UnixMilli() {
+		return false
+	}
+	if to != nil && t >= normalizeTime(*to).UnixMilli() {
+		return false
+	}
+	return true
+}
+
+// sortReadings sorts readings by time, sensor and capability.
+func sortReadings(readings []*goblin.Reading) {
+	sort.Slice(readings, func(i, j int) bool {
+		a, b := readings[i], readings[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if a.SensorId != b.SensorId {
+			return a.SensorId < b.SensorId
+		}
+		return a.Capability < b.Capability
+	})
+}
+
+func (db *DB) rawReadings(filter goblin.ReadingFilter) []*goblin.Reading {
+	readings := make([]*goblin.Reading, 0)
+	for key, value := range db.readings {
+		if !db.matchReading(key, filter) || !inRange(key.time, filter.From, filter.To) {
+			continue
+		}
+		readings = append(readings, &goblin.Reading{
+			SensorId:   key.sensorId,
+			Capability: key.capability,
+			Time:       time.UnixMilli(key.time).UTC(),
+			Value:      value,
+			Min:        value,
+			Max:        value,
+		})
+	}
+	sortReadings(readings)
+	return readings
+}
+
+// truncateWindow returns the start of the window of length window that t,
+// in Unix milliseconds, falls in. Windows are aligned to the Unix epoch.
+func truncateWindow(t int64, window time.Duration) int64 {
+	ms := window.Milliseconds()
+	start := t / ms * ms
+	if t < 0 && t%ms != 0 {
+		start -= ms
+	}
+	return start
+}
+
+// aggregatedReadings returns readings aggregated into windows of length
+// window. The window that From falls in is included in full.
+func (db *DB) aggregatedReadings(filter goblin.ReadingFilter, window time.Duration) []*goblin.Reading {
+	var from *time.Time
+	if v := filter.From; v != nil {
+		t := time.UnixMilli(truncateWindow(normalizeTime(*v).UnixMilli(), window))
+		from = &t
+	}
+
+	type aggregate struct {
+		min, max, sum float64
+		count         int
+	}
+	aggregates := make(map[readingKey]*aggregate)
+	for key, value := range db.readings {
+		if !db.matchReading(key, filter) || !inRange(key.time, from, filter.To) {
+			continue
+		}
+		bucket := readingKey{key.sensorId, key.capability, truncateWindow(key.time, window)}
+		a, ok := aggregates[bucket]
+		if !ok {
+			a = &aggregate{min: math.Inf(1), max: math.Inf(-1)}
+			aggregates[bucket] = a
+		}
+		a.min = math.Min(a.min, value)
+		a.max = math.Max(a.max, value)
+		a.sum += value
+		a.count++
+	}
+
+	readings := make([]*goblin.Reading, 0, len(aggregates))
+	for key, a := range aggregates {
+		readings = append(readings, &goblin.Reading{
+			SensorId:   key.sensorId,
+			Capability: key.capability,
+			Time:       time.UnixMilli(key.time).UTC(),
+			Value:      a.sum / float64(a.count),
+			Resolution: window,
+			Min:        a.min,
+			Max:        a.max,
+		})
+	}
+	sortReadings(readings)
+	return readings
+}
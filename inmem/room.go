@@ -0,0 +1,153 @@
+package inmem
+
+import (
+	"context"
+
+	"github.com/maehler/goblin"
+)
+
+type RoomService struct {
+	db *DB
+}
+
+func NewRoomService(db *DB) *RoomService {
+	return &RoomService{db}
+}
+
+func (s *RoomService) RoomById(ctx context.Context, id string) (*goblin.Room, error) {
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+
+	room, err := s.db.roomById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.attachRoomSensors(room); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+func (s *RoomService) FindRooms(ctx context.Context, filter goblin.RoomFilter) ([]*goblin.Room, error) {
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+
+	rooms, err := s.db.findRooms(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.attachRoomSensors(rooms...); err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
+}
+
+// CreateRoom inserts the room, or renames it if it already exists.
+func (s *RoomService) CreateRoom(ctx context.Context, room *goblin.Room) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	s.db.rooms[room.Id] = &goblin.Room{Id: room.Id, Name: room.Name}
+	return nil
+}
+
+func (s *RoomService) UpdateRoom(ctx context.Context, id string, update goblin.RoomUpdate) (*goblin.Room, error) {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	room, ok := s.db.rooms[id]
+	if !ok {
+		return nil, goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", id)
+	}
+
+	if v := update.Name; v != nil {
+		room.Name = *v
+	}
+
+	updated := copyRoom(room)
+	if err := s.db.attachRoomSensors(updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteRoom deletes the room. Sensors in the room are kept, but are no
+// longer assigned to a room.
+func (s *RoomService) DeleteRoom(ctx context.Context, id string) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if _, ok := s.db.rooms[id]; !ok {
+		return goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", id)
+	}
+
+	for _, sensor := range s.db.sensors {
+		if sensor.RoomId == id {
+			sensor.RoomId = ""
+		}
+	}
+	delete(s.db.rooms, id)
+	return nil
+}
+
+func copyRoom(room *goblin.Room) *goblin.Room {
+	return &goblin.Room{Id: room.Id, Name: room.Name}
+}
+
+func (db *DB) roomById(id string) (*goblin.Room, error) {
+	rooms, err := db.findRooms(goblin.RoomFilter{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rooms) == 0 {
+		return nil, goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", id)
+	}
+
+	return rooms[0], nil
+}
+
+func (db *DB) findRooms(filter goblin.RoomFilter) ([]*goblin.Room, error) {
+	column, desc, err := parseOrderBy(filter.OrderBy, "id", "name")
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]*goblin.Room, 0)
+	for _, room := range db.rooms {
+		if v := filter.Id; v != nil && room.Id != *v {
+			continue
+		}
+		if v := filter.Name; v != nil && room.Name != *v {
+			continue
+		}
+		rooms = append(rooms, copyRoom(room))
+	}
+
+	sortBy(rooms, false, func(r *goblin.Room) string { return r.Id })
+	sortBy(rooms, desc, func(r *goblin.Room) string {
+		if column == "name" {
+			return r.Name
+		}
+		return r.Id
+	})
+
+	return limitOffset(rooms, filter.Limit, filter.Offset), nil
+}
+
+// attachRoomSensors sets the sensors of each room.
+func (db *DB) attachRoomSensors(rooms ...*goblin.Room) error {
+	for _, room := range rooms {
+		sensors, err := db.findSensors(goblin.SensorFilter{RoomId: &room.Id})
+		if err != nil {
+			return err
+		}
+		room.Sensors = sensors
+	}
+	return nil
+}
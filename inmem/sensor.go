@@ -0,0 +1,206 @@
+package inmem
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+type SensorService struct {
+	db *DB
+}
+
+func NewSensorService(db *DB) *SensorService {
+	return &SensorService{db}
+}
+
+func (s *SensorService) SensorById(ctx context.Context, id string) (*goblin.Sensor, error) {
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+
+	return s.db.sensorById(id)
+}
+
+func (s *SensorService) FindSensors(ctx context.Context, filter goblin.SensorFilter) ([]*goblin.Sensor, error) {
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+
+	return s.db.findSensors(filter)
+}
+
+// CreateSensor inserts the sensor, or updates it if it already exists. A
+// sensor that was previously marked as removed or deleted is restored.
+func (s *SensorService) CreateSensor(ctx context.Context, sensor *goblin.Sensor) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if sensor.RoomId != "" {
+		if _, ok := s.db.rooms[sensor.RoomId]; !ok {
+			return goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", sensor.RoomId)
+		}
+	}
+
+	sensor.RemovedAt = nil
+	s.db.sensors[sensor.Id] = copySensor(sensor)
+	delete(s.db.deleted, sensor.Id)
+	return nil
+}
+
+func (s *SensorService) SyncSensor(ctx context.Context, sensor *goblin.Sensor) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if s.db.deleted[sensor.Id] {
+		return goblin.Errorf(goblin.ECONFLICT, "sensor with id %s has been deleted", sensor.Id)
+	}
+	synced := copySensor(sensor)
+	synced.RemovedAt = nil
+	if prev, ok := s.db.sensors[sensor.Id]; ok {
+		edits := s.db.edited[sensor.Id]
+		if edits.name {
+			synced.Name = prev.Name
+		}
+		if edits.room {
+			synced.RoomId = prev.RoomId
+		}
+	}
+	if synced.RoomId != "" {
+		if _, ok := s.db.rooms[synced.RoomId]; !ok {
+			return goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", synced.RoomId)
+		}
+	}
+	s.db.sensors[sensor.Id] = synced
+	*sensor = *copySensor(synced)
+	return nil
+}
+
+// DeleteSensor deletes the sensor and all of its readings and events.
+func (s *SensorService) DeleteSensor(ctx context.Context, id string) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	if _, err := s.db.sensorById(id); err != nil {
+		return err
+	}
+
+	for key := range s.db.readings {
+		if key.sensorId == id {
+			delete(s.db.readings, key)
+		}
+	}
+	s.db.events = slices.DeleteFunc(s.db.events, func(e *goblin.Event) bool {
+		return e.SensorId == id
+	})
+	delete(s.db.sensors, id)
+	delete(s.db.edited, id)
+	s.db.deleted[id] = true
+	return nil
+}
+
+func (s *SensorService) UpdateSensor(ctx context.Context, id string, update goblin.SensorUpdate) (*goblin.Sensor, error) {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	sensor, ok := s.db.sensors[id]
+	if !ok {
+		return nil, goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", id)
+	}
+
+	if v := update.RoomId; v != nil && *v != "" {
+		if _, err := s.db.roomById(*v); err != nil {
+			return nil, err
+		}
+	}
+
+	// Edited names and rooms are kept when the sensor is synced
+	edits := s.db.edited[id]
+	if v := update.Name; v != nil {
+		sensor.Name = *v
+		edits.name = true
+	}
+	if v := update.RoomId; v != nil {
+		sensor.RoomId = *v
+		edits.room = true
+	}
+	s.db.edited[id] = edits
+
+	return copySensor(sensor), nil
+}
+
+func (s *SensorService) MarkSensorRemoved(ctx context.Context, id string, removedAt time.Time) error {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	sensor, ok := s.db.sensors[id]
+	if !ok {
+		return goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", id)
+	}
+
+	t := normalizeTime(removedAt)
+	sensor.RemovedAt = &t
+	return nil
+}
+
+func copySensor(sensor *goblin.Sensor) *goblin.Sensor {
+	c := *sensor
+	c.Capabilities = slices.Clone(sensor.Capabilities)
+	if c.Capabilities == nil {
+		c.Capabilities = []string{}
+	}
+	if sensor.RemovedAt != nil {
+		t := normalizeTime(*sensor.RemovedAt)
+		c.RemovedAt = &t
+	}
+	return &c
+}
+
+func (db *DB) sensorById(id string) (*goblin.Sensor, error) {
+	sensors, err := db.findSensors(goblin.SensorFilter{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sensors) == 0 {
+		return nil, goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", id)
+	}
+
+	return sensors[0], nil
+}
+
+func (db *DB) findSensors(filter goblin.SensorFilter) ([]*goblin.Sensor, error) {
+	column, desc, err := parseOrderBy(filter.OrderBy, "id", "name", "sensor_type", "room_id")
+	if err != nil {
+		return nil, err
+	}
+
+	sensors := make([]*goblin.Sensor, 0)
+	for _, sensor := range db.sensors {
+		if v := filter.Id; v != nil && sensor.Id != *v {
+			continue
+		}
+		if v := filter.RoomId; v != nil && sensor.RoomId != *v {
+			continue
+		}
+		if v := filter.Removed; v != nil && (sensor.RemovedAt != nil) != *v {
+			continue
+		}
+		sensors = append(sensors, copySensor(sensor))
+	}
+
+	sortBy(sensors, false, func(s *goblin.Sensor) string { return s.Id })
+	sortBy(sensors, desc, func(s *goblin.Sensor) string {
+		switch column {
+		case "name":
+			return s.Name
+		case "sensor_type":
+			return s.SensorType
+		case "room_id":
+			return s.RoomId
+		}
+		return s.Id
+	})
+
+	return limitOffset(sensors, filter.Limit, filter.Offset), nil
+}
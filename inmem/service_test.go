@@ -0,0 +1,20 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/maehler/goblin/inmem"
+	"github.com/maehler/goblin/internal/servicetest"
+)
+
+func TestServices(t *testing.T) {
+	servicetest.Run(t, func(t *testing.T) servicetest.Services {
+		db := inmem.NewDB()
+		return servicetest.Services{
+			Rooms:    inmem.NewRoomService(db),
+			Sensors:  inmem.NewSensorService(db),
+			Readings: inmem.NewReadingService(db),
+			Events:   inmem.NewEventService(db),
+		}
+	})
+}
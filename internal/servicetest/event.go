@@ -0,0 +1,99 @@
+package servicetest
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+func eventCapability(event *goblin.Event) string {
+	return event.Capability
+}
+
+func testEvents(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	events := []*goblin.Event{
+		{SensorId: "201", Capability: "notificationContact", Value: "open", Time: base},
+		{SensorId: "201", Capability: "notificationContact", Value: "closed", PrevValue: "open", Time: base.Add(time.Minute)},
+		{SensorId: "102", Capability: "switchBinary", Value: "true", Time: base.Add(time.Minute)},
+		{Capability: "sun", Value: "set", Time: base.Add(2 * time.Minute)},
+		// Stored after the first event, at the same time
+		{SensorId: "301", Capability: "button", Value: "pushed", Time: base},
+	}
+	for i, event := range events {
+		must(t, s.Events.CreateEvent(ctx, event))
+		if i > 0 && event.Id <= events[i-1].Id {
+			t.Fatalf("expected increasing event ids, got %d after %d", event.Id, events[i-1].Id)
+		}
+	}
+	// eventName identifies an event by its position in events
+	eventName := func(event *goblin.Event) string {
+		for i, e := range events {
+			if e.Id == event.Id {
+				return "e" + strconv.Itoa(i)
+			}
+		}
+		return "unknown"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		filter   goblin.EventFilter
+		expected []string
+	}{
+		{"all", goblin.EventFilter{}, []string{"e0", "e4", "e1", "e2", "e3"}},
+		{"id", goblin.EventFilter{Id: ptr(events[2].Id)}, []string{"e2"}},
+		{"sensor", goblin.EventFilter{SensorId: ptr("201")}, []string{"e0", "e1"}},
+		{"system", goblin.EventFilter{SensorId: ptr("")}, []string{"e3"}},
+		{"room", goblin.EventFilter{RoomId: ptr("1")}, []string{"e2"}},
+		{"capability and value", goblin.EventFilter{Capability: ptr("notificationContact"), Value: ptr("closed")}, []string{"e1"}},
+		{"time range", goblin.EventFilter{From: ptr(base.Add(time.Minute)), To: ptr(base.Add(2 * time.Minute))}, []string{"e1", "e2"}},
+		{"order by time descending", goblin.EventFilter{OrderBy: "-time"}, []string{"e3", "e2", "e1", "e4", "e0"}},
+		{"order by id", goblin.EventFilter{OrderBy: "id"}, []string{"e0", "e1", "e2", "e3", "e4"}},
+		{"order by id descending", goblin.EventFilter{OrderBy: "-id"}, []string{"e4", "e3", "e2", "e1", "e0"}},
+		{"limit and offset", goblin.EventFilter{Offset: 1, Limit: 2}, []string{"e4", "e1"}},
+		{"offset past the end", goblin.EventFilter{Offset: 5}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := s.Events.FindEvents(ctx, tc.filter)
+			must(t, err)
+			expectIds(t, found, eventName, tc.expected...)
+		})
+	}
+
+	t.Run("fields", func(t *testing.T) {
+		found, err := s.Events.FindEvents(ctx, goblin.EventFilter{Id: ptr(events[1].Id)})
+		must(t, err)
+		if len(found) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(found))
+		}
+		e := found[0]
+		if e.SensorId != "201" || e.Capability != "notificationContact" || e.Value != "closed" ||
+			e.PrevValue != "open" || !e.Time.Equal(base.Add(time.Minute)) {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		// Counts ignore ordering and pagination
+		n, err := s.Events.CountEvents(ctx, goblin.EventFilter{SensorId: ptr("201"), OrderBy: "-time", Offset: 1, Limit: 1})
+		must(t, err)
+		if n != 2 {
+			t.Fatalf("expected 2 events, got %d", n)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := s.Events.FindEvents(ctx, goblin.EventFilter{OrderBy: "value"})
+		expectCode(t, err, goblin.EINVALID)
+		err = s.Events.CreateEvent(ctx, &goblin.Event{SensorId: "201", Value: "open", Time: base})
+		expectCode(t, err, goblin.EINVALID)
+		err = s.Events.CreateEvent(ctx, &goblin.Event{SensorId: "999", Capability: "notificationContact", Value: "open", Time: base})
+		expectCode(t, err, goblin.ENOTFOUND)
+	})
+}
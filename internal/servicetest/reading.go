@@ -0,0 +1,275 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// readingSeries identifies a reading by its sensor and capability.
+func readingSeries(reading *goblin.Reading) string {
+	return reading.SensorId + "/" + reading.Capability
+}
+
+// readingString identifies a reading by its series, time and value.
+func readingString(reading *goblin.Reading) string {
+	return fmt.Sprintf("%s@%s=%g", readingSeries(reading), reading.Time.Sub(base), reading.Value)
+}
+
+// seedReadings stores the readings that the reading tests use, and
+// returns them.
+func seedReadings(t *testing.T, s Services) []*goblin.Reading {
+	t.Helper()
+	seed(t, s)
+
+	readings := []*goblin.Reading{
+		{SensorId: "101", Capability: "temperature", Time: base, Value: 20},
+		{SensorId: "101", Capability: "humidity", Time: base, Value: 40},
+		{SensorId: "101", Capability: "temperature", Time: base.Add(time.Minute), Value: 21},
+		{SensorId: "301", Capability: "temperature", Time: base.Add(time.Minute), Value: 5},
+		{SensorId: "102", Capability: "power", Time: base.Add(5 * time.Minute), Value: 10},
+		{SensorId: "101", Capability: "temperature", Time: base.Add(10 * time.Minute), Value: 22},
+		{SensorId: "101", Capability: "temperature", Time: base.Add(70 * time.Minute), Value: 23},
+	}
+	n, err := s.Readings.CreateReadings(context.Background(), readings)
+	must(t, err)
+	if n != len(readings) {
+		t.Fatalf("expected %d readings to be stored, got %d", len(readings), n)
+	}
+	return readings
+}
+
+func testCreateReadings(t *testing.T, s Services) {
+	ctx := context.Background()
+	seedReadings(t, s)
+
+	// Readings that are already stored are skipped
+	n, err := s.Readings.CreateReadings(ctx, []*goblin.Reading{
+		{SensorId: "101", Capability: "temperature", Time: base, Value: 30},
+		{SensorId: "101", Capability: "temperature", Time: base.Add(2 * time.Minute), Value: 30},
+	})
+	must(t, err)
+	if n != 1 {
+		t.Fatalf("expected 1 reading to be stored, got %d", n)
+	}
+	readings, err := s.Readings.FindReadings(ctx, goblin.ReadingFilter{SensorId: ptr("101"), Capability: ptr("temperature")})
+	must(t, err)
+	expectIds(t, readings, readingString,
+		"101/temperature@0s=20",
+		"101/temperature@1m0s=21",
+		"101/temperature@2m0s=30",
+		"101/temperature@10m0s=22",
+		"101/temperature@1h10m0s=23",
+	)
+
+	// Unlike CreateReadings, CreateReading doesn't skip stored readings
+	err = s.Readings.CreateReading(ctx, &goblin.Reading{SensorId: "101", Capability: "temperature", Time: base, Value: 30})
+	expectCode(t, err, goblin.ECONFLICT)
+
+	err = s.Readings.CreateReading(ctx, &goblin.Reading{SensorId: "999", Capability: "temperature", Time: base, Value: 30})
+	expectCode(t, err, goblin.ENOTFOUND)
+	_, err = s.Readings.CreateReadings(ctx, []*goblin.Reading{
+		{SensorId: "101", Capability: "temperature", Time: base.Add(time.Hour), Value: 30},
+		{SensorId: "999", Capability: "temperature", Time: base, Value: 30},
+	})
+	expectCode(t, err, goblin.ENOTFOUND)
+
+	err = s.Readings.CreateReading(ctx, &goblin.Reading{SensorId: "101", Time: base, Value: 30})
+	expectCode(t, err, goblin.EINVALID)
+	_, err = s.Readings.CreateReadings(ctx, []*goblin.Reading{{SensorId: "101", Time: base, Value: 30}})
+	expectCode(t, err, goblin.EINVALID)
+}
+
+func testReadings(t *testing.T, s Services) {
+	ctx := context.Background()
+	seedReadings(t, s)
+
+	raw := time.Duration(0)
+	for _, tc := range []struct {
+		name     string
+		filter   goblin.ReadingFilter
+		expected []string
+	}{
+		{"all", goblin.ReadingFilter{}, []string{
+			"101/humidity@0s=40",
+			"101/temperature@0s=20",
+			"101/temperature@1m0s=21",
+			"301/temperature@1m0s=5",
+			"102/power@5m0s=10",
+			"101/temperature@10m0s=22",
+			"101/temperature@1h10m0s=23",
+		}},
+		{"sensor and capability", goblin.ReadingFilter{SensorId: ptr("101"), Capability: ptr("humidity")}, []string{
+			"101/humidity@0s=40",
+		}},
+		{"sensors and capabilities", goblin.ReadingFilter{SensorIds: []string{"101", "301"}, Capabilities: []string{"temperature"}}, []string{
+			"101/temperature@0s=20",
+			"101/temperature@1m0s=21",
+			"301/temperature@1m0s=5",
+			"101/temperature@10m0s=22",
+			"101/temperature@1h10m0s=23",
+		}},
+		{"rooms", goblin.ReadingFilter{RoomIds: []string{"1", "3"}, Capabilities: []string{"humidity", "power"}}, []string{
+			"101/humidity@0s=40",
+			"102/power@5m0s=10",
+		}},
+		{"no sensors", goblin.ReadingFilter{SensorIds: []string{}}, nil},
+		{"no rooms", goblin.ReadingFilter{RoomIds: []string{}}, nil},
+		{"no capabilities", goblin.ReadingFilter{Capabilities: []string{}}, nil},
+		// From is inclusive and To exclusive
+		{"time range", goblin.ReadingFilter{From: ptr(base.Add(time.Minute)), To: ptr(base.Add(10 * time.Minute))}, []string{
+			"101/temperature@1m0s=21",
+			"301/temperature@1m0s=5",
+			"102/power@5m0s=10",
+		}},
+		{"raw over a long span", goblin.ReadingFilter{SensorId: ptr("102"), From: ptr(base), To: ptr(base.Add(365 * 24 * time.Hour)), Resolution: &raw}, []string{
+			"102/power@5m0s=10",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			readings, err := s.Readings.FindReadings(ctx, tc.filter)
+			must(t, err)
+			expectIds(t, readings, readingString, tc.expected...)
+			for _, reading := range readings {
+				if reading.Resolution != 0 || reading.Min != reading.Value || reading.Max != reading.Value {
+					t.Fatalf("expected a raw reading, got %+v", reading)
+				}
+			}
+		})
+	}
+
+	t.Run("times", func(t *testing.T) {
+		readings, err := s.Readings.FindReadings(ctx, goblin.ReadingFilter{SensorId: ptr("102")})
+		must(t, err)
+		expected := base.Add(5 * time.Minute)
+		if len(readings) != 1 || !readings[0].Time.Equal(expected) || readings[0].Time.Location() != time.UTC {
+			t.Fatalf("expected a reading at %s in UTC, got %+v", expected, readings)
+		}
+	})
+
+	t.Run("invalid resolution", func(t *testing.T) {
+		for _, res := range []time.Duration{-time.Minute, 1500 * time.Millisecond} {
+			_, err := s.Readings.FindReadings(ctx, goblin.ReadingFilter{Resolution: &res})
+			expectCode(t, err, goblin.EINVALID)
+		}
+	})
+}
+
+// aggregate describes an aggregated reading by its series, window, and
+// mean, minimum and maximum value.
+func aggregate(reading *goblin.Reading) string {
+	return fmt.Sprintf("%s@%s/%s=%g[%g,%g]", readingSeries(reading), reading.Time.Sub(base), reading.Resolution, reading.Value, reading.Min, reading.Max)
+}
+
+func testAggregatedReadings(t *testing.T, s Services) {
+	ctx := context.Background()
+	seedReadings(t, s)
+
+	temperature := goblin.ReadingFilter{SensorId: ptr("101"), Capability: ptr("temperature")}
+	withFilter := func(f func(*goblin.ReadingFilter)) goblin.ReadingFilter {
+		filter := temperature
+		f(&filter)
+		return filter
+	}
+
+	for _, tc := range []struct {
+		name     string
+		filter   goblin.ReadingFilter
+		expected []string
+	}{
+		{"hourly", withFilter(func(f *goblin.ReadingFilter) {
+			f.Resolution = ptr(time.Hour)
+		}), []string{
+			"101/temperature@0s/1h0m0s=21[20,22]",
+			"101/temperature@1h0m0s/1h0m0s=23[23,23]",
+		}},
+		{"any whole number of seconds", withFilter(func(f *goblin.ReadingFilter) {
+			f.Resolution = ptr(10 * time.Minute)
+		}), []string{
+			"101/temperature@0s/10m0s=20.5[20,21]",
+			"101/temperature@10m0s/10m0s=22[22,22]",
+			"101/temperature@1h10m0s/10m0s=23[23,23]",
+		}},
+		// The window that From falls in is included in full
+		{"from within a window", withFilter(func(f *goblin.ReadingFilter) {
+			f.Resolution = ptr(time.Hour)
+			f.From = ptr(base.Add(30 * time.Minute))
+		}), []string{
+			"101/temperature@0s/1h0m0s=21[20,22]",
+			"101/temperature@1h0m0s/1h0m0s=23[23,23]",
+		}},
+		{"to", withFilter(func(f *goblin.ReadingFilter) {
+			f.Resolution = ptr(time.Hour)
+			f.To = ptr(base.Add(5 * time.Minute))
+		}), []string{
+			"101/temperature@0s/1h0m0s=20.5[20,21]",
+		}},
+		{"several series", goblin.ReadingFilter{Capabilities: []string{"temperature"}, Resolution: ptr(24 * time.Hour)}, []string{
+			"101/temperature@0s/24h0m0s=21.5[20,23]",
+			"301/temperature@0s/24h0m0s=5[5,5]",
+		}},
+		// Resolutions are picked from the span when they aren't set
+		{"picked for a long span", withFilter(func(f *goblin.ReadingFilter) {
+			f.From = ptr(base)
+			f.To = ptr(base.Add(7 * 24 * time.Hour))
+		}), []string{
+			"101/temperature@0s/1h0m0s=21[20,22]",
+			"101/temperature@1h0m0s/1h0m0s=23[23,23]",
+		}},
+		{"raw for a short span", withFilter(func(f *goblin.ReadingFilter) {
+			f.From = ptr(base)
+			f.To = ptr(base.Add(2 * time.Minute))
+		}), []string{
+			"101/temperature@0s/0s=20[20,20]",
+			"101/temperature@1m0s/0s=21[21,21]",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			readings, err := s.Readings.FindReadings(ctx, tc.filter)
+			must(t, err)
+			expectIds(t, readings, aggregate, tc.expected...)
+		})
+	}
+}
+
+func testStreamReadings(t *testing.T, s Services) {
+	ctx := context.Background()
+	seedReadings(t, s)
+
+	for _, filter := range []goblin.ReadingFilter{
+		{},
+		{Resolution: ptr(time.Hour)},
+	} {
+		expected, err := s.Readings.FindReadings(ctx, filter)
+		must(t, err)
+
+		var streamed []*goblin.Reading
+		must(t, s.Readings.StreamReadings(ctx, filter, func(reading *goblin.Reading) error {
+			streamed = append(streamed, reading)
+			return nil
+		}))
+		ids := make([]string, len(expected))
+		for i, reading := range expected {
+			ids[i] = aggregate(reading)
+		}
+		expectIds(t, streamed, aggregate, ids...)
+	}
+
+	// Streaming stops at the first error
+	stop := errors.New("stop")
+	n := 0
+	err := s.Readings.StreamReadings(ctx, goblin.ReadingFilter{}, func(reading *goblin.Reading) error {
+		n++
+		if n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || n != 2 {
+		t.Fatalf("expected streaming to stop after 2 readings with the error, got %d readings and %v", n, err)
+	}
+}
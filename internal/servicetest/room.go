@@ -0,0 +1,135 @@
+package servicetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/maehler/goblin"
+)
+
+func roomId(room *goblin.Room) string {
+	return room.Id
+}
+
+func testRooms(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	for _, tc := range []struct {
+		name     string
+		filter   goblin.RoomFilter
+		expected []string
+	}{
+		{"all", goblin.RoomFilter{}, []string{"1", "2", "3"}},
+		{"id", goblin.RoomFilter{Id: ptr("2")}, []string{"2"}},
+		{"name", goblin.RoomFilter{Name: ptr("Attic")}, []string{"3"}},
+		{"unknown name", goblin.RoomFilter{Name: ptr("Garage")}, nil},
+		{"order by name", goblin.RoomFilter{OrderBy: "name"}, []string{"3", "2", "1"}},
+		{"order by name descending", goblin.RoomFilter{OrderBy: "-name"}, []string{"1", "2", "3"}},
+		{"order by id descending", goblin.RoomFilter{OrderBy: "-id"}, []string{"3", "2", "1"}},
+		{"limit", goblin.RoomFilter{Limit: 2}, []string{"1", "2"}},
+		{"offset", goblin.RoomFilter{Offset: 2}, []string{"3"}},
+		{"limit and offset", goblin.RoomFilter{OrderBy: "name", Offset: 1, Limit: 1}, []string{"2"}},
+		{"offset past the end", goblin.RoomFilter{Offset: 5}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rooms, err := s.Rooms.FindRooms(ctx, tc.filter)
+			must(t, err)
+			expectIds(t, rooms, roomId, tc.expected...)
+		})
+	}
+
+	t.Run("invalid order", func(t *testing.T) {
+		_, err := s.Rooms.FindRooms(ctx, goblin.RoomFilter{OrderBy: "size"})
+		expectCode(t, err, goblin.EINVALID)
+	})
+
+	t.Run("sensors", func(t *testing.T) {
+		rooms, err := s.Rooms.FindRooms(ctx, goblin.RoomFilter{})
+		must(t, err)
+		expectIds(t, rooms[0].Sensors, sensorId, "101", "102")
+		expectIds(t, rooms[1].Sensors, sensorId, "201")
+		// Rooms without sensors have an empty list rather than none
+		if rooms[2].Sensors == nil || len(rooms[2].Sensors) != 0 {
+			t.Fatalf("expected no sensors in room 3, got %v", rooms[2].Sensors)
+		}
+
+		room, err := s.Rooms.RoomById(ctx, "1")
+		must(t, err)
+		if room.Name != "Kitchen" {
+			t.Fatalf("expected room Kitchen, got %q", room.Name)
+		}
+		expectIds(t, room.Sensors, sensorId, "101", "102")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := s.Rooms.RoomById(ctx, "4")
+		expectCode(t, err, goblin.ENOTFOUND)
+	})
+
+	t.Run("create existing", func(t *testing.T) {
+		room := goblin.NewRoom("3", "Loft")
+		must(t, s.Rooms.CreateRoom(ctx, &room))
+		stored, err := s.Rooms.RoomById(ctx, "3")
+		must(t, err)
+		if stored.Name != "Loft" {
+			t.Fatalf("expected room to be renamed to Loft, got %q", stored.Name)
+		}
+	})
+}
+
+func testUpdateRoom(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	room, err := s.Rooms.UpdateRoom(ctx, "1", goblin.RoomUpdate{Name: ptr("Kök")})
+	must(t, err)
+	if room.Id != "1" || room.Name != "Kök" {
+		t.Fatalf("unexpected updated room %+v", room)
+	}
+	expectIds(t, room.Sensors, sensorId, "101", "102")
+
+	stored, err := s.Rooms.RoomById(ctx, "1")
+	must(t, err)
+	if stored.Name != "Kök" {
+		t.Fatalf("expected stored name Kök, got %q", stored.Name)
+	}
+
+	// An empty update changes nothing
+	room, err = s.Rooms.UpdateRoom(ctx, "1", goblin.RoomUpdate{})
+	must(t, err)
+	if room.Name != "Kök" {
+		t.Fatalf("expected name Kök after empty update, got %q", room.Name)
+	}
+
+	_, err = s.Rooms.UpdateRoom(ctx, "4", goblin.RoomUpdate{Name: ptr("Garage")})
+	expectCode(t, err, goblin.ENOTFOUND)
+}
+
+func testDeleteRoom(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	must(t, s.Readings.CreateReading(ctx, &goblin.Reading{SensorId: "101", Capability: "temperature", Time: base, Value: 21}))
+	must(t, s.Rooms.DeleteRoom(ctx, "1"))
+
+	_, err := s.Rooms.RoomById(ctx, "1")
+	expectCode(t, err, goblin.ENOTFOUND)
+
+	// The sensors of the room are kept without a room, with their
+	// readings
+	sensors, err := s.Sensors.FindSensors(ctx, goblin.SensorFilter{RoomId: ptr("")})
+	must(t, err)
+	expectIds(t, sensors, sensorId, "101", "102", "301")
+	readings, err := s.Readings.FindReadings(ctx, goblin.ReadingFilter{SensorId: ptr("101")})
+	must(t, err)
+	if len(readings) != 1 {
+		t.Fatalf("expected the reading of sensor 101 to be kept, got %d readings", len(readings))
+	}
+
+	rooms, err := s.Rooms.FindRooms(ctx, goblin.RoomFilter{})
+	must(t, err)
+	expectIds(t, rooms, roomId, "2", "3")
+
+	expectCode(t, s.Rooms.DeleteRoom(ctx, "1"), goblin.ENOTFOUND)
+}
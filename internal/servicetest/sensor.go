@@ -0,0 +1,239 @@
+package servicetest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+func sensorId(sensor *goblin.Sensor) string {
+	return sensor.Id
+}
+
+func testSensors(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	removedAt := base.Add(90 * time.Minute)
+	must(t, s.Sensors.MarkSensorRemoved(ctx, "201", removedAt))
+
+	for _, tc := range []struct {
+		name     string
+		filter   goblin.SensorFilter
+		expected []string
+	}{
+		{"all", goblin.SensorFilter{}, []string{"101", "102", "201", "301"}},
+		{"id", goblin.SensorFilter{Id: ptr("102")}, []string{"102"}},
+		{"room", goblin.SensorFilter{RoomId: ptr("1")}, []string{"101", "102"}},
+		{"without room", goblin.SensorFilter{RoomId: ptr("")}, []string{"301"}},
+		{"empty room", goblin.SensorFilter{RoomId: ptr("3")}, nil},
+		{"removed", goblin.SensorFilter{Removed: ptr(true)}, []string{"201"}},
+		{"not removed", goblin.SensorFilter{Removed: ptr(false)}, []string{"101", "102", "301"}},
+		{"room and not removed", goblin.SensorFilter{RoomId: ptr("2"), Removed: ptr(false)}, nil},
+		{"order by name", goblin.SensorFilter{OrderBy: "name"}, []string{"301", "201", "102", "101"}},
+		{"order by sensor type descending", goblin.SensorFilter{OrderBy: "-sensor_type"}, []string{"101", "102", "201", "301"}},
+		{"order by id descending", goblin.SensorFilter{OrderBy: "-id"}, []string{"301", "201", "102", "101"}},
+		{"limit", goblin.SensorFilter{Limit: 1}, []string{"101"}},
+		{"limit and offset", goblin.SensorFilter{Offset: 1, Limit: 2}, []string{"102", "201"}},
+		{"offset", goblin.SensorFilter{OrderBy: "-id", Offset: 3}, []string{"101"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sensors, err := s.Sensors.FindSensors(ctx, tc.filter)
+			must(t, err)
+			expectIds(t, sensors, sensorId, tc.expected...)
+		})
+	}
+
+	t.Run("invalid order", func(t *testing.T) {
+		_, err := s.Sensors.FindSensors(ctx, goblin.SensorFilter{OrderBy: "capabilities"})
+		expectCode(t, err, goblin.EINVALID)
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		sensor, err := s.Sensors.SensorById(ctx, "101")
+		must(t, err)
+		if sensor.Name != "Thermometer" || sensor.SensorType != "temperature" || sensor.RoomId != "1" ||
+			!slices.Equal(sensor.Capabilities, []string{"temperature", "humidity"}) || sensor.RemovedAt != nil {
+			t.Fatalf("unexpected sensor %+v", sensor)
+		}
+
+		sensor, err = s.Sensors.SensorById(ctx, "201")
+		must(t, err)
+		if sensor.RemovedAt == nil || !sensor.RemovedAt.Equal(removedAt) {
+			t.Fatalf("expected sensor to be removed at %s, got %v", removedAt, sensor.RemovedAt)
+		}
+	})
+
+	t.Run("create in unknown room", func(t *testing.T) {
+		err := s.Sensors.CreateSensor(ctx, &goblin.Sensor{Id: "401", Name: "Window", SensorType: "notificationContact", RoomId: "9"})
+		expectCode(t, err, goblin.ENOTFOUND)
+		_, err = s.Sensors.SensorById(ctx, "401")
+		expectCode(t, err, goblin.ENOTFOUND)
+	})
+
+	t.Run("create existing", func(t *testing.T) {
+		// Creating a removed sensor restores it
+		must(t, s.Sensors.CreateSensor(ctx, &goblin.Sensor{Id: "201", Name: "Back door", SensorType: "notificationContact", RoomId: "2"}))
+		sensor, err := s.Sensors.SensorById(ctx, "201")
+		must(t, err)
+		if sensor.Name != "Back door" || sensor.RemovedAt != nil {
+			t.Fatalf("expected sensor to be renamed and restored, got %+v", sensor)
+		}
+		if sensor.Capabilities == nil || len(sensor.Capabilities) != 0 {
+			t.Fatalf("expected no capabilities, got %v", sensor.Capabilities)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := s.Sensors.SensorById(ctx, "401")
+		expectCode(t, err, goblin.ENOTFOUND)
+		expectCode(t, s.Sensors.MarkSensorRemoved(ctx, "401", removedAt), goblin.ENOTFOUND)
+	})
+}
+
+func testUpdateSensor(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	sensor, err := s.Sensors.UpdateSensor(ctx, "301", goblin.SensorUpdate{Name: ptr("Doorbell"), RoomId: ptr("3")})
+	must(t, err)
+	if sensor.Id != "301" || sensor.Name != "Doorbell" || sensor.RoomId != "3" || sensor.SensorType != "button" {
+		t.Fatalf("unexpected updated sensor %+v", sensor)
+	}
+	room, err := s.Rooms.RoomById(ctx, "3")
+	must(t, err)
+	expectIds(t, room.Sensors, sensorId, "301")
+
+	// An empty room removes the sensor from its room, and other fields
+	// are kept
+	sensor, err = s.Sensors.UpdateSensor(ctx, "301", goblin.SensorUpdate{RoomId: ptr("")})
+	must(t, err)
+	if sensor.Name != "Doorbell" || sensor.RoomId != "" {
+		t.Fatalf("unexpected updated sensor %+v", sensor)
+	}
+	stored, err := s.Sensors.SensorById(ctx, "301")
+	must(t, err)
+	if stored.Name != "Doorbell" || stored.RoomId != "" {
+		t.Fatalf("unexpected stored sensor %+v", stored)
+	}
+
+	_, err = s.Sensors.UpdateSensor(ctx, "401", goblin.SensorUpdate{Name: ptr("Window")})
+	expectCode(t, err, goblin.ENOTFOUND)
+	_, err = s.Sensors.UpdateSensor(ctx, "301", goblin.SensorUpdate{RoomId: ptr("4")})
+	expectCode(t, err, goblin.ENOTFOUND)
+}
+
+func testSyncSensor(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	// New sensors are inserted
+	sensor := &goblin.Sensor{Id: "401", Name: "Window", SensorType: "notificationContact", RoomId: "2", Capabilities: []string{"notificationContact"}}
+	must(t, s.Sensors.SyncSensor(ctx, sensor))
+	stored, err := s.Sensors.SensorById(ctx, "401")
+	must(t, err)
+	if stored.Name != "Window" || stored.RoomId != "2" {
+		t.Fatalf("unexpected synced sensor %+v", stored)
+	}
+
+	// Sensors that haven't been edited follow the bridge, and removed
+	// sensors are restored
+	must(t, s.Sensors.MarkSensorRemoved(ctx, "102", base))
+	sensor = &goblin.Sensor{Id: "102", Name: "Floor lamp", SensorType: "switchMultilevel", RoomId: "2", Capabilities: []string{"switchLevel"}}
+	must(t, s.Sensors.SyncSensor(ctx, sensor))
+	if sensor.Name != "Floor lamp" || sensor.RoomId != "2" || sensor.SensorType != "switchMultilevel" ||
+		!slices.Equal(sensor.Capabilities, []string{"switchLevel"}) || sensor.RemovedAt != nil {
+		t.Fatalf("unexpected synced sensor %+v", sensor)
+	}
+
+	// Edited names and rooms are kept, while the rest follows the bridge
+	_, err = s.Sensors.UpdateSensor(ctx, "101", goblin.SensorUpdate{Name: ptr("Fridge"), RoomId: ptr("3")})
+	must(t, err)
+	sensor = &goblin.Sensor{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "1", Capabilities: []string{"temperature"}}
+	must(t, s.Sensors.SyncSensor(ctx, sensor))
+	if sensor.Name != "Fridge" || sensor.RoomId != "3" || !slices.Equal(sensor.Capabilities, []string{"temperature"}) {
+		t.Fatalf("expected edits to be kept, got %+v", sensor)
+	}
+	stored, err = s.Sensors.SensorById(ctx, "101")
+	must(t, err)
+	if stored.Name != "Fridge" || stored.RoomId != "3" || !slices.Equal(stored.Capabilities, []string{"temperature"}) {
+		t.Fatalf("expected edits to be kept, got %+v", stored)
+	}
+
+	// Rooms that don't exist are not found, unless the room of the sensor
+	// has been edited and is kept
+	sensor = &goblin.Sensor{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "9", Capabilities: []string{"temperature"}}
+	must(t, s.Sensors.SyncSensor(ctx, sensor))
+	if sensor.RoomId != "3" {
+		t.Fatalf("expected the edited room to be kept, got %+v", sensor)
+	}
+	sensor = &goblin.Sensor{Id: "402", Name: "Window", SensorType: "notificationContact", RoomId: "9"}
+	expectCode(t, s.Sensors.SyncSensor(ctx, sensor), goblin.ENOTFOUND)
+
+	// Only the fields that were edited are kept
+	_, err = s.Sensors.UpdateSensor(ctx, "201", goblin.SensorUpdate{Name: ptr("Front door")})
+	must(t, err)
+	sensor = &goblin.Sensor{Id: "201", Name: "Door", SensorType: "notificationContact", RoomId: "1"}
+	must(t, s.Sensors.SyncSensor(ctx, sensor))
+	if sensor.Name != "Front door" || sensor.RoomId != "1" {
+		t.Fatalf("expected the name to be kept and the room to be synced, got %+v", sensor)
+	}
+
+	// Deleted sensors aren't synced again, until they are created
+	must(t, s.Sensors.DeleteSensor(ctx, "301"))
+	sensor = &goblin.Sensor{Id: "301", Name: "Button", SensorType: "button"}
+	expectCode(t, s.Sensors.SyncSensor(ctx, sensor), goblin.ECONFLICT)
+	_, err = s.Sensors.SensorById(ctx, "301")
+	expectCode(t, err, goblin.ENOTFOUND)
+
+	must(t, s.Sensors.CreateSensor(ctx, sensor))
+	must(t, s.Sensors.SyncSensor(ctx, sensor))
+	_, err = s.Sensors.SensorById(ctx, "301")
+	must(t, err)
+}
+
+func testDeleteSensor(t *testing.T, s Services) {
+	ctx := context.Background()
+	seed(t, s)
+
+	for _, reading := range []*goblin.Reading{
+		{SensorId: "101", Capability: "temperature", Time: base, Value: 21},
+		{SensorId: "101", Capability: "humidity", Time: base, Value: 40},
+		{SensorId: "102", Capability: "power", Time: base, Value: 5},
+	} {
+		must(t, s.Readings.CreateReading(ctx, reading))
+	}
+	for _, event := range []*goblin.Event{
+		{SensorId: "101", Capability: "battery", Value: "low", Time: base},
+		{SensorId: "102", Capability: "switchBinary", Value: "true", Time: base},
+		{Capability: "sun", Value: "set", Time: base},
+	} {
+		must(t, s.Events.CreateEvent(ctx, event))
+	}
+
+	must(t, s.Sensors.DeleteSensor(ctx, "101"))
+
+	_, err := s.Sensors.SensorById(ctx, "101")
+	expectCode(t, err, goblin.ENOTFOUND)
+	room, err := s.Rooms.RoomById(ctx, "1")
+	must(t, err)
+	expectIds(t, room.Sensors, sensorId, "102")
+
+	// The readings and events of the sensor are deleted with it, raw
+	// and aggregated, and those of other sensors are kept
+	readings, err := s.Readings.FindReadings(ctx, goblin.ReadingFilter{})
+	must(t, err)
+	expectIds(t, readings, readingSeries, "102/power")
+	readings, err = s.Readings.FindReadings(ctx, goblin.ReadingFilter{Resolution: ptr(time.Hour)})
+	must(t, err)
+	expectIds(t, readings, readingSeries, "102/power")
+
+	events, err := s.Events.FindEvents(ctx, goblin.EventFilter{})
+	must(t, err)
+	expectIds(t, events, eventCapability, "switchBinary", "sun")
+
+	expectCode(t, s.Sensors.DeleteSensor(ctx, "101"), goblin.ENOTFOUND)
+}
@@ -0,0 +1,112 @@
+// Package servicetest is a conformance suite for implementations of the
+// goblin services. Every implementation runs it from its own tests, so
+// that they agree on filters, ordering, pagination, errors and what is
+// deleted along with what.
+package servicetest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// Services are the services of the implementation under test, sharing the
+// same store.
+type Services struct {
+	Rooms    goblin.RoomService
+	Sensors  goblin.SensorService
+	Readings goblin.ReadingService
+	Events   goblin.EventService
+}
+
+// Run runs the suite. open is called for every test, and must return
+// services with an empty store.
+func Run(t *testing.T, open func(t *testing.T) Services) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, Services)
+	}{
+		{"Rooms", testRooms},
+		{"UpdateRoom", testUpdateRoom},
+		{"DeleteRoom", testDeleteRoom},
+		{"Sensors", testSensors},
+		{"UpdateSensor", testUpdateSensor},
+		{"SyncSensor", testSyncSensor},
+		{"DeleteSensor", testDeleteSensor},
+		{"CreateReadings", testCreateReadings},
+		{"Readings", testReadings},
+		{"AggregatedReadings", testAggregatedReadings},
+		{"StreamReadings", testStreamReadings},
+		{"Events", testEvents},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, open(t))
+		})
+	}
+}
+
+// base is the time of the first reading and event of the fixture.
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// seed stores the rooms and sensors that the tests use:
+//
+//	1 Kitchen  101 Thermometer (temperature, humidity), 102 Lamp
+//	2 Bedroom  201 Door
+//	3 Attic
+//	           301 Button, without a room
+func seed(t *testing.T, s Services) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, room := range []goblin.Room{
+		goblin.NewRoom("1", "Kitchen"),
+		goblin.NewRoom("2", "Bedroom"),
+		goblin.NewRoom("3", "Attic"),
+	} {
+		must(t, s.Rooms.CreateRoom(ctx, &room))
+	}
+
+	for _, sensor := range []*goblin.Sensor{
+		{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "1", Capabilities: []string{"temperature", "humidity"}},
+		{Id: "102", Name: "Lamp", SensorType: "switchBinary", RoomId: "1", Capabilities: []string{"switchBinary", "power"}},
+		{Id: "201", Name: "Door", SensorType: "notificationContact", RoomId: "2", Capabilities: []string{"notificationContact"}},
+		{Id: "301", Name: "Button", SensorType: "button", Capabilities: []string{"button"}},
+	} {
+		must(t, s.Sensors.CreateSensor(ctx, sensor))
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectCode fails the test unless err has code.
+func expectCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if got := goblin.ErrorCode(err); got != code {
+		t.Fatalf("expected error with code %q, got %q: %v", code, got, err)
+	}
+}
+
+// expectIds fails the test unless ids are the expected ids, in order.
+func expectIds[T any](t *testing.T, items []T, id func(T) string, expected ...string) {
+	t.Helper()
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = id(item)
+	}
+	if !slices.Equal(ids, expected) && !(len(ids) == 0 && len(expected) == 0) {
+		t.Fatalf("expected %q, got %q", expected, ids)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,256 @@
+package nexa
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens when a message is published to a
+// subscription whose buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room for the
+	// new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the new message.
+	DropNewest
+	// Block waits until there is room in the buffer, which stalls the
+	// publisher.
+	Block
+)
+
+// DefaultBufferSize is the buffer size of subscriptions that don't set one.
+const DefaultBufferSize = 64
+
+// Bus delivers published messages to any number of subscribers. Each
+// subscriber has its own buffered channel, so a slow subscriber doesn't
+// affect the others unless it uses the Block policy.
+type Bus struct {
+	mutex         sync.RWMutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+type Subscription struct {
+	// Messages matching the subscription. The channel is closed when the
+	// subscription is closed.
+	C <-chan Message
+
+	bus          *Bus
+	messages     chan Message
+	done         chan struct{}
+	closeOnce    sync.Once
+	sendMutex    sync.Mutex
+	overflow     OverflowPolicy
+	nodes        map[string]bool
+	capabilities map[string]bool
+	filter       func(*Message) bool
+	dropped      atomic.Uint64
+}
+
+type subscribeOptions struct {
+	bufferSize   int
+	overflow     OverflowPolicy
+	nodes        []string
+	capabilities []string
+	filter       func(*Message) bool
+}
+
+type SubscribeOption func(*subscribeOptions)
+
+// WithBufferSize sets the number of messages buffered for the subscriber.
+func WithBufferSize(size int) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.bufferSize = size
+	}
+}
+
+// WithOverflowPolicy sets what happens when the buffer is full.
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.overflow = policy
+	}
+}
+
+// WithNodes limits the subscription to messages from the given nodes.
+func WithNodes(ids ...string) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.nodes = append(options.nodes, ids...)
+	}
+}
+
+// WithCapabilities limits the subscription to messages for the given
+// capabilities.
+func WithCapabilities(capabilities ...string) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.capabilities = append(options.capabilities, capabilities...)
+	}
+}
+
+// WithFilter limits the subscription to messages for which filter returns
+// true.
+func WithFilter(filter func(*Message) bool) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.filter = filter
+	}
+}
+
+// Subscribe returns a new subscription to the messages published on the
+// bus. The subscription must be closed when it is no longer used.
+func (b *Bus) Subscribe(opts ...SubscribeOption) *Subscription {
+	options := subscribeOptions{
+		bufferSize: DefaultBufferSize,
+		overflow:   DropOldest,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.bufferSize < 1 {
+		options.bufferSize = 1
+	}
+
+	messages := make(chan Message, options.bufferSize)
+	s := &Subscription{
+		C:        messages,
+		bus:      b,
+		messages: messages,
+		done:     make(chan struct{}),
+		overflow: options.overflow,
+		filter:   options.filter,
+	}
+	if len(options.nodes) > 0 {
+		s.nodes = make(map[string]bool)
+		for _, id := range options.nodes {
+			s.nodes[id] = true
+		}
+	}
+	if len(options.capabilities) > 0 {
+		s.capabilities = make(map[string]bool)
+		for _, capability := range options.capabilities {
+			s.capabilities[capability] = true
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		close(s.done)
+		close(s.messages)
+		return s
+	}
+	b.subscriptions[s] = true
+
+	return s
+}
+
+// Publish sends msg to every matching subscription. It only blocks if a
+// subscription with the Block policy is full, in which case it waits until
+// there is room or ctx is done.
+func (b *Bus) Publish(ctx context.Context, msg Message) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for s := range b.subscriptions {
+		if s.matches(&msg) {
+			s.send(ctx, msg)
+		}
+	}
+}
+
+// Close closes the bus and all of its subscriptions.
+func (b *Bus) Close() {
+	b.mutex.Lock()
+	subscriptions := b.subscriptions
+	b.subscriptions = make(map[*Subscription]bool)
+	b.closed = true
+	b.mutex.Unlock()
+
+	for s := range subscriptions {
+		s.closeOnce.Do(func() {
+			close(s.done)
+			close(s.messages)
+		})
+	}
+}
+
+// Dropped returns the number of messages that were dropped because the
+// subscription buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close removes the subscription from the bus and closes its channel.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		// Release any publisher blocked on this subscription before
+		// waiting for the bus lock.
+		close(s.done)
+
+		s.bus.mutex.Lock()
+		delete(s.bus.subscriptions, s)
+		s.bus.mutex.Unlock()
+
+		close(s.messages)
+	})
+}
+
+func (s *Subscription) matches(msg *Message) bool {
+	if s.nodes != nil && !s.nodes[msg.SourceNode] && !s.nodes[msg.NodeId] {
+		return false
+	}
+	if s.capabilities != nil && !s.capabilities[msg.Capability] {
+		return false
+	}
+	if s.filter != nil && !s.filter(msg) {
+		return false
+	}
+	return true
+}
+
+func (s *Subscription) send(ctx context.Context, msg Message) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.overflow {
+	case Block:
+		select {
+		case s.messages <- msg:
+		case <-s.done:
+		case <-ctx.Done():
+			s.dropped.Add(1)
+		}
+	case DropNewest:
+		select {
+		case s.messages <- msg:
+		default:
+			s.dropped.Add(1)
+		}
+	default:
+		for {
+			select {
+			case s.messages <- msg:
+				return
+			default:
+			}
+			select {
+			case <-s.messages:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package nexa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// EventRecorder stores discrete events, such as door states, button presses
+// and sun transitions, from Nexa messages.
+type EventRecorder struct {
+	EventService goblin.EventService
+
+	sensors *sensorRegistry
+	// Last value of each sensor and capability, which becomes the
+	// previous value of the next event
+	last map[eventKey]string
+}
+
+type eventKey struct {
+	sensorId, capability string
+}
+
+func NewEventRecorder(eventService goblin.EventService, sensorService goblin.SensorService) *EventRecorder {
+	return &EventRecorder{
+		EventService: eventService,
+		sensors:      newSensorRegistry(sensorService),
+		last:         make(map[eventKey]string),
+	}
+}
+
+// IsEvent reports whether msg carries a discrete event that the event
+// recorder stores: node messages that aren't numeric readings, and time
+// messages other than the clock, which ticks every minute.
+func IsEvent(msg *Message) bool {
+	if msg.SystemType == "time" {
+		return msg.Subtype != "" && msg.Subtype != "clock"
+	}
+	return msg.Capability != "" && msg.SourceNode != "" && !IsReading(msg)
+}
+
+// eventValue formats the value of a message as it is stored.
+func eventValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// previous returns the last value of the sensor and capability. Values
+// that haven't been seen since the recorder started are looked up among the
+// stored events.
+func (r *EventRecorder) previous(ctx context.Context, key eventKey) (string, error) {
+	if v, ok := r.last[key]; ok {
+		return v, nil
+	}
+	events, err := r.EventService.FindEvents(ctx, goblin.EventFilter{
+		SensorId:   &key.sensorId,
+		Capability: &key.capability,
+		OrderBy:    "-time",
+		Limit:      1,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(events) == 0 {
+		return "", nil
+	}
+	return events[0].Value, nil
+}
+
+// Record stores the event in msg, creating a sensor for its node if there
+// is none. Messages that do not carry an event, and events of deleted
+// sensors, are ignored.
+func (r *EventRecorder) Record(ctx context.Context, msg *Message) error {
+	if !IsEvent(msg) {
+		return nil
+	}
+	if msg.SystemType != "time" {
+		if ok, err := r.sensors.ensure(ctx, msg); err != nil || !ok {
+			return err
+		}
+	}
+
+	event := &goblin.Event{
+		SensorId:   msg.SourceNode,
+		Capability: msg.Capability,
+		Value:      eventValue(msg.Value),
+		Time:       msg.Time,
+	}
+	if msg.SystemType == "time" {
+		event.SensorId, event.Capability = "", msg.Subtype
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	key := eventKey{event.SensorId, event.Capability}
+	prev, err := r.previous(ctx, key)
+	if err != nil {
+		return err
+	}
+	event.PrevValue = prev
+
+	if err := r.EventService.CreateEvent(ctx, event); err != nil {
+		r.sensors.forget(msg.SourceNode)
+		return err
+	}
+	r.last[key] = event.Value
+	return nil
+}
+
+// Run records every message received on messages until the channel is
+// closed or ctx is done.
+func (r *EventRecorder) Run(ctx context.Context, messages <-chan Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			if err := r.Record(ctx, &msg); err != nil {
+				log.Printf("error recording event %s: %s", msg.String(), err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,478 @@
+// Package fakebridge simulates a Nexa Bridge. It answers discovery
+// requests, serves nodes and rooms behind digest authentication and pushes
+// events over a websocket, which makes it possible to run goblin without
+// the physical bridge.
+package fakebridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maehler/goblin/nexa"
+	"nhooyr.io/websocket"
+)
+
+type Room struct {
+	Id              string `json:"id"`
+	Name            string `json:"name"`
+	TempSensor      string `json:"tempSensor"`
+	BackgroundImage string `json:"backURL"`
+}
+
+// ScriptedEvent is an event that is emitted After a given delay when a
+// script is played.
+type ScriptedEvent struct {
+	After      Duration    `json:"after"`
+	Node       string      `json:"node"`
+	Capability string      `json:"capability"`
+	Value      interface{} `json:"value"`
+}
+
+// Duration is a time.Duration that is read from and written to JSON as a
+// string such as "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Fixtures are the rooms and nodes served by the bridge, and an optional
+// script of events.
+type Fixtures struct {
+	Rooms  []Room           `json:"rooms"`
+	Nodes  []*nexa.NexaNode `json:"nodes"`
+	Events []ScriptedEvent  `json:"events"`
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(path string) (*Fixtures, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixtures := &Fixtures{}
+	if err := json.Unmarshal(b, fixtures); err != nil {
+		return nil, fmt.Errorf("parse fixtures %s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// DefaultFixtures returns a small home with a few rooms and a node for
+// each capability that goblin knows how to display.
+func DefaultFixtures() *Fixtures {
+	now := time.Now()
+	event := func(name string, value interface{}) *nexa.NexaEvent {
+		return &nexa.NexaEvent{Name: name, Value: value, Time: now}
+	}
+	return &Fixtures{
+		Rooms: []Room{
+			{Id: "1", Name: "Living room", TempSensor: "101"},
+			{Id: "2", Name: "Hallway"},
+			{Id: "3", Name: "Bedroom", TempSensor: "301"},
+		},
+		Nodes: []*nexa.NexaNode{
+			{
+				Id:           "101",
+				Name:         "Living room thermometer",
+				RoomId:       "1",
+				Capabilities: []string{"temperature", "humidity"},
+				LastEvents: map[string]*nexa.NexaEvent{
+					"temperature": event("temperature", 21.5),
+					"humidity":    event("humidity", 40.0),
+				},
+			},
+			{
+				Id:           "102",
+				Name:         "Floor lamp",
+				RoomId:       "1",
+				Capabilities: []string{"switchBinary", "switchLevel"},
+				LastEvents: map[string]*nexa.NexaEvent{
+					"switchBinary": event("switchBinary", false),
+					"switchLevel":  event("switchLevel", 0.5),
+				},
+			},
+			{
+				Id:           "201",
+				Name:         "Front door",
+				RoomId:       "2",
+				Capabilities: []string{"notificationContact"},
+				LastEvents: map[string]*nexa.NexaEvent{
+					"notificationContact": event("notificationContact", false),
+				},
+			},
+			{
+				Id:           "202",
+				Name:         "Doorbell",
+				RoomId:       "2",
+				Capabilities: []string{"notificationPushButton"},
+				LastEvents: map[string]*nexa.NexaEvent{
+					"notificationPushButton": event("notificationPushButton", false),
+				},
+			},
+			{
+				Id:           "301",
+				Name:         "Bedroom thermometer",
+				RoomId:       "3",
+				Capabilities: []string{"temperature", "humidity"},
+				LastEvents: map[string]*nexa.NexaEvent{
+					"temperature": event("temperature", 19.0),
+					"humidity":    event("humidity", 45.0),
+				},
+			},
+			{
+				Id:           "302",
+				Name:         "Bedside lamp",
+				RoomId:       "3",
+				Capabilities: []string{"switchBinary"},
+				LastEvents: map[string]*nexa.NexaEvent{
+					"switchBinary": event("switchBinary", true),
+				},
+			},
+		},
+	}
+}
+
+// Bridge is a simulated Nexa Bridge.
+type Bridge struct {
+	mutex   sync.Mutex
+	rooms   []Room
+	nodes   map[string]*nexa.NexaNode
+	clients map[chan nexa.Message]bool
+	digest  *digestServer
+}
+
+func New(fixtures *Fixtures, username, password string) *Bridge {
+	b := &Bridge{
+		rooms:   append([]Room{}, fixtures.Rooms...),
+		nodes:   make(map[string]*nexa.NexaNode),
+		clients: make(map[chan nexa.Message]bool),
+		digest:  newDigestServer("nexa", username, password),
+	}
+	for _, node := range fixtures.Nodes {
+		n := *node
+		n.LastEvents = make(map[string]*nexa.NexaEvent)
+		for name, event := range node.LastEvents {
+			e := *event
+			e.NodeId = n.Id
+			n.LastEvents[name] = &e
+		}
+		b.nodes[n.Id] = &n
+	}
+	return b
+}
+
+// Handler returns the REST API of the bridge. All endpoints require digest
+// authentication.
+func (b *Bridge) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/nodes", b.nodesHandler)
+	mux.HandleFunc("GET /v1/nodes/{id}", b.nodeHandler)
+	mux.HandleFunc("POST /v1/nodes/{id}/call", b.callHandler)
+	mux.HandleFunc("GET /v1/rooms", b.roomsHandler)
+	return b.digest.middleware(mux)
+}
+
+// SocketHandler returns the websocket endpoint that events are pushed to.
+func (b *Bridge) SocketHandler() http.Handler {
+	return http.HandlerFunc(b.socketHandler)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("error encoding response:", err.Error())
+	}
+}
+
+func (b *Bridge) sortedNodes() []*nexa.NexaNode {
+	nodes := make([]*nexa.NexaNode, 0, len(b.nodes))
+	for _, node := range b.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Id < nodes[j].Id })
+	return nodes
+}
+
+func (b *Bridge) nodesHandler(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	writeJSON(w, b.sortedNodes())
+}
+
+func (b *Bridge) nodeHandler(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	node, ok := b.nodes[r.PathValue("id")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, node)
+}
+
+func (b *Bridge) roomsHandler(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	writeJSON(w, b.rooms)
+}
+
+func (b *Bridge) callHandler(w http.ResponseWriter, r *http.Request) {
+	call := struct {
+		Capability string      `json:"capability"`
+		Value      interface{} `json:"value"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.mutex.Lock()
+	_, ok := b.nodes[r.PathValue("id")]
+	b.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := b.Emit(r.PathValue("id"), call.Capability, call.Value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Emit sets the value of a node capability and pushes the event to all
+// connected websocket clients.
+func (b *Bridge) Emit(nodeId string, capability string, value interface{}) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	node, ok := b.nodes[nodeId]
+	if !ok {
+		return fmt.Errorf("node %s not found", nodeId)
+	}
+	hasCapability := false
+	for _, c := range node.Capabilities {
+		hasCapability = hasCapability || c == capability
+	}
+	if !hasCapability {
+		return fmt.Errorf("node %s has no capability %q", nodeId, capability)
+	}
+
+	now := time.Now()
+	event := &nexa.NexaEvent{NodeId: nodeId, Name: capability, Value: value, Time: now}
+	if prev, ok := node.LastEvents[capability]; ok {
+		event.PrevValue = prev.Value
+	}
+	node.LastEvents[capability] = event
+
+	b.broadcast(nexa.Message{
+		SystemType: "node",
+		SourceNode: nodeId,
+		Capability: capability,
+		Name:       node.Name,
+		Value:      value,
+		Time:       now,
+	})
+	return nil
+}
+
+// EmitSystem pushes a message that isn't tied to a node, such as time or
+// sun events.
+func (b *Bridge) EmitSystem(msg nexa.Message) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.broadcast(msg)
+}
+
+func (b *Bridge) broadcast(msg nexa.Message) {
+	for client := range b.clients {
+		select {
+		case client <- msg:
+		default:
+			log.Printf("fakebridge: dropping message for slow client")
+		}
+	}
+}
+
+func (b *Bridge) socketHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Println("fakebridge: accept websocket:", err.Error())
+		return
+	}
+	defer c.CloseNow()
+
+	messages := make(chan nexa.Message, 64)
+	b.mutex.Lock()
+	b.clients[messages] = true
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		delete(b.clients, messages)
+		b.mutex.Unlock()
+	}()
+
+	ctx := c.CloseRead(r.Context())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			payload, err := json.Marshal(msg)
+			if err != nil {
+				log.Println("fakebridge: encode message:", err.Error())
+				continue
+			}
+			writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err = c.Write(writeCtx, websocket.MessageText, payload)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Play emits the scripted events in order, waiting for each event's delay
+// before emitting it.
+func (b *Bridge) Play(ctx context.Context, events []ScriptedEvent) error {
+	for _, event := range events {
+		timer := time.NewTimer(time.Duration(event.After))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if err := b.Emit(event.Node, event.Capability, event.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunRandom emits a random but plausible event every interval until ctx is
+// done.
+func (b *Bridge) RunRandom(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			nodeId, capability, value, ok := b.randomEvent()
+			if !ok {
+				continue
+			}
+			if err := b.Emit(nodeId, capability, value); err != nil {
+				log.Println("fakebridge:", err.Error())
+			}
+		}
+	}
+}
+
+func (b *Bridge) randomEvent() (string, string, interface{}, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	nodes := b.sortedNodes()
+	if len(nodes) == 0 {
+		return "", "", nil, false
+	}
+	node := nodes[rand.Intn(len(nodes))]
+	if len(node.Capabilities) == 0 {
+		return "", "", nil, false
+	}
+	capability := node.Capabilities[rand.Intn(len(node.Capabilities))]
+
+	var prev interface{}
+	if event, ok := node.LastEvents[capability]; ok {
+		prev = event.Value
+	}
+	prevFloat, _ := prev.(float64)
+	prevBool, _ := prev.(bool)
+
+	switch capability {
+	case "temperature":
+		if prev == nil {
+			prevFloat = 20
+		}
+		return node.Id, capability, round(prevFloat+rand.Float64()-0.5, 1), true
+	case "humidity":
+		if prev == nil {
+			prevFloat = 40
+		}
+		return node.Id, capability, round(min(max(prevFloat+rand.Float64()*4-2, 0), 100), 0), true
+	case "switchLevel":
+		return node.Id, capability, round(rand.Float64(), 2), true
+	case "notificationPushButton":
+		return node.Id, capability, true, true
+	default:
+		return node.Id, capability, !prevBool, true
+	}
+}
+
+func round(v float64, decimals int) float64 {
+	p := 1.0
+	for i := 0; i < decimals; i++ {
+		p *= 10
+	}
+	if v < 0 {
+		return float64(int(v*p-0.5)) / p
+	}
+	return float64(int(v*p+0.5)) / p
+}
+
+// ServeDiscovery answers Nexa discovery broadcasts on addr until ctx is
+// done. The bridge is identified by the source address of the reply.
+func ServeDiscovery(ctx context.Context, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		log.Printf("fakebridge: discovery request %q from %s", buf[:n], from)
+		if _, err := pc.WriteToUDP([]byte("nexa bridge"), from); err != nil {
+			log.Println("fakebridge: discovery reply:", err.Error())
+		}
+	}
+}
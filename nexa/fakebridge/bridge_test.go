@@ -0,0 +1,142 @@
+package fakebridge_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/nexa"
+	"github.com/maehler/goblin/nexa/fakebridge"
+)
+
+// newBridge starts a bridge with the default fixtures and returns the
+// configuration of a Nexa that connects to it with password.
+func newBridge(t *testing.T, password string) *nexa.NexaConfig {
+	t.Helper()
+	bridge := fakebridge.New(fakebridge.DefaultFixtures(), "nexa", "nexa")
+	api := httptest.NewServer(bridge.Handler())
+	t.Cleanup(api.Close)
+	socket := httptest.NewServer(bridge.SocketHandler())
+	t.Cleanup(socket.Close)
+
+	u, err := url.Parse(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(socket.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := nexa.NewNexaConfig()
+	config.URL = *u
+	config.Username = "nexa"
+	config.Password = password
+	config.WebsocketHost = host
+	if config.WebsocketPort, err = strconv.Atoi(port); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestNexaService(t *testing.T) {
+	service := nexa.NewNexaService(nexa.NewNexa(newBridge(t, "nexa")))
+
+	nodes, err := service.Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 6 {
+		t.Errorf("expected 6 nodes, got %d", len(nodes))
+	}
+
+	rooms, err := service.Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 3 || len(rooms[0].Nodes) != 2 || len(rooms[1].Nodes) != 2 || len(rooms[2].Nodes) != 2 {
+		t.Errorf("expected 3 rooms with 2 nodes each, got %+v", rooms)
+	}
+
+	// Calls are authenticated with the challenge of the previous requests,
+	// and POST bodies are sent again when they are challenged
+	if err := service.SetSwitch("102", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SetLevel("102", 0.25); err != nil {
+		t.Fatal(err)
+	}
+	node, err := service.Node("102")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if on := node.LastEvents["switchBinary"].Value; on != true {
+		t.Errorf("expected the lamp to be on, got %v", on)
+	}
+	if level := node.LastEvents["switchLevel"].Value; level != 0.25 {
+		t.Errorf("expected level 0.25, got %v", level)
+	}
+
+	if _, err := service.Node("999"); goblin.ErrorCode(err) != goblin.ENOTFOUND {
+		t.Errorf("expected an unknown node to be not found, got %v", err)
+	}
+	if err := service.SetSwitch("999", true); goblin.ErrorCode(err) != goblin.ENOTFOUND {
+		t.Errorf("expected switching an unknown node to be not found, got %v", err)
+	}
+}
+
+func TestNexaServiceWrongPassword(t *testing.T) {
+	service := nexa.NewNexaService(nexa.NewNexa(newBridge(t, "wrong")))
+
+	var statusErr *nexa.StatusError
+	if _, err := service.Nodes(); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %v", http.StatusUnauthorized, err)
+	}
+}
+
+func TestNexaWebsocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := nexa.NewNexa(newBridge(t, "nexa"))
+	service := nexa.NewNexaService(n)
+	messages := n.Bus.Subscribe(nexa.WithFilter(func(msg *nexa.Message) bool { return msg.SourceNode == "302" }))
+	defer messages.Close()
+	states, stopStates := n.WatchState()
+	defer stopStates()
+	go n.Run(ctx)
+
+	timeout := time.After(5 * time.Second)
+	for connected := false; !connected; {
+		select {
+		case state := <-states:
+			connected = state.State == nexa.StateConnected
+		case <-timeout:
+			t.Fatal("timed out waiting for the websocket to connect")
+		}
+	}
+
+	// The bridge may not have registered the connection yet, so the
+	// call is repeated until its event arrives
+	for {
+		if err := service.SetSwitch("302", false); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-messages.C:
+			if msg.Capability != "switchBinary" || msg.Value != false {
+				t.Fatalf("expected the bedside lamp to be switched off, got %s", msg)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for the event of the call")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package fakebridge
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/maehler/goblin/auth"
+)
+
+// digestServer verifies digest authentication the way the Nexa Bridge
+// does: MD5 with qop=auth and a nonce that is reused for every request.
+type digestServer struct {
+	realm    string
+	username string
+	password string
+	nonce    string
+	opaque   string
+}
+
+func newDigestServer(realm, username, password string) *digestServer {
+	return &digestServer{
+		realm:    realm,
+		username: username,
+		password: password,
+		nonce:    randomHex(16),
+		opaque:   randomHex(16),
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *digestServer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.authorized(r) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Digest realm="%s", qop="auth", nonce="%s", opaque="%s", algorithm=MD5`,
+				d.realm,
+				d.nonce,
+				d.opaque,
+			))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *digestServer) authorized(r *http.Request) bool {
+	credentials, err := auth.ParseCredentials(r.Header.Get("Authorization"))
+	if err != nil || !strings.EqualFold(credentials.Scheme, "Digest") {
+		return false
+	}
+	params := credentials.Params
+	if params["username"] != d.username || params["nonce"] != d.nonce {
+		return false
+	}
+	// The response covers the uri parameter, which must be the uri that
+	// was requested for the response to prove anything about the request
+	if params["uri"] != r.URL.RequestURI() {
+		return false
+	}
+
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", d.username, d.realm, d.password))
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", r.Method, params["uri"]))
+	var expected string
+	if params["qop"] == "" {
+		expected = md5Hex(fmt.Sprintf("%s:%s:%s", ha1, d.nonce, ha2))
+	} else {
+		expected = md5Hex(fmt.Sprintf(
+			"%s:%s:%s:%s:%s:%s",
+			ha1,
+			d.nonce,
+			params["nc"],
+			params["cnonce"],
+			params["qop"],
+			ha2,
+		))
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) == 1
+}
@@ -0,0 +1,178 @@
+package nexa
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// Inventory keeps the rooms and sensors in the database in sync with the
+// rooms and nodes of the Nexa Bridge.
+type Inventory struct {
+	NexaService   *NexaService
+	RoomService   goblin.RoomService
+	SensorService goblin.SensorService
+}
+
+func NewInventory(nexaService *NexaService, roomService goblin.RoomService, sensorService goblin.SensorService) *Inventory {
+	return &Inventory{
+		NexaService:   nexaService,
+		RoomService:   roomService,
+		SensorService: sensorService,
+	}
+}
+
+// sensorType describes a node by its first capability, since the bridge
+// doesn't report a type for its nodes.
+func sensorType(node *NexaNode) string {
+	if len(node.Capabilities) == 0 {
+		return "unknown"
+	}
+	return node.Capabilities[0]
+}
+
+// Sync inserts and updates rooms and sensors for all rooms and nodes on
+// the bridge, and marks sensors whose nodes are no longer present as
+// removed. Changes that users have made to sensors are kept.
+func (i *Inventory) Sync(ctx context.Context) error {
+	rooms, err := i.NexaService.rooms()
+	if err != nil {
+		return err
+	}
+	nodes, err := i.NexaService.Nodes()
+	if err != nil {
+		return err
+	}
+
+	roomIds := make(map[string]bool)
+	for _, room := range rooms {
+		r := goblin.NewRoom(room.Id, room.Name)
+		if err := i.RoomService.CreateRoom(ctx, &r); err != nil {
+			return err
+		}
+		roomIds[room.Id] = true
+	}
+
+	existing, err := i.SensorService.FindSensors(ctx, goblin.SensorFilter{})
+	if err != nil {
+		return err
+	}
+	sensors := make(map[string]*goblin.Sensor)
+	for _, sensor := range existing {
+		sensors[sensor.Id] = sensor
+	}
+
+	present := make(map[string]bool)
+	for _, node := range nodes {
+		present[node.Id] = true
+
+		roomId := node.RoomId
+		if !roomIds[roomId] {
+			roomId = ""
+		}
+		sensor := &goblin.Sensor{
+			Id:           node.Id,
+			Name:         node.Name,
+			SensorType:   sensorType(node),
+			RoomId:       roomId,
+			Capabilities: node.Capabilities,
+		}
+
+		prev, ok := sensors[node.Id]
+		if ok && prev.RemovedAt == nil &&
+			prev.Name == sensor.Name &&
+			prev.SensorType == sensor.SensorType &&
+			prev.RoomId == sensor.RoomId &&
+			slices.Equal(prev.Capabilities, sensor.Capabilities) {
+			continue
+		}
+
+		// Names and rooms that have been edited are kept, and sensors
+		// that have been deleted stay deleted
+		err := i.SensorService.SyncSensor(ctx, sensor)
+		if goblin.ErrorCode(err) == goblin.ECONFLICT {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if ok && prev.RoomId != sensor.RoomId {
+			log.Printf("sensor %s moved from room %q to %q", node.Id, prev.RoomId, sensor.RoomId)
+		}
+	}
+
+	now := time.Now()
+	for _, sensor := range existing {
+		if present[sensor.Id] || sensor.RemovedAt != nil {
+			continue
+		}
+		log.Printf("sensor %s (%s) is no longer on the bridge", sensor.Id, sensor.Name)
+		if err := i.SensorService.MarkSensorRemoved(ctx, sensor.Id, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run syncs the inventory every interval, whenever the websocket
+// reconnects and whenever a message arrives from a node that hasn't been
+// seen before, until ctx is done.
+func (i *Inventory) Run(ctx context.Context, n *Nexa, interval time.Duration) {
+	states, stopStates := n.WatchState()
+	defer stopStates()
+
+	messages := n.Bus.Subscribe(WithOverflowPolicy(DropNewest))
+	defer messages.Close()
+
+	known := make(map[string]bool)
+	sync := func() {
+		if err := i.Sync(ctx); err != nil {
+			log.Println("error syncing inventory:", err.Error())
+			return
+		}
+		sensors, err := i.SensorService.FindSensors(ctx, goblin.SensorFilter{})
+		if err != nil {
+			log.Println("error listing sensors:", err.Error())
+			return
+		}
+		clear(known)
+		for _, sensor := range sensors {
+			known[sensor.Id] = true
+		}
+	}
+	sync()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Unknown nodes usually arrive in bursts when they are paired, so
+	// wait a bit before syncing.
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sync()
+		case state := <-states:
+			if state.State == StateConnected {
+				sync()
+			}
+		case msg, ok := <-messages.C:
+			if !ok {
+				return
+			}
+			if msg.SourceNode != "" && !known[msg.SourceNode] {
+				known[msg.SourceNode] = true
+				debounce.Reset(5 * time.Second)
+			}
+		case <-debounce.C:
+			sync()
+		}
+	}
+}
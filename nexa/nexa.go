@@ -1,8 +1,9 @@
 package nexa
 
 import (
-	"context"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,11 +11,12 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"sync"
 	"time"
 
+	"github.com/maehler/goblin"
 	"github.com/maehler/goblin/auth"
 	"github.com/spf13/viper"
-	"nhooyr.io/websocket"
 )
 
 type subscriber struct {
@@ -24,11 +26,17 @@ type subscriber struct {
 
 type NexaService struct {
 	Nexa *Nexa
+
+	client *http.Client
 }
 
 func NewNexaService(nexa *Nexa) NexaService {
 	return NexaService{
 		Nexa: nexa,
+		client: &http.Client{
+			Transport: auth.NewTransport(nexa.Config.Username, nexa.Config.Password),
+			Timeout:   10 * time.Second,
+		},
 	}
 }
 
@@ -44,14 +52,19 @@ type Nexa struct {
 	// Nexa config
 	Config *NexaConfig
 
-	// Parsed output messages
-	Messages chan Message
+	// Bus that parsed messages are published on
+	Bus *Bus
+
+	stateMutex    sync.Mutex
+	state         ConnectionState
+	stateWatchers map[chan StateEvent]bool
 }
 
 func NewNexa(config *NexaConfig) *Nexa {
 	return &Nexa{
-		Config:   config,
-		Messages: make(chan Message, 0),
+		Config:        config,
+		Bus:           NewBus(),
+		stateWatchers: make(map[chan StateEvent]bool),
 	}
 }
 
@@ -61,6 +74,17 @@ type NexaConfig struct {
 	Password      string
 	WebsocketHost string
 	WebsocketPort int
+
+	// Timeout for establishing the websocket connection
+	DialTimeout time.Duration
+	// Delays between reconnection attempts. The delay doubles for every
+	// failed attempt, starting at MinBackoff and capped at MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// How often the websocket is pinged, and how long to wait for a pong
+	// before the connection is considered dead
+	PingInterval time.Duration
+	PingTimeout  time.Duration
 }
 
 type NexaNodes = []*NexaNode
@@ -74,7 +98,7 @@ type NexaNode struct {
 }
 
 type NexaEvent struct {
-	NodeId    string
+	NodeId    string      `json:"-"`
 	Name      string      `json:"name"`
 	Value     interface{} `json:"value"`
 	PrevValue interface{} `json:"prevValue"`
@@ -129,41 +153,80 @@ func NewNexaConfig() *NexaConfig {
 			Scheme: "http",
 			Host:   viper.GetString("nexa.address"),
 		},
-		Username: viper.GetString("nexa.username"),
-		Password: viper.GetString("nexa.password"),
+		Username:     viper.GetString("nexa.username"),
+		Password:     viper.GetString("nexa.password"),
+		DialTimeout:  10 * time.Second,
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+		PingInterval: 30 * time.Second,
+		PingTimeout:  10 * time.Second,
 	}
 }
 
-func (s *NexaService) Nodes() (NexaNodes, error) {
-	client := &http.Client{}
-	nodesURL := s.Nexa.Config.URL
-	nodesURL.Path = "v1/nodes"
+// StatusError is an error response from the REST API of the Nexa Bridge.
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+}
 
-	da := auth.NewDigestAuth(s.Nexa.Config.Username, s.Nexa.Config.Password)
-	req, err := da.Request("GET", nodesURL.String())
+// nodeError returns ENOTFOUND if err is the bridge responding that it has
+// no node with the id, and err otherwise.
+func nodeError(nodeId string, err error) error {
+	var e *StatusError
+	if errors.As(err, &e) && e.StatusCode == http.StatusNotFound {
+		return goblin.Errorf(goblin.ENOTFOUND, "node with id %s not found", nodeId)
+	}
+	return err
+}
+
+// do sends a request to the API of the Nexa Bridge and decodes the JSON
+// response into v, unless v is nil.
+func (s *NexaService) do(method string, path string, body interface{}, v interface{}) error {
+	u := s.Nexa.Config.URL
+	u.Path = path
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u.String(), reqBody)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	log.Println(resp.Status)
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if v == nil {
+		return nil
 	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
 
+func (s *NexaService) Nodes() (NexaNodes, error) {
 	nodes := NexaNodes{}
-	if err := json.Unmarshal(body, &nodes); err != nil {
+	if err := s.do("GET", "v1/nodes", nil, &nodes); err != nil {
 		return nil, err
 	}
 	for _, node := range nodes {
@@ -175,36 +238,9 @@ func (s *NexaService) Nodes() (NexaNodes, error) {
 }
 
 func (s *NexaService) Node(nodeId string) (*NexaNode, error) {
-	client := &http.Client{}
-	nodesURL := s.Nexa.Config.URL
-	nodesURL.Path = fmt.Sprintf("v1/nodes/%s", nodeId)
-
-	da := auth.NewDigestAuth(s.Nexa.Config.Username, s.Nexa.Config.Password)
-	req, err := da.Request("GET", nodesURL.String())
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	log.Println(resp.Status)
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(resp.Status)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	node := &NexaNode{}
-	if err := json.Unmarshal(body, node); err != nil {
-		return nil, err
+	if err := s.do("GET", fmt.Sprintf("v1/nodes/%s", nodeId), nil, node); err != nil {
+		return nil, nodeError(nodeId, err)
 	}
 	for _, event := range node.LastEvents {
 		event.NodeId = node.Id
@@ -212,39 +248,21 @@ func (s *NexaService) Node(nodeId string) (*NexaNode, error) {
 	return node, nil
 }
 
-func (s *NexaService) Rooms() (NexaRooms, error) {
-	client := &http.Client{}
-	roomsURL := s.Nexa.Config.URL
-	roomsURL.Path = "v1/rooms"
-
-	da := auth.NewDigestAuth(s.Nexa.Config.Username, s.Nexa.Config.Password)
-	req, err := da.Request("GET", roomsURL.String())
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
+// rooms returns the rooms without their nodes.
+func (s *NexaService) rooms() (NexaRooms, error) {
+	rooms := NexaRooms{}
+	if err := s.do("GET", "v1/rooms", nil, &rooms); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	log.Println(resp.Status)
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(resp.Status)
-	}
+	return rooms, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (s *NexaService) Rooms() (NexaRooms, error) {
+	rooms, err := s.rooms()
 	if err != nil {
 		return nil, err
 	}
 
-	rooms := NexaRooms{}
-	if err := json.Unmarshal(body, &rooms); err != nil {
-		return nil, err
-	}
-
 	roomIds := make(map[string]int)
 	for i, room := range rooms {
 		roomIds[room.Id] = i
@@ -266,35 +284,29 @@ func (s *NexaService) Rooms() (NexaRooms, error) {
 	return rooms, nil
 }
 
-func (n *Nexa) InitSockets() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
+type nodeCall struct {
+	Capability string      `json:"capability"`
+	Value      interface{} `json:"value"`
+}
 
-	c, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://%s:%d", n.Config.WebsocketHost, n.Config.WebsocketPort), nil)
-	if err != nil {
-		panic(err)
-	}
-	defer c.CloseNow()
+// SetNodeValue sets the value of a capability on a node.
+func (s *NexaService) SetNodeValue(nodeId string, capability string, value interface{}) error {
+	path := fmt.Sprintf("v1/nodes/%s/call", nodeId)
+	return nodeError(nodeId, s.do("POST", path, nodeCall{Capability: capability, Value: value}, nil))
+}
 
-	for {
-		_, r, err := c.Reader(context.TODO())
-		if err != nil {
-			log.Println("error reading from socket:", err.Error())
-			return
-		}
-		b, err := io.ReadAll(r)
-		if err != nil {
-			log.Println("error reading message:", err.Error())
-			return
-		}
-		msg, err := ParseMessage(string(b))
-		if err != nil {
-			log.Println("error parsing message:", err.Error())
-			return
-		}
+// SetSwitch turns a node with the switchBinary capability on or off.
+func (s *NexaService) SetSwitch(nodeId string, on bool) error {
+	return s.SetNodeValue(nodeId, "switchBinary", on)
+}
 
-		n.Messages <- *msg
+// SetLevel sets the level of a node with the switchLevel capability. The
+// level must be between 0 and 1.
+func (s *NexaService) SetLevel(nodeId string, level float64) error {
+	if level < 0 || level > 1 {
+		return goblin.Errorf(goblin.EINVALID, "level must be between 0 and 1")
 	}
+	return s.SetNodeValue(nodeId, "switchLevel", level)
 }
 
 // Get preferred outbound ip of this machine
@@ -0,0 +1,133 @@
+package nexa
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// Recorder stores numeric readings, such as temperature, humidity and
+// power, from Nexa messages.
+type Recorder struct {
+	ReadingService goblin.ReadingService
+
+	sensors *sensorRegistry
+}
+
+func NewRecorder(readingService goblin.ReadingService, sensorService goblin.SensorService) *Recorder {
+	return &Recorder{
+		ReadingService: readingService,
+		sensors:        newSensorRegistry(sensorService),
+	}
+}
+
+// sensorRegistry makes sure that there is a sensor for every node that
+// readings and events are recorded from, since they reference it. Nodes
+// that are paired with the bridge send messages before the inventory has
+// synced them, and get a sensor that the next sync fills in.
+type sensorRegistry struct {
+	service goblin.SensorService
+	// Whether there is a sensor for each node that has been seen. Nodes
+	// whose sensors have been deleted have none.
+	known map[string]bool
+}
+
+func newSensorRegistry(service goblin.SensorService) *sensorRegistry {
+	return &sensorRegistry{
+		service: service,
+		known:   make(map[string]bool),
+	}
+}
+
+// ensure creates a sensor for the source node of msg unless there already
+// is one. It reports false if the sensor has been deleted, in which case
+// nothing should be recorded for it.
+func (r *sensorRegistry) ensure(ctx context.Context, msg *Message) (bool, error) {
+	if ok, seen := r.known[msg.SourceNode]; seen {
+		return ok, nil
+	}
+
+	_, err := r.service.SensorById(ctx, msg.SourceNode)
+	if goblin.ErrorCode(err) == goblin.ENOTFOUND {
+		log.Printf("creating sensor for unknown node %s", msg.SourceNode)
+		err = r.service.SyncSensor(ctx, &goblin.Sensor{
+			Id:           msg.SourceNode,
+			SensorType:   msg.Capability,
+			Capabilities: []string{msg.Capability},
+		})
+		if goblin.ErrorCode(err) == goblin.ECONFLICT {
+			r.known[msg.SourceNode] = false
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	r.known[msg.SourceNode] = true
+	return true, nil
+}
+
+// forget makes the registry look up the sensor of a node again, such as
+// after recording for it failed.
+func (r *sensorRegistry) forget(id string) {
+	delete(r.known, id)
+}
+
+// IsReading reports whether msg carries a reading that the recorder stores.
+func IsReading(msg *Message) bool {
+	if msg.Capability == "" || msg.SourceNode == "" {
+		return false
+	}
+	_, err := msg.FloatValue()
+	return err == nil
+}
+
+// Record stores the reading in msg, creating a sensor for its node if
+// there is none. Messages that do not carry a reading, and readings of
+// deleted sensors, are ignored.
+func (r *Recorder) Record(ctx context.Context, msg *Message) error {
+	if !IsReading(msg) {
+		return nil
+	}
+
+	if ok, err := r.sensors.ensure(ctx, msg); err != nil || !ok {
+		return err
+	}
+
+	value, _ := msg.FloatValue()
+	t := msg.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	err := r.ReadingService.CreateReading(ctx, &goblin.Reading{
+		SensorId:   msg.SourceNode,
+		Capability: msg.Capability,
+		Time:       t,
+		Value:      value,
+	})
+	if err != nil {
+		r.sensors.forget(msg.SourceNode)
+	}
+	return err
+}
+
+// Run records every message received on messages until the channel is
+// closed or ctx is done.
+func (r *Recorder) Run(ctx context.Context, messages <-chan Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			if err := r.Record(ctx, &msg); err != nil {
+				log.Printf("error recording %s: %s", msg.String(), err.Error())
+			}
+		}
+	}
+}
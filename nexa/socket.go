@@ -0,0 +1,213 @@
+package nexa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnected
+	StateReconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// StateEvent describes a change in the state of the websocket connection
+// to the Nexa Bridge.
+type StateEvent struct {
+	State ConnectionState
+	Time  time.Time
+	// The error that caused the connection to be lost, if any
+	Err error
+	// Number of failed connection attempts since the last successful
+	// connection
+	Attempt int
+	// Delay before the next connection attempt when reconnecting
+	Delay time.Duration
+}
+
+// State returns the current state of the websocket connection.
+func (n *Nexa) State() ConnectionState {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+	return n.state
+}
+
+// WatchState returns a channel that receives an event every time the state
+// of the websocket connection changes, and a function that stops the
+// watch. Events are dropped if the channel is not read fast enough.
+func (n *Nexa) WatchState() (<-chan StateEvent, func()) {
+	events := make(chan StateEvent, 8)
+	n.stateMutex.Lock()
+	n.stateWatchers[events] = true
+	n.stateMutex.Unlock()
+
+	return events, func() {
+		n.stateMutex.Lock()
+		defer n.stateMutex.Unlock()
+		if n.stateWatchers[events] {
+			delete(n.stateWatchers, events)
+			close(events)
+		}
+	}
+}
+
+func (n *Nexa) setState(event StateEvent) {
+	event.Time = time.Now()
+
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+	n.state = event.State
+	for events := range n.stateWatchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+
+	switch {
+	case event.State == StateReconnecting:
+		log.Printf("nexa websocket %s in %s (attempt %d): %v", event.State, event.Delay, event.Attempt, event.Err)
+	case event.Err != nil:
+		log.Printf("nexa websocket %s: %s", event.State, event.Err.Error())
+	default:
+		log.Printf("nexa websocket %s", event.State)
+	}
+}
+
+// Run connects to the websocket of the Nexa Bridge and publishes the parsed
+// messages on n.Bus. Lost connections are re-established with
+// exponential backoff until ctx is done.
+func (n *Nexa) Run(ctx context.Context) error {
+	attempt := 0
+	for {
+		err := n.connect(ctx, func() { attempt = 0 })
+		if ctx.Err() != nil {
+			n.setState(StateEvent{State: StateDisconnected})
+			return ctx.Err()
+		}
+		n.setState(StateEvent{State: StateDisconnected, Err: err})
+
+		attempt++
+		delay := n.backoff(attempt)
+		n.setState(StateEvent{
+			State:   StateReconnecting,
+			Err:     err,
+			Attempt: attempt,
+			Delay:   delay,
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			n.setState(StateEvent{State: StateDisconnected})
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the given reconnection attempt, with up
+// to 20% jitter so that several clients don't reconnect in lockstep.
+func (n *Nexa) backoff(attempt int) time.Duration {
+	delay := n.Config.MinBackoff
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := 1; i < attempt && delay < n.Config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if n.Config.MaxBackoff > 0 && delay > n.Config.MaxBackoff {
+		delay = n.Config.MaxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// connect dials the websocket and reads messages until the connection is
+// lost. onConnect is called once the connection has been established.
+func (n *Nexa) connect(ctx context.Context, onConnect func()) error {
+	dialCtx := ctx
+	if n.Config.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, n.Config.DialTimeout)
+		defer cancel()
+	}
+
+	c, _, err := websocket.Dial(dialCtx, fmt.Sprintf("ws://%s:%d", n.Config.WebsocketHost, n.Config.WebsocketPort), nil)
+	if err != nil {
+		return err
+	}
+	defer c.CloseNow()
+
+	onConnect()
+	n.setState(StateEvent{State: StateConnected})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go n.keepalive(ctx, c)
+
+	for {
+		_, b, err := c.Read(ctx)
+		if err != nil {
+			return fmt.Errorf("read from socket: %w", err)
+		}
+		msg, err := ParseMessage(string(b))
+		if err != nil {
+			log.Println("error parsing message:", err.Error())
+			continue
+		}
+
+		n.Bus.Publish(ctx, *msg)
+	}
+}
+
+// keepalive pings the websocket at regular intervals and closes it if a
+// pong is not received in time.
+func (n *Nexa) keepalive(ctx context.Context, c *websocket.Conn) {
+	if n.Config.PingInterval <= 0 {
+		return
+	}
+
+	timeout := n.Config.PingTimeout
+	if timeout <= 0 {
+		timeout = n.Config.PingInterval
+	}
+
+	ticker := time.NewTicker(n.Config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := c.Ping(pingCtx)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				log.Println("nexa websocket ping failed:", err.Error())
+				c.CloseNow()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package goblin
+
+import (
+	"context"
+	"time"
+)
+
+type Reading struct {
+	SensorId   string    `json:"sensorId"`
+	Capability string    `json:"capability"`
+	Time       time.Time `json:"time"`
+	Value      float64   `json:"value"`
+	// Aggregated readings cover the window of length Resolution starting
+	// at Time. Value is the mean of the readings in the window, and Min
+	// and Max their range. Raw readings have a zero Resolution.
+	Resolution time.Duration `json:"-"`
+	Min        float64       `json:"min"`
+	Max        float64       `json:"max"`
+}
+
+// Resolutions are the aggregation windows that are picked for readings when
+// a filter doesn't set a resolution, from finest to coarsest.
+var Resolutions = []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour}
+
+// Spans of up to RawSpan return raw readings when the resolution is picked
+// automatically. Longer spans use the finest resolution that gives at most
+// MaxWindows windows per series.
+const (
+	RawSpan    = 24 * time.Hour
+	MaxWindows = 1000
+)
+
+// ResolutionForSpan returns the resolution that is picked for readings
+// over span, where zero is raw readings.
+func ResolutionForSpan(span time.Duration) time.Duration {
+	if span <= RawSpan {
+		return 0
+	}
+	for _, res := range Resolutions {
+		if span/res <= MaxWindows {
+			return res
+		}
+	}
+	return Resolutions[len(Resolutions)-1]
+}
+
+type ReadingService interface {
+	CreateReading(context.Context, *Reading) error
+	// CreateReadings stores several readings at once, skipping readings
+	// that are already stored for the same sensor, capability and time.
+	// It returns the number of readings that were stored.
+	CreateReadings(context.Context, []*Reading) (int, error)
+	FindReadings(context.Context, ReadingFilter) ([]*Reading, error)
+	// StreamReadings calls the function for each reading matching the
+	// filter, without holding all of them in memory. It stops at the
+	// first error returned by the function.
+	StreamReadings(context.Context, ReadingFilter, func(*Reading) error) error
+}
+
+type ReadingFilter struct {
+	SensorId   *string
+	Capability *string
+	// Readings of any of the sensors, sensors in any of the rooms and any
+	// of the capabilities. A nil slice doesn't filter.
+	SensorIds    []string
+	RoomIds      []string
+	Capabilities []string
+	From         *time.Time
+	To           *time.Time
+	// Resolution of the returned readings, which may be any whole number
+	// of seconds. Zero returns raw readings. If unset, the resolution is
+	// picked from the span between From and To, or raw readings are
+	// returned if From is unset.
+	Resolution *time.Duration
+}
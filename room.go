@@ -3,9 +3,9 @@ package goblin
 import "context"
 
 type Room struct {
-	Id      string
-	Name    string
-	Sensors []*Sensor
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Sensors []*Sensor `json:"sensors"`
 }
 
 func NewRoom(id, name string) Room {
@@ -17,11 +17,23 @@ func NewRoom(id, name string) Room {
 
 type RoomService interface {
 	RoomById(context.Context, string) (*Room, error)
+	FindRooms(context.Context, RoomFilter) ([]*Room, error)
 	CreateRoom(context.Context, *Room) error
+	UpdateRoom(context.Context, string, RoomUpdate) (*Room, error)
 	DeleteRoom(context.Context, string) error
 }
 
 type RoomFilter struct {
 	Id   *string
 	Name *string
+
+	// Column to order by, "id" or "name", optionally prefixed with "-"
+	// for descending order
+	OrderBy string
+	Offset  int
+	Limit   int
+}
+
+type RoomUpdate struct {
+	Name *string
 }
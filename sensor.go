@@ -2,22 +2,55 @@ package goblin
 
 import (
 	"context"
+	"time"
 )
 
 type Sensor struct {
-	Id         string
-	SensorType string
-	RoomId     string
+	Id           string   `json:"id"`
+	Name         string   `json:"name"`
+	SensorType   string   `json:"sensorType"`
+	RoomId       string   `json:"roomId"`
+	Capabilities []string `json:"capabilities"`
+	// Time when the sensor was removed from the Nexa Bridge, or nil if it
+	// is still present
+	RemovedAt *time.Time `json:"removedAt"`
 }
 
 type SensorService interface {
 	SensorById(context.Context, string) (*Sensor, error)
+	FindSensors(context.Context, SensorFilter) ([]*Sensor, error)
 	CreateSensor(context.Context, *Sensor) error
+	// SyncSensor inserts or updates a sensor from the Nexa Bridge and sets
+	// sensor to what is stored. Unlike CreateSensor, it keeps the name and
+	// room of an existing sensor if they have been changed with
+	// UpdateSensor, and it returns ECONFLICT for sensors that have been
+	// deleted with DeleteSensor rather than inserting them again.
+	SyncSensor(context.Context, *Sensor) error
+	UpdateSensor(context.Context, string, SensorUpdate) (*Sensor, error)
+	// DeleteSensor deletes the sensor and all of its readings and events.
+	// It isn't synced from the Nexa Bridge again unless it is created
+	// with CreateSensor.
 	DeleteSensor(context.Context, string) error
-	UpdateRoom(context.Context, string, string) error
+	MarkSensorRemoved(context.Context, string, time.Time) error
 }
 
 type SensorFilter struct {
-	Id     *string
+	Id *string
+	// Room of the sensors. An empty string matches sensors without a
+	// room.
+	RoomId  *string
+	Removed *bool
+
+	// Column to order by, "id", "name", "sensor_type" or "room_id",
+	// optionally prefixed with "-" for descending order
+	OrderBy string
+	Offset  int
+	Limit   int
+}
+
+type SensorUpdate struct {
+	Name *string
+	// Room to move the sensor to. An empty string removes the sensor from
+	// its room.
 	RoomId *string
 }
@@ -0,0 +1,255 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupFormat is the format of the time in the names of scheduled backups,
+// which sorts in chronological order.
+const backupFormat = "20060102T150405Z"
+
+// copyDatabase copies the main database of src into dst using the SQLite
+// backup API. The source is read in a single step, so that concurrent
+// writers don't restart the backup; in WAL mode they are not blocked by
+// it.
+func copyDatabase(ctx context.Context, dst, src *sql.DB) error {
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			dstSQLite, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", dstDriverConn)
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", srcDriverConn)
+			}
+
+			backup, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Close()
+					return err
+				}
+				if done {
+					break
+				}
+				// The source or destination is locked, try again
+				select {
+				case <-ctx.Done():
+					backup.Close()
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+
+			return backup.Finish()
+		})
+	})
+}
+
+// Backup writes a consistent copy of the database to path while it is in
+// use. The copy is written next to path and renamed into place when it is
+// complete.
+func (db *DB) Backup(ctx context.Context, path string) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+
+	dst, err := sql.Open("sqlite3", "file:"+tmp)
+	if err != nil {
+		return err
+	}
+	if err := copyDatabase(ctx, dst, db.db); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("backup to %s: %w", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Restore replaces the contents of the database with the backup at path.
+// The backup must only have applied migrations that are known to this
+// version of goblin. Migrations that are pending in the backup are not
+// applied. The database should not be in use by another process while it is
+// restored.
+func (db *DB) Restore(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	// Check a copy so that the backup itself is never modified, since
+	// reading the migrations may upgrade the migrations table
+	tmp, err := os.MkdirTemp("", "goblin-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// The backup isn't written to, so it can be read without creating
+	// WAL files next to it
+	src, err := sql.Open("sqlite3", "file:"+path+"?immutable=1")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	backup := NewDatabase("file:" + filepath.Join(tmp, "goblin.db"))
+	if err := backup.Connect(); err != nil {
+		return err
+	}
+	defer backup.Close()
+
+	if err := copyDatabase(ctx, backup.db, src); err != nil {
+		return fmt.Errorf("read backup %s: %w", path, err)
+	}
+
+	var integrity string
+	if err := backup.db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return fmt.Errorf("check backup %s: %w", path, err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("backup %s is corrupt: %s", path, integrity)
+	}
+
+	migrations, err := backup.Migrations(ctx)
+	if err != nil {
+		return fmt.Errorf("read migrations of backup %s: %w", path, err)
+	}
+	if err := checkMigrations(migrations); err != nil {
+		return fmt.Errorf("backup %s is not compatible: %w", path, err)
+	}
+	for _, m := range migrations {
+		if m.Pending() {
+			log.Printf("migration %s is pending in backup %s", m.Name, path)
+		}
+	}
+
+	if err := copyDatabase(ctx, db.db, backup.db); err != nil {
+		return fmt.Errorf("restore %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Backups writes backups of a database to a directory and removes old
+// ones.
+type Backups struct {
+	db *DB
+	// Directory that backups are written to
+	Dir string
+	// Number of backups to keep, zero keeps all
+	Keep int
+}
+
+func NewBackups(db *DB, dir string, keep int) *Backups {
+	return &Backups{
+		db:   db,
+		Dir:  dir,
+		Keep: keep,
+	}
+}
+
+// Run creates a backup every interval until ctx is done.
+func (b *Backups) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if path, err := b.Create(ctx); err != nil {
+				log.Println("error backing up database:", err.Error())
+			} else {
+				log.Printf("backed up database to %s", path)
+			}
+		}
+	}
+}
+
+// Create writes a backup named by the current time to the backup
+// directory, removes the oldest backups beyond Keep and returns the path of
+// the new backup.
+func (b *Backups) Create(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(b.Dir, "goblin-"+time.Now().UTC().Format(backupFormat)+".db")
+	if err := b.db.Backup(ctx, path); err != nil {
+		return "", err
+	}
+
+	if err := b.rotate(); err != nil {
+		return path, fmt.Errorf("rotate backups: %w", err)
+	}
+
+	return path, nil
+}
+
+// List returns the paths of the backups in the backup directory, oldest
+// first.
+func (b *Backups) List() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(b.Dir, "goblin-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	backups := paths[:0]
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "goblin-"), ".db")
+		if _, err := time.Parse(backupFormat, name); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (b *Backups) rotate() error {
+	if b.Keep <= 0 {
+		return nil
+	}
+	backups, err := b.List()
+	if err != nil {
+		return err
+	}
+	for len(backups) > b.Keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/sqlite"
+)
+
+// roomNames returns the names of the rooms in db, ordered by id.
+func roomNames(t *testing.T, db *sqlite.DB) []string {
+	t.Helper()
+	rooms, err := sqlite.NewRoomService(db).FindRooms(context.Background(), goblin.RoomFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		names = append(names, room.Name)
+	}
+	return names
+}
+
+func createRoom(t *testing.T, db *sqlite.DB, id, name string) {
+	t.Helper()
+	room := goblin.NewRoom(id, name)
+	if err := sqlite.NewRoomService(db).CreateRoom(context.Background(), &room); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db := openDB(t)
+	createRoom(t, db, "1", "Kitchen")
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := db.Backup(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary copy to be renamed, got %v", err)
+	}
+
+	// The backup is a complete database that can be opened on its own
+	backup := sqlite.NewDatabase(path)
+	if err := backup.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if names := roomNames(t, backup); !reflect.DeepEqual(names, []string{"Kitchen"}) {
+		t.Errorf("expected the backup to have the kitchen, got %v", names)
+	}
+}
+
+func TestBackupsKeep(t *testing.T) {
+	db := openDB(t)
+	dir := t.TempDir()
+
+	// Older backups, and a file that isn't a backup, which is never
+	// removed
+	older := []string{"goblin-20240101T000000Z.db", "goblin-20240102T000000Z.db", "goblin-20240103T000000Z.db"}
+	for _, name := range append(older, "goblin-copy.db") {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups := sqlite.NewBackups(db, dir, 2)
+	path, err := backups.Create(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := backups.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, older[2]), path}; !reflect.DeepEqual(list, want) {
+		t.Errorf("expected backups %v, got %v", want, list)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "goblin-copy.db")); err != nil {
+		t.Errorf("expected other files to be kept, got %v", err)
+	}
+
+	// Zero keeps all backups
+	backups.Keep = 0
+	for _, name := range older {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := backups.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := backups.List(); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 4 {
+		t.Errorf("expected 4 backups, got %v", list)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	db := openDB(t)
+	createRoom(t, db, "1", "Kitchen")
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := db.Backup(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	createRoom(t, db, "2", "Bedroom")
+
+	if err := db.Restore(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if names := roomNames(t, db); !reflect.DeepEqual(names, []string{"Kitchen"}) {
+		t.Errorf("expected only the kitchen after restoring, got %v", names)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	valid := filepath.Join(t.TempDir(), "valid.db")
+	if err := openDB(t).Backup(context.Background(), valid); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// Writes the backup to path
+		write func(t *testing.T, path string)
+		err   string
+	}{
+		{
+			name: "not a database",
+			write: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, bytes.Repeat([]byte("goblin"), 1024), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "not a database",
+		},
+		{
+			name: "truncated",
+			write: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, buf[:len(buf)/2], 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "malformed",
+		},
+		{
+			name: "newer schema",
+			write: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, buf, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				execFile(t, path, `INSERT INTO migrations (name, checksum, applied_at) VALUES ('migrations/000099.sql', 'newer', '2030-01-01T00:00:00.000Z')`)
+			},
+			err: "is not compatible: database has migration migrations/000099.sql applied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openDB(t)
+			createRoom(t, db, "1", "Kitchen")
+			path := filepath.Join(t.TempDir(), "backup.db")
+			test.write(t, path)
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = db.Restore(context.Background(), path)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+			if names := roomNames(t, db); !reflect.DeepEqual(names, []string{"Kitchen"}) {
+				t.Errorf("expected the database to be untouched, got %v", names)
+			}
+			if after, err := os.ReadFile(path); err != nil || !bytes.Equal(before, after) {
+				t.Errorf("expected the backup to be untouched, got %v", err)
+			}
+		})
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
-	"io/fs"
 	"log"
-	"sort"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/maehler/goblin"
+	"github.com/mattn/go-sqlite3"
 )
 
 type DB struct {
@@ -19,6 +21,17 @@ type DB struct {
 	cancel  context.CancelFunc
 }
 
+// timeFormat is used for all timestamps stored in the database. It has a
+// fixed width so that timestamps can be compared as strings.
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+// isConstraint reports whether err is a violation of a constraint of the
+// kind, such as sqlite3.ErrConstraintForeignKey.
+func isConstraint(err error, kind sqlite3.ErrNoExtended) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == kind
+}
+
 //go:embed migrations/*.sql
 var migrationFS embed.FS
 
@@ -31,7 +44,21 @@ func NewDatabase(dsn string) *DB {
 	return db
 }
 
+// Open connects to the database and applies all pending migrations.
 func (db *DB) Open() error {
+	if err := db.Connect(); err != nil {
+		return err
+	}
+
+	if err := db.MigrateUp(db.context); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Connect connects to the database without migrating it.
+func (db *DB) Connect() error {
 	log.Printf("Connecting to database %s", db.dsn)
 	var err error
 	if db.db, err = sql.Open("sqlite3", db.dsn); err != nil {
@@ -46,57 +73,53 @@ func (db *DB) Open() error {
 		return fmt.Errorf("enable foreign keys: %s", err)
 	}
 
-	if err := db.migrate(); err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func (db DB) migrate() error {
-	if _, err := db.db.Exec(`CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY);`); err != nil {
-		return fmt.Errorf("Failed to create migrations table: %s", err.Error())
-	}
-
-	fnames, err := fs.Glob(migrationFS, "migrations/*.sql")
-	if err != nil {
-		return err
+func (db *DB) Close() error {
+	db.cancel()
+	if db.db != nil {
+		return db.db.Close()
 	}
-	sort.Strings(fnames)
-
-	for _, fname := range fnames {
-		if err := db.migrateFile(fname); err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
-func (db DB) migrateFile(fname string) error {
-	log.Printf("Running migration for %s", fname)
-	tx, err := db.db.Begin()
-	if err != nil {
-		return err
-	}
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
 
-	var n int
-	if err := tx.QueryRow(`SELECT COUNT(*) FROM migrations WHERE name = ?`, fname).Scan(&n); err != nil {
-		return err
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(timeFormat, s)
+}
+
+// formatOrderBy returns an ORDER BY clause for orderBy, which is a column
+// name optionally prefixed with "-" for descending order. Only the columns
+// in allowed may be used, and the first of them is the default.
+func formatOrderBy(orderBy string, allowed ...string) (string, error) {
+	if orderBy == "" {
+		return "ORDER BY " + allowed[0] + " ASC", nil
 	}
-	if n != 0 {
-		return nil
+	column, direction := orderBy, "ASC"
+	if strings.HasPrefix(orderBy, "-") {
+		column, direction = orderBy[1:], "DESC"
 	}
-
-	if buf, err := fs.ReadFile(migrationFS, fname); err != nil {
-		return err
-	} else if _, err := tx.Exec(string(buf)); err != nil {
-		return err
+	for _, c := range allowed {
+		if c == column {
+			return "ORDER BY " + column + " " + direction, nil
+		}
 	}
+	return "", goblin.Errorf(goblin.EINVALID, "cannot order by %q", orderBy)
+}
 
-	if _, err := tx.Exec(`INSERT INTO migrations (name) VALUES (?)`, fname); err != nil {
-		return err
+// formatLimitOffset returns a LIMIT/OFFSET clause, or an empty string if
+// neither is set.
+func formatLimitOffset(limit, offset int) string {
+	if limit > 0 && offset > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	} else if limit > 0 {
+		return fmt.Sprintf("LIMIT %d", limit)
+	} else if offset > 0 {
+		return fmt.Sprintf("LIMIT -1 OFFSET %d", offset)
 	}
-
-	return tx.Commit()
+	return ""
 }
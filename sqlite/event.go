@@ -0,0 +1,180 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/maehler/goblin"
+	"github.com/mattn/go-sqlite3"
+)
+
+type EventService struct {
+	db *DB
+}
+
+func NewEventService(db *DB) *EventService {
+	return &EventService{db}
+}
+
+func (s *EventService) CreateEvent(ctx context.Context, event *goblin.Event) error {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *EventService) FindEvents(ctx context.Context, filter goblin.EventFilter) ([]*goblin.Event, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return events(ctx, tx, filter)
+}
+
+func (s *EventService) CountEvents(ctx context.Context, filter goblin.EventFilter) (int, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := eventWhere(filter)
+	var n int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM events WHERE `+strings.Join(where, " AND "), args...).Scan(&n)
+	return n, err
+}
+
+func createEvent(ctx context.Context, tx *sql.Tx, event *goblin.Event) error {
+	if event.Capability == "" {
+		return goblin.Errorf(goblin.EINVALID, "event has no capability")
+	}
+	res, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO events (sensor_id, capability, value, prev_value, time) VALUES (?, ?, ?, ?, ?)`,
+		nullString(event.SensorId),
+		event.Capability,
+		event.Value,
+		nullString(event.PrevValue),
+		formatTime(event.Time),
+	)
+	if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+		return goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", event.SensorId)
+	} else if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	event.Id = int(id)
+	return nil
+}
+
+func eventWhere(filter goblin.EventFilter) ([]string, []interface{}) {
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if v := filter.Id; v != nil {
+		where = append(where, "id = ?")
+		args = append(args, *v)
+	}
+	if v := filter.SensorId; v != nil {
+		if *v == "" {
+			where = append(where, "sensor_id IS NULL")
+		} else {
+			where = append(where, "sensor_id = ?")
+			args = append(args, *v)
+		}
+	}
+	if v := filter.RoomId; v != nil {
+		where = append(where, "sensor_id IN (SELECT id FROM sensors WHERE room_id = ?)")
+		args = append(args, *v)
+	}
+	if v := filter.Capability; v != nil {
+		where = append(where, "capability = ?")
+		args = append(args, *v)
+	}
+	if v := filter.Value; v != nil {
+		where = append(where, "value = ?")
+		args = append(args, *v)
+	}
+	if v := filter.From; v != nil {
+		where = append(where, "time >= ?")
+		args = append(args, formatTime(*v))
+	}
+	if v := filter.To; v != nil {
+		where = append(where, "time < ?")
+		args = append(args, formatTime(*v))
+	}
+	return where, args
+}
+
+func events(ctx context.Context, tx *sql.Tx, filter goblin.EventFilter) ([]*goblin.Event, error) {
+	where, args := eventWhere(filter)
+
+	orderBy, err := formatOrderBy(filter.OrderBy, "time", "id")
+	if err != nil {
+		return nil, err
+	}
+	// Events at the same time are in the order they were stored
+	if strings.HasPrefix(filter.OrderBy, "-") {
+		orderBy += ", id DESC"
+	} else {
+		orderBy += ", id ASC"
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT
+		id,
+		sensor_id,
+		capability,
+		value,
+		prev_value,
+		time
+	FROM events
+	WHERE `+strings.Join(where, " AND ")+`
+	`+orderBy+`
+	`+formatLimitOffset(filter.Limit, filter.Offset),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*goblin.Event, 0)
+	for rows.Next() {
+		event := &goblin.Event{}
+		var sensorId, prevValue sql.NullString
+		var t string
+		err := rows.Scan(
+			&event.Id,
+			&sensorId,
+			&event.Capability,
+			&event.Value,
+			&prevValue,
+			&t,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.SensorId = sensorId.String
+		event.PrevValue = prevValue.String
+		if event.Time, err = parseTime(t); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,315 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migration is a schema migration in migrations/. Migrations are applied in
+// the order of their names. A migration NNNNNN.sql can be reverted if there
+// is a down-migration NNNNNN.down.sql next to it.
+type Migration struct {
+	Name string
+	// Checksum of the migration file, empty if the migration has been
+	// applied but is unknown to this version of goblin.
+	Checksum string
+	// Name of the down-migration, empty if the migration can't be reverted.
+	Down string
+	// When the migration was applied, nil if it is pending.
+	AppliedAt *time.Time
+	// Checksum of the migration file when it was applied.
+	AppliedChecksum string
+}
+
+// Pending reports whether the migration has not been applied.
+func (m *Migration) Pending() bool {
+	return m.AppliedAt == nil
+}
+
+// Unknown reports whether the migration has been applied, but is not part
+// of this version of goblin. This happens if the database has been
+// migrated by a newer version.
+func (m *Migration) Unknown() bool {
+	return m.Checksum == ""
+}
+
+// Modified reports whether the migration file has been changed since the
+// migration was applied.
+func (m *Migration) Modified() bool {
+	return !m.Pending() && !m.Unknown() && m.Checksum != m.AppliedChecksum
+}
+
+func checksum(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationFiles returns the embedded migrations in the order they are
+// applied.
+func migrationFiles() ([]*Migration, error) {
+	fnames, err := fs.Glob(migrationFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fnames)
+
+	migrations := make([]*Migration, 0, len(fnames))
+	for _, fname := range fnames {
+		if strings.HasSuffix(fname, ".down.sql") {
+			continue
+		}
+		buf, err := fs.ReadFile(migrationFS, fname)
+		if err != nil {
+			return nil, err
+		}
+		m := &Migration{
+			Name:     fname,
+			Checksum: checksum(buf),
+		}
+		down := strings.TrimSuffix(fname, ".sql") + ".down.sql"
+		if _, err := fs.Stat(migrationFS, down); err == nil {
+			m.Down = down
+		}
+		migrations = append(migrations, m)
+	}
+
+	return migrations, nil
+}
+
+// initMigrations creates the migrations table. Databases created before
+// migrations had checksums get the missing columns, and the checksums of
+// the migrations they have applied are taken from the current files.
+func (db *DB) initMigrations(ctx context.Context) error {
+	_, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS migrations (
+		name TEXT PRIMARY KEY,
+		checksum TEXT,
+		applied_at TEXT
+	)`)
+	if err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+
+	columns := make(map[string]bool)
+	rows, err := db.db.QueryContext(ctx, `SELECT name FROM pragma_table_info('migrations')`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range []string{"checksum", "applied_at"} {
+		if columns[column] {
+			continue
+		}
+		if _, err := db.db.ExecContext(ctx, `ALTER TABLE migrations ADD COLUMN `+column+` TEXT`); err != nil {
+			return fmt.Errorf("upgrade migrations table: %w", err)
+		}
+	}
+
+	files, err := migrationFiles()
+	if err != nil {
+		return err
+	}
+	for _, m := range files {
+		res, err := db.db.ExecContext(ctx, `UPDATE migrations SET checksum = ? WHERE name = ? AND checksum IS NULL`, m.Checksum, m.Name)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("recorded checksum of previously applied migration %s", m.Name)
+		}
+	}
+
+	return nil
+}
+
+// Migrations returns all migrations, both those that are part of this
+// version of goblin and those that have been applied to the database.
+func (db *DB) Migrations(ctx context.Context) ([]*Migration, error) {
+	if err := db.initMigrations(ctx); err != nil {
+		return nil, err
+	}
+
+	migrations, err := migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Migration)
+	for _, m := range migrations {
+		byName[m.Name] = m
+	}
+
+	rows, err := db.db.QueryContext(ctx, `SELECT name, checksum, applied_at FROM migrations ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var sum, appliedAt sql.NullString
+		if err := rows.Scan(&name, &sum, &appliedAt); err != nil {
+			return nil, err
+		}
+		m, ok := byName[name]
+		if !ok {
+			m = &Migration{Name: name}
+			migrations = append(migrations, m)
+		}
+		m.AppliedChecksum = sum.String
+		// Migrations applied before applied_at was recorded get the zero
+		// time
+		t := time.Time{}
+		if appliedAt.Valid {
+			if t, err = parseTime(appliedAt.String); err != nil {
+				return nil, err
+			}
+		}
+		m.AppliedAt = &t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Name < migrations[j].Name
+	})
+
+	return migrations, nil
+}
+
+// checkMigrations returns an error if an applied migration has been
+// modified or is unknown to this version of goblin.
+func checkMigrations(migrations []*Migration) error {
+	for _, m := range migrations {
+		if m.Unknown() {
+			return fmt.Errorf("database has migration %s applied, which is unknown to this version of goblin", m.Name)
+		}
+		if m.Modified() {
+			return fmt.Errorf("migration %s has been modified since it was applied (checksum %s, applied %s)", m.Name, m.Checksum, m.AppliedChecksum)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies all pending migrations, each in its own transaction.
+// Nothing is applied if an applied migration has been modified or is
+// unknown.
+func (db *DB) MigrateUp(ctx context.Context) error {
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkMigrations(migrations); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if !m.Pending() {
+			continue
+		}
+		if err := db.migrateFile(ctx, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the n most recently applied migrations, each in its
+// own transaction. It stops at the first migration that can't be reverted.
+func (db *DB) MigrateDown(ctx context.Context, n int) error {
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkMigrations(migrations); err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && n > 0; i-- {
+		m := migrations[i]
+		if m.Pending() {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %s can't be reverted", m.Name)
+		}
+		if err := db.revertFile(ctx, m); err != nil {
+			return fmt.Errorf("revert migration %s: %w", m.Name, err)
+		}
+		n--
+	}
+
+	return nil
+}
+
+func (db *DB) migrateFile(ctx context.Context, m *Migration) error {
+	log.Printf("Running migration for %s", m.Name)
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	buf, err := fs.ReadFile(migrationFS, m.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, string(buf)); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO migrations (name, checksum, applied_at) VALUES (?, ?, ?)`,
+		m.Name,
+		m.Checksum,
+		formatTime(time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) revertFile(ctx context.Context, m *Migration) error {
+	log.Printf("Reverting migration %s with %s", m.Name, m.Down)
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	buf, err := fs.ReadFile(migrationFS, m.Down)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, string(buf)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM migrations WHERE name = ?`, m.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,237 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maehler/goblin/sqlite"
+)
+
+// execFile executes statements on the database file at path outside of
+// DB, the way an older or newer version of goblin would.
+func execFile(t *testing.T, path string, queries ...string) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, query := range queries {
+		if _, err := conn.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+}
+
+// connectDB connects to the database file at path without migrating it.
+func connectDB(t *testing.T, path string) *sqlite.DB {
+	t.Helper()
+	db := sqlite.NewDatabase(path)
+	if err := db.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// migrations returns the migrations of db by name.
+func migrations(t *testing.T, db *sqlite.DB) map[string]*sqlite.Migration {
+	t.Helper()
+	list, err := db.Migrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*sqlite.Migration)
+	for _, m := range list {
+		byName[m.Name] = m
+	}
+	return byName
+}
+
+// hasTable reports whether the database file at path has the table.
+func hasTable(t *testing.T, path, table string) bool {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goblin.db")
+	db := connectDB(t, path)
+
+	pending := migrations(t, db)
+	if len(pending) != 6 {
+		t.Fatalf("expected 6 migrations, got %d", len(pending))
+	}
+	for name, m := range pending {
+		if !m.Pending() || m.Unknown() || m.Modified() {
+			t.Errorf("expected %s to be pending, got %+v", name, m)
+		}
+	}
+	if m := pending["migrations/000001.sql"]; m.Down != "" {
+		t.Errorf("expected migrations/000001.sql to be irreversible, got down-migration %s", m.Down)
+	}
+	if m := pending["migrations/000005.sql"]; m.Down != "migrations/000005.down.sql" {
+		t.Errorf("expected down-migration migrations/000005.down.sql, got %q", m.Down)
+	}
+
+	if err := db.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range migrations(t, db) {
+		if m.Pending() || m.AppliedAt.IsZero() || m.AppliedChecksum != m.Checksum {
+			t.Errorf("expected %s to be applied with its checksum, got %+v", name, m)
+		}
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "goblin.db")
+	db := connectDB(t, path)
+	if err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.MigrateDown(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if hasTable(t, path, "deleted_sensors") {
+		t.Error("expected migrations/000005.down.sql to drop deleted_sensors")
+	}
+	byName := migrations(t, db)
+	if !byName["migrations/000005.sql"].Pending() || byName["migrations/000004.sql"].Pending() {
+		t.Error("expected only migrations/000005.sql to be reverted")
+	}
+
+	// Every down-migration reverts its migration, and the migrations can
+	// be applied again
+	if err := db.MigrateDown(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range migrations(t, db) {
+		if want := name >= "migrations/000002.sql"; m.Pending() != want {
+			t.Errorf("expected %s pending to be %t", name, want)
+		}
+	}
+	if err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !hasTable(t, path, "deleted_sensors") {
+		t.Error("expected migrations/000005.sql to create deleted_sensors again")
+	}
+
+	// Reverting stops at the first migration without a down-migration
+	err := db.MigrateDown(ctx, 10)
+	if err == nil || err.Error() != "migration migrations/000001.sql can't be reverted" {
+		t.Fatalf("expected migrations/000001.sql not to be reverted, got %v", err)
+	}
+	for name, m := range migrations(t, db) {
+		if want := name >= "migrations/000002.sql"; m.Pending() != want {
+			t.Errorf("expected %s pending to be %t", name, want)
+		}
+	}
+}
+
+func TestMigrateModified(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "goblin.db")
+	db := connectDB(t, path)
+	if err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MigrateDown(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	execFile(t, path, `UPDATE migrations SET checksum = 'edited' WHERE name = 'migrations/000002.sql'`)
+
+	m := migrations(t, db)["migrations/000002.sql"]
+	if !m.Modified() || m.Unknown() || m.AppliedChecksum != "edited" {
+		t.Fatalf("expected migrations/000002.sql to be modified, got %+v", m)
+	}
+
+	// Neither direction touches a database with a modified migration
+	for name, migrate := range map[string]func() error{
+		"up":   func() error { return db.MigrateUp(ctx) },
+		"down": func() error { return db.MigrateDown(ctx, 1) },
+	} {
+		if err := migrate(); err == nil || !strings.Contains(err.Error(), "migration migrations/000002.sql has been modified") {
+			t.Errorf("%s: expected a modified migration error, got %v", name, err)
+		}
+	}
+	byName := migrations(t, db)
+	if !byName["migrations/000005.sql"].Pending() || byName["migrations/000004.sql"].Pending() {
+		t.Error("expected no migrations to be applied or reverted")
+	}
+}
+
+func TestMigrateUnknown(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "goblin.db")
+	db := connectDB(t, path)
+	if err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	execFile(t, path, `INSERT INTO migrations (name, checksum, applied_at) VALUES ('migrations/000099.sql', 'newer', '2030-01-01T00:00:00.000Z')`)
+
+	m := migrations(t, db)["migrations/000099.sql"]
+	if m == nil || !m.Unknown() || m.Modified() || m.Pending() || m.AppliedChecksum != "newer" {
+		t.Fatalf("expected migrations/000099.sql to be unknown, got %+v", m)
+	}
+	err := db.MigrateUp(ctx)
+	if err == nil || !strings.Contains(err.Error(), "unknown to this version of goblin") {
+		t.Fatalf("expected an unknown migration error, got %v", err)
+	}
+}
+
+func TestMigrateOldMigrationsTable(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "goblin.db")
+
+	// Databases created before checksums only recorded the names of the
+	// applied migrations
+	queries := []string{`CREATE TABLE migrations (name TEXT PRIMARY KEY)`}
+	for _, name := range []string{"migrations/000000.sql", "migrations/000001.sql"} {
+		buf, err := os.ReadFile(filepath.FromSlash(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queries = append(queries, string(buf), `INSERT INTO migrations (name) VALUES ('`+name+`')`)
+	}
+	execFile(t, path, queries...)
+
+	db := connectDB(t, path)
+	for name, m := range migrations(t, db) {
+		switch name {
+		case "migrations/000000.sql", "migrations/000001.sql":
+			if m.Pending() || !m.AppliedAt.IsZero() || m.Modified() || m.AppliedChecksum != m.Checksum {
+				t.Errorf("expected %s to be applied with the current checksum, got %+v", name, m)
+			}
+		default:
+			if !m.Pending() {
+				t.Errorf("expected %s to be pending, got %+v", name, m)
+			}
+		}
+	}
+
+	if err := db.MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range migrations(t, db) {
+		if m.Pending() || m.Modified() {
+			t.Errorf("expected %s to be applied, got %+v", name, m)
+		}
+	}
+}
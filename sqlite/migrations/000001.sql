@@ -0,0 +1,44 @@
+-- Sensors may not belong to a room, and nodes that disappear from the
+-- Nexa Bridge are marked as removed rather than deleted so that their
+-- readings are kept. SQLite can't alter column constraints, so the
+-- sensors table is rebuilt. The reading tables reference it and are
+-- rebuilt as well, children first, so that no foreign keys are violated.
+CREATE TABLE sensors_old AS SELECT * FROM sensors;
+CREATE TABLE temperature_old AS SELECT * FROM temperature;
+CREATE TABLE humidity_old AS SELECT * FROM humidity;
+
+DROP TABLE temperature;
+DROP TABLE humidity;
+DROP TABLE sensors;
+
+CREATE TABLE sensors (
+    id TEXT PRIMARY KEY NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    sensor_type TEXT NOT NULL,
+    room_id TEXT REFERENCES rooms(id),
+    capabilities TEXT NOT NULL DEFAULT '',
+    removed_at TEXT
+);
+
+CREATE INDEX sensors_room_id_idx ON sensors (room_id);
+
+CREATE TABLE temperature (
+    time TEXT PRIMARY KEY NOT NULL,
+    sensor_id TEXT NOT NULL REFERENCES sensors(id),
+    value FLOAT
+);
+
+CREATE TABLE humidity (
+    time TEXT PRIMARY KEY NOT NULL,
+    sensor_id TEXT NOT NULL REFERENCES sensors(id),
+    value FLOAT
+);
+
+INSERT INTO sensors (id, sensor_type, room_id)
+SELECT id, sensor_type, room_id FROM sensors_old;
+INSERT INTO temperature SELECT time, sensor_id, value FROM temperature_old;
+INSERT INTO humidity SELECT time, sensor_id, value FROM humidity_old;
+
+DROP TABLE sensors_old;
+DROP TABLE temperature_old;
+DROP TABLE humidity_old;
@@ -0,0 +1,22 @@
+-- The old tables only hold temperature and humidity, and only one reading
+-- per point in time, so readings of other capabilities and readings at the
+-- same time from several sensors are lost.
+CREATE TABLE temperature (
+    time TEXT PRIMARY KEY NOT NULL,
+    sensor_id TEXT NOT NULL REFERENCES sensors(id),
+    value FLOAT
+);
+
+CREATE TABLE humidity (
+    time TEXT PRIMARY KEY NOT NULL,
+    sensor_id TEXT NOT NULL REFERENCES sensors(id),
+    value FLOAT
+);
+
+INSERT OR IGNORE INTO temperature (time, sensor_id, value)
+SELECT time, sensor_id, value FROM readings WHERE capability = 'temperature';
+
+INSERT OR IGNORE INTO humidity (time, sensor_id, value)
+SELECT time, sensor_id, value FROM readings WHERE capability = 'humidity';
+
+DROP TABLE readings;
@@ -0,0 +1,22 @@
+-- All numeric readings are stored in a single table, keyed by sensor,
+-- capability and time so that sensors reporting at the same time don't
+-- collide.
+CREATE TABLE readings (
+    sensor_id TEXT NOT NULL REFERENCES sensors(id),
+    capability TEXT NOT NULL,
+    time TEXT NOT NULL,
+    value REAL NOT NULL CHECK (typeof(value) = 'real'),
+    PRIMARY KEY (sensor_id, capability, time)
+) WITHOUT ROWID;
+
+CREATE INDEX readings_capability_time_idx ON readings (capability, time);
+CREATE INDEX readings_time_idx ON readings (time);
+
+INSERT OR IGNORE INTO readings (sensor_id, capability, time, value)
+SELECT sensor_id, 'temperature', time, value FROM temperature WHERE value IS NOT NULL;
+
+INSERT OR IGNORE INTO readings (sensor_id, capability, time, value)
+SELECT sensor_id, 'humidity', time, value FROM humidity WHERE value IS NOT NULL;
+
+DROP TABLE temperature;
+DROP TABLE humidity;
@@ -0,0 +1,2 @@
+DROP TABLE rollup_watermarks;
+DROP TABLE reading_rollups;
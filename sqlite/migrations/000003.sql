@@ -0,0 +1,21 @@
+-- Rollups of readings into fixed windows. resolution is the length of the
+-- window in seconds and time is the start of the window.
+CREATE TABLE reading_rollups (
+    resolution INTEGER NOT NULL,
+    sensor_id TEXT NOT NULL REFERENCES sensors(id),
+    capability TEXT NOT NULL,
+    time TEXT NOT NULL,
+    min REAL NOT NULL,
+    mean REAL NOT NULL,
+    max REAL NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (resolution, sensor_id, capability, time)
+) WITHOUT ROWID;
+
+CREATE INDEX reading_rollups_resolution_time_idx ON reading_rollups (resolution, time);
+
+-- Readings before rolled_until have been rolled up for the resolution.
+CREATE TABLE rollup_watermarks (
+    resolution INTEGER PRIMARY KEY,
+    rolled_until TEXT NOT NULL
+);
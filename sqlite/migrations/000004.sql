@@ -0,0 +1,13 @@
+-- Discrete events, such as doors opening and buttons being pressed. System
+-- events that don't come from a sensor have no sensor_id.
+CREATE TABLE events (
+    id INTEGER PRIMARY KEY,
+    sensor_id TEXT REFERENCES sensors(id),
+    capability TEXT NOT NULL,
+    value TEXT NOT NULL,
+    prev_value TEXT,
+    time TEXT NOT NULL
+);
+
+CREATE INDEX events_sensor_id_capability_time_idx ON events (sensor_id, capability, time);
+CREATE INDEX events_time_idx ON events (time);
@@ -0,0 +1,4 @@
+DROP TABLE deleted_sensors;
+
+ALTER TABLE sensors DROP COLUMN room_edited;
+ALTER TABLE sensors DROP COLUMN name_edited;
@@ -0,0 +1,10 @@
+-- Names and rooms of sensors that users have changed are kept when the
+-- inventory is synced with the Nexa Bridge, and sensors that users have
+-- deleted are not synced again.
+ALTER TABLE sensors ADD COLUMN name_edited INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sensors ADD COLUMN room_edited INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE deleted_sensors (
+    id TEXT PRIMARY KEY NOT NULL,
+    deleted_at TEXT NOT NULL
+);
@@ -0,0 +1,444 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/mattn/go-sqlite3"
+)
+
+type ReadingService struct {
+	db *DB
+}
+
+func NewReadingService(db *DB) *ReadingService {
+	return &ReadingService{db}
+}
+
+func (s *ReadingService) CreateReading(ctx context.Context, reading *goblin.Reading) error {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createReading(ctx, tx, reading); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateReadings stores readings in a single transaction, skipping readings
+// that are already stored for the same sensor, capability and time. It
+// returns the number of readings that were stored.
+func (s *ReadingService) CreateReadings(ctx context.Context, readings []*goblin.Reading) (int, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := createReadings(ctx, tx, readings)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *ReadingService) FindReadings(ctx context.Context, filter goblin.ReadingFilter) ([]*goblin.Reading, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return readings(ctx, tx, filter)
+}
+
+// StreamReadings calls fn for each reading matching filter, in the same
+// order as FindReadings, while reading them from the database. It stops at
+// the first error returned by fn.
+func (s *ReadingService) StreamReadings(ctx context.Context, filter goblin.ReadingFilter, fn func(*goblin.Reading) error) error {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return eachReading(ctx, tx, filter, fn)
+}
+
+func createReading(ctx context.Context, tx *sql.Tx, reading *goblin.Reading) error {
+	if reading.Capability == "" {
+		return goblin.Errorf(goblin.EINVALID, "reading has no capability")
+	}
+	stmt := `INSERT INTO readings (sensor_id, capability, time, value) VALUES (?, ?, ?, ?)`
+	_, err := tx.ExecContext(
+		ctx,
+		stmt,
+		reading.SensorId,
+		reading.Capability,
+		formatTime(reading.Time),
+		reading.Value,
+	)
+	switch {
+	case isConstraint(err, sqlite3.ErrConstraintForeignKey):
+		return goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", reading.SensorId)
+	case isConstraint(err, sqlite3.ErrConstraintPrimaryKey):
+		return goblin.Errorf(goblin.ECONFLICT, "reading of %s %s at %s already exists", reading.SensorId, reading.Capability, reading.Time.Format(time.RFC3339Nano))
+	case err != nil:
+		return err
+	}
+	return rollupLate(ctx, tx, []*goblin.Reading{reading})
+}
+
+func createReadings(ctx context.Context, tx *sql.Tx, readings []*goblin.Reading) (int, error) {
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO readings (sensor_id, capability, time, value)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (sensor_id, capability, time) DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	created := make([]*goblin.Reading, 0, len(readings))
+	for _, reading := range readings {
+		if reading.Capability == "" {
+			return 0, goblin.Errorf(goblin.EINVALID, "reading has no capability")
+		}
+		res, err := stmt.ExecContext(
+			ctx,
+			reading.SensorId,
+			reading.Capability,
+			formatTime(reading.Time),
+			reading.Value,
+		)
+		if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+			return 0, goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", reading.SensorId)
+		} else if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return 0, err
+		} else if n > 0 {
+			created = append(created, reading)
+		}
+	}
+
+	if err := rollupLate(ctx, tx, created); err != nil {
+		return 0, err
+	}
+
+	return len(created), nil
+}
+
+func readings(ctx context.Context, tx *sql.Tx, filter goblin.ReadingFilter) ([]*goblin.Reading, error) {
+	readings := make([]*goblin.Reading, 0)
+	err := eachReading(ctx, tx, filter, func(reading *goblin.Reading) error {
+		readings = append(readings, reading)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+func eachReading(ctx context.Context, tx *sql.Tx, filter goblin.ReadingFilter, fn func(*goblin.Reading) error) error {
+	var res time.Duration
+	if v := filter.Resolution; v != nil {
+		res = *v
+		if res < 0 || res%time.Second != 0 {
+			return goblin.Errorf(goblin.EINVALID, "unsupported resolution %s", res)
+		}
+	} else {
+		var err error
+		if res, err = readingResolution(ctx, tx, filter); err != nil {
+			return err
+		}
+	}
+
+	if res == 0 {
+		return rawReadings(ctx, tx, filter, fn)
+	}
+	return aggregatedReadings(ctx, tx, filter, res, fn)
+}
+
+// readingResolution picks the resolution for the span of filter as
+// goblin.ResolutionForSpan does, or raw readings if filter has no span. If
+// the readings at that resolution have been pruned from the start of the
+// span, a coarser resolution that goes further back is used instead.
+func readingResolution(ctx context.Context, tx *sql.Tx, filter goblin.ReadingFilter) (time.Duration, error) {
+	if filter.From == nil {
+		return 0, nil
+	}
+	to := time.Now()
+	if filter.To != nil {
+		to = *filter.To
+	}
+	span := to.Sub(*filter.From)
+
+	// Index into Resolutions, where -1 is raw readings
+	i := slices.Index(Resolutions, goblin.ResolutionForSpan(span))
+
+	from := formatTime(*filter.From)
+	for ; i+1 < len(Resolutions); i++ {
+		first, err := firstReading(ctx, tx, filter, i)
+		if err != nil {
+			return 0, err
+		}
+		if first == "" || from >= first {
+			break
+		}
+		// Only switch if the coarser resolution has at least one full
+		// window before the first reading at this one
+		next, err := firstReading(ctx, tx, filter, i+1)
+		if err != nil {
+			return 0, err
+		}
+		if next == "" {
+			break
+		}
+		t, err := parseTime(next)
+		if err != nil {
+			return 0, err
+		}
+		if formatTime(t.Add(Resolutions[i+1])) > first {
+			break
+		}
+	}
+
+	if i < 0 {
+		return 0, nil
+	}
+	return Resolutions[i], nil
+}
+
+// firstReading returns the time of the first reading matching the
+// capabilities of filter at Resolutions[i], or raw readings if i is -1. It
+// is empty if there are no readings.
+func firstReading(ctx context.Context, tx *sql.Tx, filter goblin.ReadingFilter, i int) (string, error) {
+	query, args := `SELECT MIN(time) FROM readings WHERE 1 = 1`, []interface{}{}
+	if i >= 0 {
+		query, args = `SELECT MIN(time) FROM reading_rollups WHERE resolution = ?`, []interface{}{seconds(Resolutions[i])}
+	}
+	if v := filter.Capability; v != nil {
+		query += " AND capability = ?"
+		args = append(args, *v)
+	}
+	if v := filter.Capabilities; v != nil {
+		in, inArgs := inClause("capability", v)
+		query += " AND " + in
+		args = append(args, inArgs...)
+	}
+	var first sql.NullString
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&first); err != nil {
+		return "", err
+	}
+	return first.String, nil
+}
+
+// inClause returns an IN condition for column that matches any of values.
+func inClause(column string, values []string) (string, []interface{}) {
+	if len(values) == 0 {
+		return "0 = 1", nil
+	}
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return column + " IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ") + ")", args
+}
+
+// readingWhere returns the conditions of filter other than the time range.
+func readingWhere(filter goblin.ReadingFilter) ([]string, []interface{}) {
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if v := filter.SensorId; v != nil {
+		where = append(where, "sensor_id = ?")
+		args = append(args, *v)
+	}
+	if v := filter.Capability; v != nil {
+		where = append(where, "capability = ?")
+		args = append(args, *v)
+	}
+	if v := filter.SensorIds; v != nil {
+		in, inArgs := inClause("sensor_id", v)
+		where = append(where, in)
+		args = append(args, inArgs...)
+	}
+	if v := filter.RoomIds; v != nil {
+		in, inArgs := inClause("room_id", v)
+		where = append(where, "sensor_id IN (SELECT id FROM sensors WHERE "+in+")")
+		args = append(args, inArgs...)
+	}
+	if v := filter.Capabilities; v != nil {
+		in, inArgs := inClause("capability", v)
+		where = append(where, in)
+		args = append(args, inArgs...)
+	}
+	return where, args
+}
+
+func rawReadings(ctx context.Context, tx *sql.Tx, filter goblin.ReadingFilter, fn func(*goblin.Reading) error) error {
+	where, args := readingWhere(filter)
+	if v := filter.From; v != nil {
+		where = append(where, "time >= ?")
+		args = append(args, formatTime(*v))
+	}
+	if v := filter.To; v != nil {
+		where = append(where, "time < ?")
+		args = append(args, formatTime(*v))
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT
+		sensor_id,
+		capability,
+		time,
+		value
+	FROM readings
+	WHERE `+strings.Join(where, " AND ")+`
+	ORDER BY time ASC, sensor_id ASC, capability ASC`,
+		args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reading := &goblin.Reading{}
+		var t string
+		err := rows.Scan(
+			&reading.SensorId,
+			&reading.Capability,
+			&t,
+			&reading.Value,
+		)
+		if err != nil {
+			return err
+		}
+		if reading.Time, err = parseTime(t); err != nil {
+			return err
+		}
+		reading.Min, reading.Max = reading.Value, reading.Value
+		if err := fn(reading); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// truncateWindow returns the start of the window of length window that t
+// falls in. Windows are aligned to the Unix epoch, like windowStart.
+func truncateWindow(t time.Time, window time.Duration) time.Time {
+	s := seconds(window)
+	return time.Unix(t.Unix()/s*s, 0).UTC()
+}
+
+// aggregatedReadings calls fn with readings aggregated into windows of
+// length window. They are aggregated from the coarsest rollup whose
+// resolution divides the window, and from raw readings for the time that
+// hasn't been rolled up yet. The window that From falls in is included in
+// full.
+func aggregatedReadings(ctx context.Context, tx *sql.Tx, filter goblin.ReadingFilter, window time.Duration, fn func(*goblin.Reading) error) error {
+	var source time.Duration
+	for _, res := range Resolutions {
+		if window%res == 0 {
+			source = res
+		}
+	}
+
+	where, args := readingWhere(filter)
+	if v := filter.From; v != nil {
+		where = append(where, "time >= ?")
+		args = append(args, formatTime(truncateWindow(*v, window)))
+	}
+	if v := filter.To; v != nil {
+		where = append(where, "time < ?")
+		args = append(args, formatTime(*v))
+	}
+
+	// Readings before rolled have been rolled up at the source resolution
+	rolled := ""
+	parts, partArgs := []string{}, []interface{}{}
+	if source != 0 {
+		var err error
+		if rolled, err = watermark(ctx, tx, source); err != nil {
+			return err
+		}
+		parts = append(parts, `SELECT
+			sensor_id,
+			capability,
+			`+windowStart("time", window)+` AS bucket,
+			min,
+			mean,
+			max,
+			count
+		FROM reading_rollups
+		WHERE resolution = ? AND time < ? AND `+strings.Join(where, " AND "))
+		partArgs = append(partArgs, seconds(source), rolled)
+		partArgs = append(partArgs, args...)
+	}
+	parts = append(parts, `SELECT
+		sensor_id,
+		capability,
+		`+windowStart("time", window)+` AS bucket,
+		value AS min,
+		value AS mean,
+		value AS max,
+		1 AS count
+	FROM readings
+	WHERE time >= ? AND `+strings.Join(where, " AND "))
+	partArgs = append(partArgs, rolled)
+	partArgs = append(partArgs, args...)
+
+	rows, err := tx.QueryContext(ctx, `SELECT
+		sensor_id,
+		capability,
+		bucket,
+		MIN(min),
+		SUM(mean * count) / SUM(count),
+		MAX(max)
+	FROM (`+strings.Join(parts, " UNION ALL ")+`)
+	GROUP BY sensor_id, capability, bucket
+	ORDER BY bucket ASC, sensor_id ASC, capability ASC`,
+		partArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reading := &goblin.Reading{Resolution: window}
+		var t string
+		err := rows.Scan(
+			&reading.SensorId,
+			&reading.Capability,
+			&t,
+			&reading.Min,
+			&reading.Value,
+			&reading.Max,
+		)
+		if err != nil {
+			return err
+		}
+		if reading.Time, err = parseTime(t); err != nil {
+			return err
+		}
+		if err := fn(reading); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
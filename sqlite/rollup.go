@@ -0,0 +1,293 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// Resolutions are the windows that readings are rolled up into, from finest
+// to coarsest. The finest resolution is rolled up from raw readings, and
+// every other resolution from the one before it. Windows are aligned to
+// UTC.
+var Resolutions = goblin.Resolutions
+
+// rollupDelay is how long to wait after a window has ended before rolling
+// it up, so that readings that are recorded late are included.
+const rollupDelay = time.Minute
+
+// RetentionPolicy decides how long readings are kept. A zero duration keeps
+// readings forever.
+type RetentionPolicy struct {
+	// Raw is how long raw readings are kept, by capability. Capabilities
+	// are matched case-insensitively, since config keys are lowercased.
+	Raw map[string]time.Duration
+	// DefaultRaw is how long raw readings of capabilities that are not in
+	// Raw are kept.
+	DefaultRaw time.Duration
+	// Rollups is how long rollups are kept, by resolution.
+	Rollups map[time.Duration]time.Duration
+}
+
+// Downsampler rolls up readings into the windows in Resolutions and prunes
+// readings according to a retention policy. Readings are only pruned after
+// they have been rolled up.
+type Downsampler struct {
+	db        *DB
+	Retention RetentionPolicy
+}
+
+func NewDownsampler(db *DB, retention RetentionPolicy) *Downsampler {
+	return &Downsampler{
+		db:        db,
+		Retention: retention,
+	}
+}
+
+// Run downsamples at start and then every interval until ctx is done.
+func (d *Downsampler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Downsample(ctx, time.Now()); err != nil {
+			log.Println("error downsampling readings:", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Downsample rolls up all windows that ended before now and prunes the
+// readings and rollups that are older than the retention policy allows.
+func (d *Downsampler) Downsample(ctx context.Context, now time.Time) error {
+	tx, err := d.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range Resolutions {
+		if err := rollup(ctx, tx, i, now); err != nil {
+			return fmt.Errorf("roll up %s: %w", Resolutions[i], err)
+		}
+	}
+
+	if err := pruneReadings(ctx, tx, d.Retention, now); err != nil {
+		return fmt.Errorf("prune readings: %w", err)
+	}
+
+	if err := pruneRollups(ctx, tx, d.Retention, now); err != nil {
+		return fmt.Errorf("prune rollups: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// seconds returns the length of a resolution as stored in the database.
+func seconds(res time.Duration) int64 {
+	return int64(res / time.Second)
+}
+
+// windowStart returns an SQL expression for the start of the window of
+// length res that the time in column falls in.
+func windowStart(column string, res time.Duration) string {
+	return fmt.Sprintf(
+		`strftime('%%Y-%%m-%%dT%%H:%%M:%%S.000Z', (CAST(strftime('%%s', %s) AS INTEGER) / %d) * %d, 'unixepoch')`,
+		column, seconds(res), seconds(res),
+	)
+}
+
+// watermark returns the time before which readings have been rolled up at
+// res, formatted as in the database. It is empty if nothing has been
+// rolled up, which compares as earlier than every time.
+func watermark(ctx context.Context, tx *sql.Tx, res time.Duration) (string, error) {
+	var rolledUntil string
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT rolled_until FROM rollup_watermarks WHERE resolution = ?`,
+		seconds(res),
+	).Scan(&rolledUntil)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return rolledUntil, err
+}
+
+// rollup aggregates the windows of Resolutions[i] that ended before now and
+// haven't been rolled up yet.
+func rollup(ctx context.Context, tx *sql.Tx, i int, now time.Time) error {
+	res := Resolutions[i]
+	from, err := watermark(ctx, tx, res)
+	if err != nil {
+		return err
+	}
+	until := formatTime(now.Add(-rollupDelay).Truncate(res))
+	if from >= until {
+		return nil
+	}
+
+	if err := rollupWindows(ctx, tx, i, from, until, "1 = 1"); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO rollup_watermarks (resolution, rolled_until)
+		VALUES (?, ?)
+		ON CONFLICT (resolution) DO UPDATE SET rolled_until = excluded.rolled_until`,
+		seconds(res), until)
+	return err
+}
+
+// rollupWindows aggregates the windows of Resolutions[i] between from and
+// until that match the condition, replacing existing rollups.
+func rollupWindows(ctx context.Context, tx *sql.Tx, i int, from, until string, where string, whereArgs ...interface{}) error {
+	res := Resolutions[i]
+	var query string
+	args := []interface{}{seconds(res)}
+	if i == 0 {
+		query = `SELECT ?, sensor_id, capability, ` + windowStart("time", res) + ` AS bucket,
+			MIN(value), AVG(value), MAX(value), COUNT(*)
+		FROM readings
+		WHERE time >= ? AND time < ? AND ` + where + `
+		GROUP BY sensor_id, capability, bucket`
+	} else {
+		query = `SELECT ?, sensor_id, capability, ` + windowStart("time", res) + ` AS bucket,
+			MIN(min), SUM(mean * count) / SUM(count), MAX(max), SUM(count)
+		FROM reading_rollups
+		WHERE resolution = ? AND time >= ? AND time < ? AND ` + where + `
+		GROUP BY sensor_id, capability, bucket`
+		args = append(args, seconds(Resolutions[i-1]))
+	}
+	args = append(args, from, until)
+	args = append(args, whereArgs...)
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO reading_rollups
+		(resolution, sensor_id, capability, time, min, mean, max, count)
+		`+query+`
+		ON CONFLICT (resolution, sensor_id, capability, time) DO UPDATE SET
+			min = excluded.min,
+			mean = excluded.mean,
+			max = excluded.max,
+			count = excluded.count`,
+		args...)
+	return err
+}
+
+// rollupLate rolls up the windows that readings stored after their windows
+// were rolled up fall in, such as imported readings. Windows whose raw
+// readings have been pruned only get the new readings.
+func rollupLate(ctx context.Context, tx *sql.Tx, readings []*goblin.Reading) error {
+	type series struct{ sensorId, capability string }
+	type span struct{ from, to time.Time }
+	spans := make(map[series]span)
+	for _, r := range readings {
+		k := series{r.SensorId, r.Capability}
+		s, ok := spans[k]
+		if !ok {
+			s = span{r.Time, r.Time}
+		}
+		if r.Time.Before(s.from) {
+			s.from = r.Time
+		}
+		if r.Time.After(s.to) {
+			s.to = r.Time
+		}
+		spans[k] = s
+	}
+
+	for i, res := range Resolutions {
+		rolled, err := watermark(ctx, tx, res)
+		if err != nil {
+			return err
+		}
+		for k, s := range spans {
+			from := formatTime(truncateWindow(s.from, res))
+			if from >= rolled {
+				continue
+			}
+			until := formatTime(truncateWindow(s.to, res).Add(res))
+			if until > rolled {
+				until = rolled
+			}
+			err := rollupWindows(ctx, tx, i, from, until, "sensor_id = ? AND capability = ?", k.sensorId, k.capability)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// cutoff returns the time before which readings may be pruned: older than
+// retention, and already rolled up into the watermark. An empty string
+// means that nothing may be pruned.
+func cutoff(now time.Time, retention time.Duration, watermark string) string {
+	if retention <= 0 {
+		return ""
+	}
+	c := formatTime(now.Add(-retention))
+	if watermark < c {
+		return watermark
+	}
+	return c
+}
+
+func pruneReadings(ctx context.Context, tx *sql.Tx, policy RetentionPolicy, now time.Time) error {
+	rolled, err := watermark(ctx, tx, Resolutions[0])
+	if err != nil {
+		return err
+	}
+
+	capabilities := []interface{}{}
+	for capability, retention := range policy.Raw {
+		capabilities = append(capabilities, capability)
+		if c := cutoff(now, retention, rolled); c != "" {
+			_, err := tx.ExecContext(ctx, `DELETE FROM readings WHERE capability = ? COLLATE NOCASE AND time < ?`, capability, c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	c := cutoff(now, policy.DefaultRaw, rolled)
+	if c == "" {
+		return nil
+	}
+	where := "time < ?"
+	if len(capabilities) > 0 {
+		where += " AND capability COLLATE NOCASE NOT IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(capabilities)), ", ") + ")"
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM readings WHERE `+where, append([]interface{}{c}, capabilities...)...)
+	return err
+}
+
+func pruneRollups(ctx context.Context, tx *sql.Tx, policy RetentionPolicy, now time.Time) error {
+	for i, res := range Resolutions {
+		// The coarsest resolution has nothing to be rolled up into
+		rolled := formatTime(now)
+		if i+1 < len(Resolutions) {
+			var err error
+			if rolled, err = watermark(ctx, tx, Resolutions[i+1]); err != nil {
+				return err
+			}
+		}
+		c := cutoff(now, policy.Rollups[res], rolled)
+		if c == "" {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM reading_rollups WHERE resolution = ? AND time < ?`, seconds(res), c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/sqlite"
+)
+
+var rollupBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the time d after rollupBase.
+func at(d time.Duration) time.Time {
+	return rollupBase.Add(d)
+}
+
+// rollupDB is a database with a sensor that measures temperature and
+// humidity, and a connection that reads its tables directly.
+type rollupDB struct {
+	readings *sqlite.ReadingService
+	conn     *sql.DB
+}
+
+func newDownsampler(t *testing.T, retention sqlite.RetentionPolicy) (*sqlite.Downsampler, rollupDB) {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "goblin.db")
+	db := sqlite.NewDatabase(path)
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	room := goblin.NewRoom("1", "Kitchen")
+	if err := sqlite.NewRoomService(db).CreateRoom(ctx, &room); err != nil {
+		t.Fatal(err)
+	}
+	sensor := &goblin.Sensor{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "1", Capabilities: []string{"temperature", "humidity"}}
+	if err := sqlite.NewSensorService(db).CreateSensor(ctx, sensor); err != nil {
+		t.Fatal(err)
+	}
+	return sqlite.NewDownsampler(db, retention), rollupDB{sqlite.NewReadingService(db), conn}
+}
+
+func downsample(t *testing.T, d *sqlite.Downsampler, now time.Time) {
+	t.Helper()
+	if err := d.Downsample(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// create stores readings of capability at the times after rollupBase.
+func (db rollupDB) create(t *testing.T, capability string, values map[time.Duration]float64) {
+	t.Helper()
+	readings := make([]*goblin.Reading, 0, len(values))
+	for d, value := range values {
+		readings = append(readings, &goblin.Reading{SensorId: "101", Capability: capability, Time: at(d), Value: value})
+	}
+	if _, err := db.readings.CreateReadings(context.Background(), readings); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// watermarks returns the watermarks by resolution.
+func (db rollupDB) watermarks(t *testing.T) map[time.Duration]string {
+	t.Helper()
+	rows, err := db.conn.Query(`SELECT resolution, rolled_until FROM rollup_watermarks`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	watermarks := make(map[time.Duration]string)
+	for rows.Next() {
+		var seconds int64
+		var rolledUntil string
+		if err := rows.Scan(&seconds, &rolledUntil); err != nil {
+			t.Fatal(err)
+		}
+		watermarks[time.Duration(seconds)*time.Second] = rolledUntil
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return watermarks
+}
+
+// rollups returns the rollups at res formatted as "capability time min mean
+// max count".
+func (db rollupDB) rollups(t *testing.T, res time.Duration) []string {
+	t.Helper()
+	rows, err := db.conn.Query(`SELECT capability, time, min, mean, max, count
+		FROM reading_rollups
+		WHERE resolution = ?
+		ORDER BY capability, time`, int64(res/time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	rollups := []string{}
+	for rows.Next() {
+		var capability, window string
+		var min, mean, max float64
+		var count int
+		if err := rows.Scan(&capability, &window, &min, &mean, &max, &count); err != nil {
+			t.Fatal(err)
+		}
+		rollups = append(rollups, fmt.Sprintf("%s %s %g %g %g %d", capability, window, min, mean, max, count))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rollups
+}
+
+// raw returns the raw readings formatted as "capability time value".
+func (db rollupDB) raw(t *testing.T) []string {
+	t.Helper()
+	raw := time.Duration(0)
+	readings, err := db.readings.FindReadings(context.Background(), goblin.ReadingFilter{Resolution: &raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted := []string{}
+	for _, r := range readings {
+		formatted = append(formatted, fmt.Sprintf("%s %s %g", r.Capability, r.Time.UTC().Format(time.RFC3339), r.Value))
+	}
+	return formatted
+}
+
+func expect(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %s %v, got %v", what, want, got)
+	}
+}
+
+func TestRollupWatermarks(t *testing.T) {
+	d, db := newDownsampler(t, sqlite.RetentionPolicy{})
+	db.create(t, "temperature", map[time.Duration]float64{
+		time.Minute:      10,
+		2 * time.Minute:  20,
+		7 * time.Minute:  30,
+		61 * time.Minute: 40,
+	})
+
+	// Only windows that ended a minute before now are rolled up, and the
+	// watermarks don't move back if the clock does
+	for _, now := range []time.Duration{6 * time.Minute, 10*time.Minute + 30*time.Second, 6 * time.Minute} {
+		downsample(t, d, at(now))
+		expect(t, "watermarks", db.watermarks(t), map[time.Duration]string{
+			5 * time.Minute: "2024-01-01T00:05:00.000Z",
+			time.Hour:       "2024-01-01T00:00:00.000Z",
+			24 * time.Hour:  "2024-01-01T00:00:00.000Z",
+		})
+		expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{"temperature 2024-01-01T00:00:00.000Z 10 15 20 2"})
+		expect(t, "1h rollups", db.rollups(t, time.Hour), []string{})
+	}
+
+	// Coarser resolutions are rolled up from finer ones
+	downsample(t, d, at(2*time.Hour+30*time.Second))
+	expect(t, "watermarks", db.watermarks(t), map[time.Duration]string{
+		5 * time.Minute: "2024-01-01T01:55:00.000Z",
+		time.Hour:       "2024-01-01T01:00:00.000Z",
+		24 * time.Hour:  "2024-01-01T00:00:00.000Z",
+	})
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{
+		"temperature 2024-01-01T00:00:00.000Z 10 15 20 2",
+		"temperature 2024-01-01T00:05:00.000Z 30 30 30 1",
+		"temperature 2024-01-01T01:00:00.000Z 40 40 40 1",
+	})
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 10 20 30 3"})
+	expect(t, "1d rollups", db.rollups(t, 24*time.Hour), []string{})
+
+	downsample(t, d, at(25*time.Hour))
+	expect(t, "1d rollups", db.rollups(t, 24*time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 10 25 40 4"})
+}
+
+func TestRollupLate(t *testing.T) {
+	d, db := newDownsampler(t, sqlite.RetentionPolicy{})
+	db.create(t, "temperature", map[time.Duration]float64{
+		time.Minute:      10,
+		2 * time.Minute:  20,
+		7 * time.Minute:  30,
+		61 * time.Minute: 40,
+	})
+	downsample(t, d, at(2*time.Hour+30*time.Second))
+
+	// A reading in a window that has been rolled up updates the window at
+	// every resolution that has rolled it up
+	db.create(t, "temperature", map[time.Duration]float64{3 * time.Minute: 60})
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{
+		"temperature 2024-01-01T00:00:00.000Z 10 30 60 3",
+		"temperature 2024-01-01T00:05:00.000Z 30 30 30 1",
+		"temperature 2024-01-01T01:00:00.000Z 40 40 40 1",
+	})
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 10 30 60 4"})
+
+	// Readings in several windows update all of them, but not the windows
+	// that haven't been rolled up
+	db.create(t, "temperature", map[time.Duration]float64{
+		8 * time.Minute:   0,
+		62 * time.Minute:  100,
+		118 * time.Minute: 1,
+	})
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{
+		"temperature 2024-01-01T00:00:00.000Z 10 30 60 3",
+		"temperature 2024-01-01T00:05:00.000Z 0 15 30 2",
+		"temperature 2024-01-01T01:00:00.000Z 40 70 100 2",
+	})
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 0 24 60 5"})
+
+	// The late reading is rolled up with the rest of its window
+	downsample(t, d, at(2*time.Hour+5*time.Minute+30*time.Second))
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute)[3:], []string{"temperature 2024-01-01T01:55:00.000Z 1 1 1 1"})
+}
+
+func TestRollupLatePruned(t *testing.T) {
+	d, db := newDownsampler(t, sqlite.RetentionPolicy{DefaultRaw: time.Hour})
+	db.create(t, "temperature", map[time.Duration]float64{
+		time.Minute:     10,
+		2 * time.Minute: 20,
+	})
+	downsample(t, d, at(2*time.Hour+30*time.Second))
+	expect(t, "raw readings", db.raw(t), []string{})
+
+	// Windows whose raw readings have been pruned only get the new
+	// reading
+	db.create(t, "temperature", map[time.Duration]float64{3 * time.Minute: 60})
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{"temperature 2024-01-01T00:00:00.000Z 60 60 60 1"})
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 60 60 60 1"})
+}
+
+func TestPruneReadings(t *testing.T) {
+	d, db := newDownsampler(t, sqlite.RetentionPolicy{
+		Raw:        map[string]time.Duration{"HUMIDITY": 30 * time.Minute},
+		DefaultRaw: time.Minute,
+	})
+	values := map[time.Duration]float64{
+		time.Minute:     1,
+		7 * time.Minute: 7,
+		9 * time.Minute: 9,
+	}
+	db.create(t, "temperature", values)
+	db.create(t, "humidity", values)
+
+	// Temperature readings are older than their retention, but only those
+	// that have been rolled up are pruned
+	downsample(t, d, at(10*time.Minute+30*time.Second))
+	expect(t, "raw readings", db.raw(t), []string{
+		"humidity 2024-01-01T00:01:00Z 1",
+		"humidity 2024-01-01T00:07:00Z 7",
+		"temperature 2024-01-01T00:07:00Z 7",
+		"humidity 2024-01-01T00:09:00Z 9",
+		"temperature 2024-01-01T00:09:00Z 9",
+	})
+
+	downsample(t, d, at(15*time.Minute+30*time.Second))
+	expect(t, "raw readings", db.raw(t), []string{
+		"humidity 2024-01-01T00:01:00Z 1",
+		"humidity 2024-01-01T00:07:00Z 7",
+		"humidity 2024-01-01T00:09:00Z 9",
+	})
+
+	// Capabilities in the policy are matched case-insensitively
+	downsample(t, d, at(38*time.Minute))
+	expect(t, "raw readings", db.raw(t), []string{
+		"humidity 2024-01-01T00:09:00Z 9",
+	})
+	expect(t, "5m rollups", len(db.rollups(t, 5*time.Minute)), 4)
+}
+
+func TestPruneRollups(t *testing.T) {
+	d, db := newDownsampler(t, sqlite.RetentionPolicy{
+		Rollups: map[time.Duration]time.Duration{
+			5 * time.Minute: 10 * time.Minute,
+			time.Hour:       time.Hour,
+			24 * time.Hour:  48 * time.Hour,
+		},
+	})
+	db.create(t, "temperature", map[time.Duration]float64{
+		time.Minute:      10,
+		61 * time.Minute: 40,
+		25 * time.Hour:   50,
+	})
+
+	// Rollups older than their retention are pruned once they have been
+	// rolled up into the next resolution, so the hourly rollup is kept
+	// until there is a daily one
+	downsample(t, d, at(2*time.Hour+30*time.Second))
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{"temperature 2024-01-01T01:00:00.000Z 40 40 40 1"})
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 10 10 10 1"})
+
+	downsample(t, d, at(26*time.Hour+30*time.Second))
+	expect(t, "5m rollups", db.rollups(t, 5*time.Minute), []string{"temperature 2024-01-02T01:00:00.000Z 50 50 50 1"})
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{})
+	expect(t, "1d rollups", db.rollups(t, 24*time.Hour), []string{"temperature 2024-01-01T00:00:00.000Z 10 25 40 2"})
+
+	// Daily rollups have nothing to be rolled up into
+	downsample(t, d, at(50*time.Hour+30*time.Second))
+	expect(t, "1h rollups", db.rollups(t, time.Hour), []string{})
+	expect(t, "1d rollups", db.rollups(t, 24*time.Hour), []string{"temperature 2024-01-02T00:00:00.000Z 50 50 50 1"})
+}
@@ -3,7 +3,6 @@ package sqlite
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"log"
 	"strings"
 
@@ -25,7 +24,35 @@ func (s *RoomService) RoomById(ctx context.Context, id string) (*goblin.Room, er
 	}
 	defer tx.Rollback()
 
-	return roomById(ctx, tx, id)
+	room, err := roomById(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachRoomSensors(ctx, tx, room); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+func (s *RoomService) FindRooms(ctx context.Context, filter goblin.RoomFilter) ([]*goblin.Room, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rooms, err := rooms(ctx, tx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachRoomSensors(ctx, tx, rooms...); err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
 }
 
 func (s *RoomService) CreateRoom(ctx context.Context, room *goblin.Room) error {
@@ -42,8 +69,43 @@ func (s *RoomService) CreateRoom(ctx context.Context, room *goblin.Room) error {
 	return tx.Commit()
 }
 
+func (s *RoomService) UpdateRoom(ctx context.Context, id string, update goblin.RoomUpdate) (*goblin.Room, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	room, err := updateRoom(ctx, tx, id, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachRoomSensors(ctx, tx, room); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// DeleteRoom deletes the room. Sensors in the room are kept, but are no
+// longer assigned to a room.
 func (s *RoomService) DeleteRoom(ctx context.Context, id string) error {
-	panic("not implemented")
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteRoom(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func roomById(ctx context.Context, tx *sql.Tx, id string) (*goblin.Room, error) {
@@ -53,14 +115,14 @@ func roomById(ctx context.Context, tx *sql.Tx, id string) (*goblin.Room, error)
 	}
 
 	if len(rooms) == 0 {
-		return nil, fmt.Errorf("room with id %s not found", id)
+		return nil, goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", id)
 	}
 
 	return rooms[0], nil
 }
 
 func rooms(ctx context.Context, tx *sql.Tx, filter goblin.RoomFilter) ([]*goblin.Room, error) {
-	where, args := []string{}, []interface{}{}
+	where, args := []string{"1 = 1"}, []interface{}{}
 	if v := filter.Id; v != nil {
 		where = append(where, "id = ?")
 		args = append(args, *v)
@@ -70,7 +132,19 @@ func rooms(ctx context.Context, tx *sql.Tx, filter goblin.RoomFilter) ([]*goblin
 		args = append(args, *v)
 	}
 
-	rows, err := tx.QueryContext(ctx, "SELECT id, name FROM rooms WHERE "+strings.Join(where, " AND "), args...)
+	orderBy, err := formatOrderBy(filter.OrderBy, "id", "name")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT
+		id,
+		name
+	FROM rooms
+	WHERE `+strings.Join(where, " AND ")+`
+	`+orderBy+`
+	`+formatLimitOffset(filter.Limit, filter.Offset),
+		args...)
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +170,52 @@ func rooms(ctx context.Context, tx *sql.Tx, filter goblin.RoomFilter) ([]*goblin
 	return rooms, nil
 }
 
+// attachRoomSensors sets the sensors of each room.
+func attachRoomSensors(ctx context.Context, tx *sql.Tx, rooms ...*goblin.Room) error {
+	for _, room := range rooms {
+		sensors, err := sensors(ctx, tx, goblin.SensorFilter{RoomId: &room.Id})
+		if err != nil {
+			return err
+		}
+		room.Sensors = sensors
+	}
+	return nil
+}
+
 func createRoom(ctx context.Context, tx *sql.Tx, room *goblin.Room) error {
 	log.Printf("inserting room %s with id %s", room.Name, room.Id)
-	stmt := `INSERT OR REPLACE INTO rooms (id, name) VALUES (?, ?)`
-	_, err := tx.ExecContext(ctx, stmt, room.Id, room.Name, room.Id)
+	stmt := `INSERT INTO rooms (id, name) VALUES (?, ?)
+	ON CONFLICT (id) DO UPDATE SET name = excluded.name`
+	_, err := tx.ExecContext(ctx, stmt, room.Id, room.Name)
+	return err
+}
+
+func updateRoom(ctx context.Context, tx *sql.Tx, id string, update goblin.RoomUpdate) (*goblin.Room, error) {
+	room, err := roomById(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := update.Name; v != nil {
+		room.Name = *v
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE rooms SET name = ? WHERE id = ?`, room.Name, id); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+func deleteRoom(ctx context.Context, tx *sql.Tx, id string) error {
+	if _, err := roomById(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE sensors SET room_id = NULL WHERE room_id = ?`, id); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM rooms WHERE id = ?`, id)
 	return err
 }
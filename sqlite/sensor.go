@@ -3,10 +3,12 @@ package sqlite
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/maehler/goblin"
+	"github.com/mattn/go-sqlite3"
 )
 
 type SensorService struct {
@@ -27,28 +29,90 @@ func (s *SensorService) SensorById(ctx context.Context, id string) (*goblin.Sens
 	return sensorById(ctx, tx, id)
 }
 
+func (s *SensorService) FindSensors(ctx context.Context, filter goblin.SensorFilter) ([]*goblin.Sensor, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return sensors(ctx, tx, filter)
+}
+
 func (s *SensorService) CreateSensor(ctx context.Context, sensor *goblin.Sensor) error {
-	_, err := s.db.db.Begin()
+	tx, err := s.db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err := createSensor(ctx, tx, sensor); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+func (s *SensorService) SyncSensor(ctx context.Context, sensor *goblin.Sensor) error {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := syncSensor(ctx, tx, sensor); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSensor deletes the sensor and all of its readings and events.
 func (s *SensorService) DeleteSensor(ctx context.Context, id string) error {
-	_, err := s.db.db.Begin()
+	tx, err := s.db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err := deleteSensor(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SensorService) UpdateSensor(ctx context.Context, id string, update goblin.SensorUpdate) (*goblin.Sensor, error) {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sensor, err := updateSensor(ctx, tx, id, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return sensor, nil
 }
 
-func (s *SensorService) UpdateRoom(ctx context.Context, id string, roomId string) error {
-	_, err := s.db.db.Begin()
+func (s *SensorService) MarkSensorRemoved(ctx context.Context, id string, removedAt time.Time) error {
+	tx, err := s.db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err := markSensorRemoved(ctx, tx, id, removedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func sensorById(ctx context.Context, tx *sql.Tx, id string) (*goblin.Sensor, error) {
@@ -58,30 +122,50 @@ func sensorById(ctx context.Context, tx *sql.Tx, id string) (*goblin.Sensor, err
 	}
 
 	if len(sensors) == 0 {
-		return nil, fmt.Errorf("user with id %s not found", id)
+		return nil, goblin.Errorf(goblin.ENOTFOUND, "sensor with id %s not found", id)
 	}
 
 	return sensors[0], nil
 }
 
 func sensors(ctx context.Context, tx *sql.Tx, filter goblin.SensorFilter) ([]*goblin.Sensor, error) {
-	where, args := []string{}, []interface{}{}
+	where, args := []string{"1 = 1"}, []interface{}{}
 	if v := filter.Id; v != nil {
 		where = append(where, "id = ?")
 		args = append(args, *v)
 	}
 	if v := filter.RoomId; v != nil {
-		where = append(where, "room_id = ?")
-		args = append(args, *v)
+		if *v == "" {
+			where = append(where, "room_id IS NULL")
+		} else {
+			where = append(where, "room_id = ?")
+			args = append(args, *v)
+		}
+	}
+	if v := filter.Removed; v != nil {
+		if *v {
+			where = append(where, "removed_at IS NOT NULL")
+		} else {
+			where = append(where, "removed_at IS NULL")
+		}
+	}
+
+	orderBy, err := formatOrderBy(filter.OrderBy, "id", "name", "sensor_type", "room_id")
+	if err != nil {
+		return nil, err
 	}
 
 	rows, err := tx.QueryContext(ctx, `SELECT
 		id,
+		name,
 		sensor_type,
-		room_id
+		room_id,
+		capabilities,
+		removed_at
 	FROM sensors
 	WHERE `+strings.Join(where, " AND ")+`
-	ORDER BY ASC id;`,
+	`+orderBy+`
+	`+formatLimitOffset(filter.Limit, filter.Offset),
 		args...)
 	if err != nil {
 		return nil, err
@@ -91,14 +175,28 @@ func sensors(ctx context.Context, tx *sql.Tx, filter goblin.SensorFilter) ([]*go
 	sensors := make([]*goblin.Sensor, 0)
 	for rows.Next() {
 		sensor := &goblin.Sensor{}
+		var roomId, removedAt sql.NullString
+		var capabilities string
 		err := rows.Scan(
 			&sensor.Id,
+			&sensor.Name,
 			&sensor.SensorType,
-			&sensor.RoomId,
+			&roomId,
+			&capabilities,
+			&removedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		sensor.RoomId = roomId.String
+		sensor.Capabilities = splitCapabilities(capabilities)
+		if removedAt.Valid {
+			t, err := parseTime(removedAt.String)
+			if err != nil {
+				return nil, err
+			}
+			sensor.RemovedAt = &t
+		}
 		sensors = append(sensors, sensor)
 	}
 
@@ -108,3 +206,169 @@ func sensors(ctx context.Context, tx *sql.Tx, filter goblin.SensorFilter) ([]*go
 
 	return sensors, nil
 }
+
+// createSensor inserts the sensor, or updates it if it already exists. A
+// sensor that was previously marked as removed or deleted is restored.
+func createSensor(ctx context.Context, tx *sql.Tx, sensor *goblin.Sensor) error {
+	log.Printf("inserting sensor %s with id %s", sensor.Name, sensor.Id)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM deleted_sensors WHERE id = ?`, sensor.Id); err != nil {
+		return err
+	}
+	stmt := `INSERT INTO sensors (id, name, sensor_type, room_id, capabilities, removed_at)
+	VALUES (?, ?, ?, ?, ?, NULL)
+	ON CONFLICT (id) DO UPDATE SET
+		name = excluded.name,
+		sensor_type = excluded.sensor_type,
+		room_id = excluded.room_id,
+		capabilities = excluded.capabilities,
+		removed_at = NULL`
+	_, err := tx.ExecContext(
+		ctx,
+		stmt,
+		sensor.Id,
+		sensor.Name,
+		sensor.SensorType,
+		nullString(sensor.RoomId),
+		strings.Join(sensor.Capabilities, ","),
+	)
+	if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+		return goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", sensor.RoomId)
+	} else if err != nil {
+		return err
+	}
+	sensor.RemovedAt = nil
+	return nil
+}
+
+// syncSensor inserts or updates a sensor from the Nexa Bridge, keeping the
+// name and room of an existing sensor if they have been edited, and sets
+// sensor to what is stored.
+func syncSensor(ctx context.Context, tx *sql.Tx, sensor *goblin.Sensor) error {
+	var deleted bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM deleted_sensors WHERE id = ?)`, sensor.Id).Scan(&deleted)
+	if err != nil {
+		return err
+	} else if deleted {
+		return goblin.Errorf(goblin.ECONFLICT, "sensor with id %s has been deleted", sensor.Id)
+	}
+
+	stmt := `INSERT INTO sensors (id, name, sensor_type, room_id, capabilities, removed_at)
+	VALUES (?, ?, ?, ?, ?, NULL)
+	ON CONFLICT (id) DO UPDATE SET
+		name = CASE WHEN name_edited THEN name ELSE excluded.name END,
+		sensor_type = excluded.sensor_type,
+		room_id = CASE WHEN room_edited THEN room_id ELSE excluded.room_id END,
+		capabilities = excluded.capabilities,
+		removed_at = NULL`
+	_, err = tx.ExecContext(
+		ctx,
+		stmt,
+		sensor.Id,
+		sensor.Name,
+		sensor.SensorType,
+		nullString(sensor.RoomId),
+		strings.Join(sensor.Capabilities, ","),
+	)
+	if isConstraint(err, sqlite3.ErrConstraintForeignKey) {
+		return goblin.Errorf(goblin.ENOTFOUND, "room with id %s not found", sensor.RoomId)
+	} else if err != nil {
+		return err
+	}
+
+	stored, err := sensorById(ctx, tx, sensor.Id)
+	if err != nil {
+		return err
+	}
+	*sensor = *stored
+	return nil
+}
+
+// deleteSensor deletes the sensor and remembers that it was deleted, so
+// that it isn't synced from the bridge again.
+func deleteSensor(ctx context.Context, tx *sql.Tx, id string) error {
+	if _, err := sensorById(ctx, tx, id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO deleted_sensors (id, deleted_at) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET deleted_at = excluded.deleted_at`,
+		id,
+		formatTime(time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM readings WHERE sensor_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reading_rollups WHERE sensor_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM events WHERE sensor_id = ?`, id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM sensors WHERE id = ?`, id)
+	return err
+}
+
+func updateSensor(ctx context.Context, tx *sql.Tx, id string, update goblin.SensorUpdate) (*goblin.Sensor, error) {
+	sensor, err := sensorById(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := update.Name; v != nil {
+		sensor.Name = *v
+	}
+	if v := update.RoomId; v != nil {
+		if *v != "" {
+			if _, err := roomById(ctx, tx, *v); err != nil {
+				return nil, err
+			}
+		}
+		sensor.RoomId = *v
+	}
+
+	// Edited names and rooms are kept when the sensor is synced
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE sensors SET
+			name = ?,
+			room_id = ?,
+			name_edited = name_edited OR ?,
+			room_edited = room_edited OR ?
+		WHERE id = ?`,
+		sensor.Name,
+		nullString(sensor.RoomId),
+		update.Name != nil,
+		update.RoomId != nil,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sensor, nil
+}
+
+func markSensorRemoved(ctx context.Context, tx *sql.Tx, id string, removedAt time.Time) error {
+	if _, err := sensorById(ctx, tx, id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE sensors SET removed_at = ? WHERE id = ?`, formatTime(removedAt), id)
+	return err
+}
+
+func splitCapabilities(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// nullString returns NULL for empty strings, which is used for optional
+// foreign keys.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
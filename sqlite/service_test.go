@@ -0,0 +1,33 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/maehler/goblin/internal/servicetest"
+	"github.com/maehler/goblin/sqlite"
+)
+
+// openDB opens a migrated database in a temporary directory, which is
+// closed when the test ends.
+func openDB(t *testing.T) *sqlite.DB {
+	t.Helper()
+	db := sqlite.NewDatabase(filepath.Join(t.TempDir(), "goblin.db"))
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestServices(t *testing.T) {
+	servicetest.Run(t, func(t *testing.T) servicetest.Services {
+		db := openDB(t)
+		return servicetest.Services{
+			Rooms:    sqlite.NewRoomService(db),
+			Sensors:  sqlite.NewSensorService(db),
+			Readings: sqlite.NewReadingService(db),
+			Events:   sqlite.NewEventService(db),
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maehler/goblin"
+)
+
+// ErrQueueFull is returned by the Writer when a reading or event is dropped
+// because the queue is full.
+var ErrQueueFull = errors.New("write queue is full")
+
+// Writer queues readings and events and writes them in batches, so that a
+// steady stream of small writes doesn't cost a transaction, and an fsync,
+// each. A batch is written when it reaches BatchSize or when Interval has
+// passed since the last one, whichever comes first.
+//
+// Writer implements goblin.ReadingService and goblin.EventService. Queued
+// readings and events are not returned by the find methods until they have
+// been written, and queued events don't get an id. Readings that are
+// already stored are skipped instead of failing the batch.
+type Writer struct {
+	db       *DB
+	readings *ReadingService
+	events   *EventService
+
+	// Largest number of readings and events written in one transaction
+	BatchSize int
+	// Longest time that a reading or event is queued
+	Interval time.Duration
+	// Largest number of readings and events that are queued before new
+	// ones are dropped
+	QueueSize int
+
+	mutex   sync.Mutex
+	queue   []writerItem
+	running bool
+	// Signals Run that a full batch is queued
+	full chan struct{}
+
+	dropped atomic.Uint64
+	written atomic.Uint64
+	failed  atomic.Uint64
+	batches atomic.Uint64
+}
+
+// writerItem is a queued reading or event.
+type writerItem struct {
+	reading *goblin.Reading
+	event   *goblin.Event
+}
+
+// WriterStats are counters of a Writer.
+type WriterStats struct {
+	// Readings and events waiting to be written
+	Queued int
+	// Readings and events dropped because the queue was full
+	Dropped uint64
+	// Readings and events written, or skipped as already stored
+	Written uint64
+	// Readings and events that couldn't be written
+	Failed uint64
+	// Transactions that wrote a batch
+	Batches uint64
+}
+
+func NewWriter(db *DB) *Writer {
+	return &Writer{
+		db:        db,
+		readings:  NewReadingService(db),
+		events:    NewEventService(db),
+		BatchSize: 500,
+		Interval:  5 * time.Second,
+		QueueSize: 10000,
+		full:      make(chan struct{}, 1),
+	}
+}
+
+// Stats returns the current counters of the writer.
+func (w *Writer) Stats() WriterStats {
+	w.mutex.Lock()
+	queued := len(w.queue)
+	w.mutex.Unlock()
+
+	return WriterStats{
+		Queued:  queued,
+		Dropped: w.dropped.Load(),
+		Written: w.written.Load(),
+		Failed:  w.failed.Load(),
+		Batches: w.batches.Load(),
+	}
+}
+
+// Run writes queued readings and events in batches until ctx is done, and
+// then writes whatever is left in the queue. Readings and events created
+// while Run isn't running are written immediately.
+func (w *Writer) Run(ctx context.Context) {
+	w.mutex.Lock()
+	w.running = true
+	w.mutex.Unlock()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	var dropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			w.mutex.Lock()
+			w.running = false
+			w.mutex.Unlock()
+
+			// The context is done, but the final batches should still
+			// be written
+			if err := w.Flush(context.WithoutCancel(ctx)); err != nil {
+				log.Println("error writing queued readings and events:", err.Error())
+			}
+			return
+		case <-ticker.C:
+		case <-w.full:
+		}
+		if err := w.Flush(ctx); err != nil {
+			log.Println("error writing queued readings and events:", err.Error())
+		}
+		if n := w.dropped.Load(); n > dropped {
+			log.Printf("dropped %d readings and events since the write queue was full", n-dropped)
+			dropped = n
+		}
+	}
+}
+
+// Flush writes all queued readings and events, one batch at a time.
+func (w *Writer) Flush(ctx context.Context) error {
+	for {
+		w.mutex.Lock()
+		n := min(len(w.queue), max(w.BatchSize, 1))
+		batch := w.queue[:n:n]
+		w.queue = w.queue[n:]
+		w.mutex.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+		if err := w.write(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// write stores batch in one transaction. If that fails, each reading and
+// event is written in a transaction of its own, so that one that can't be
+// stored doesn't take the rest of the batch with it.
+func (w *Writer) write(ctx context.Context, batch []writerItem) error {
+	err := w.writeBatch(ctx, batch)
+	if err == nil {
+		w.written.Add(uint64(len(batch)))
+		w.batches.Add(1)
+		return nil
+	}
+	if ctx.Err() != nil {
+		w.failed.Add(uint64(len(batch)))
+		return err
+	}
+
+	log.Printf("error writing batch of %d, writing one at a time: %s", len(batch), err.Error())
+	for _, item := range batch {
+		if err := w.writeBatch(ctx, []writerItem{item}); err != nil {
+			w.failed.Add(1)
+			log.Println("error writing queued item:", err.Error())
+			continue
+		}
+		w.written.Add(1)
+	}
+	return nil
+}
+
+func (w *Writer) writeBatch(ctx context.Context, batch []writerItem) error {
+	tx, err := w.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	readings := make([]*goblin.Reading, 0, len(batch))
+	for _, item := range batch {
+		if item.reading != nil {
+			readings = append(readings, item.reading)
+		} else if err := createEvent(ctx, tx, item.event); err != nil {
+			return err
+		}
+	}
+	if len(readings) > 0 {
+		if _, err := createReadings(ctx, tx, readings); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// enqueue adds item to the queue. It reports false if Run isn't running,
+// in which case the item should be written immediately.
+func (w *Writer) enqueue(item writerItem) (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.running {
+		return false, nil
+	}
+	if len(w.queue) >= w.QueueSize {
+		w.dropped.Add(1)
+		return true, ErrQueueFull
+	}
+	w.queue = append(w.queue, item)
+	if len(w.queue) >= w.BatchSize {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+// CreateReading queues a copy of reading to be written with the next
+// batch.
+func (w *Writer) CreateReading(ctx context.Context, reading *goblin.Reading) error {
+	r := *reading
+	if queued, err := w.enqueue(writerItem{reading: &r}); queued || err != nil {
+		return err
+	}
+	return w.readings.CreateReading(ctx, reading)
+}
+
+// CreateReadings writes readings immediately, since they are already a
+// batch. Queued readings and events are written first.
+func (w *Writer) CreateReadings(ctx context.Context, readings []*goblin.Reading) (int, error) {
+	if err := w.Flush(ctx); err != nil {
+		return 0, err
+	}
+	return w.readings.CreateReadings(ctx, readings)
+}
+
+func (w *Writer) FindReadings(ctx context.Context, filter goblin.ReadingFilter) ([]*goblin.Reading, error) {
+	return w.readings.FindReadings(ctx, filter)
+}
+
+func (w *Writer) StreamReadings(ctx context.Context, filter goblin.ReadingFilter, fn func(*goblin.Reading) error) error {
+	return w.readings.StreamReadings(ctx, filter, fn)
+}
+
+// CreateEvent queues a copy of event to be written with the next batch.
+// The id of event is only set if it is written immediately.
+func (w *Writer) CreateEvent(ctx context.Context, event *goblin.Event) error {
+	e := *event
+	if queued, err := w.enqueue(writerItem{event: &e}); queued || err != nil {
+		return err
+	}
+	return w.events.CreateEvent(ctx, event)
+}
+
+func (w *Writer) FindEvents(ctx context.Context, filter goblin.EventFilter) ([]*goblin.Event, error) {
+	return w.events.FindEvents(ctx, filter)
+}
+
+func (w *Writer) CountEvents(ctx context.Context, filter goblin.EventFilter) (int, error) {
+	return w.events.CountEvents(ctx, filter)
+}
@@ -0,0 +1,200 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maehler/goblin"
+	"github.com/maehler/goblin/sqlite"
+)
+
+var writerBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newWriter returns a writer of a database with a thermometer, and the
+// reading service of the database.
+func newWriter(t *testing.T) (*sqlite.Writer, *sqlite.ReadingService) {
+	t.Helper()
+	ctx := context.Background()
+	db := openDB(t)
+	room := goblin.NewRoom("1", "Kitchen")
+	if err := sqlite.NewRoomService(db).CreateRoom(ctx, &room); err != nil {
+		t.Fatal(err)
+	}
+	sensor := &goblin.Sensor{Id: "101", Name: "Thermometer", SensorType: "temperature", RoomId: "1", Capabilities: []string{"temperature"}}
+	if err := sqlite.NewSensorService(db).CreateSensor(ctx, sensor); err != nil {
+		t.Fatal(err)
+	}
+	return sqlite.NewWriter(db), sqlite.NewReadingService(db)
+}
+
+// startWriter runs w until the test ends, or until the returned function
+// is called, which waits for Run to return. It waits for w to queue before
+// returning, since creating readings writes them immediately until then.
+func startWriter(t *testing.T, w *sqlite.Writer) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+
+	// Events only get an id when they are written immediately
+	waitFor(t, "the writer to run", func() bool {
+		event := &goblin.Event{Capability: "probe", Value: "1", Time: writerBase}
+		if err := w.CreateEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		return event.Id == 0
+	})
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return stop
+}
+
+// waitFor fails the test unless cond is true within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// createReadings queues n temperature readings a minute apart from
+// writerBase plus offset minutes.
+func createReadings(t *testing.T, w *sqlite.Writer, sensorId string, offset, n int) {
+	t.Helper()
+	for i := offset; i < offset+n; i++ {
+		reading := &goblin.Reading{SensorId: sensorId, Capability: "temperature", Time: writerBase.Add(time.Duration(i) * time.Minute), Value: float64(i)}
+		if err := w.CreateReading(context.Background(), reading); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func countReadings(t *testing.T, readings *sqlite.ReadingService) int {
+	t.Helper()
+	found, err := readings.FindReadings(context.Background(), goblin.ReadingFilter{SensorIds: []string{"101"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(found)
+}
+
+func TestWriterBatchSize(t *testing.T) {
+	w, readings := newWriter(t)
+	w.BatchSize = 3
+	w.Interval = time.Hour
+	startWriter(t, w)
+	start := w.Stats()
+
+	createReadings(t, w, "101", 0, 2)
+	if stats := w.Stats(); stats.Queued != 2 || stats.Batches != start.Batches {
+		t.Fatalf("expected 2 queued readings and no batch, got %+v", stats)
+	}
+	createReadings(t, w, "101", 2, 1)
+	waitFor(t, "a full batch", func() bool { return w.Stats().Batches == start.Batches+1 })
+	if stats := w.Stats(); stats.Queued != 0 || stats.Written != start.Written+3 {
+		t.Fatalf("expected 3 readings written in one batch, got %+v", stats)
+	}
+	if n := countReadings(t, readings); n != 3 {
+		t.Fatalf("expected 3 stored readings, got %d", n)
+	}
+}
+
+func TestWriterInterval(t *testing.T) {
+	w, readings := newWriter(t)
+	w.BatchSize = 100
+	w.Interval = 20 * time.Millisecond
+	startWriter(t, w)
+	start := w.Stats()
+
+	createReadings(t, w, "101", 0, 2)
+	waitFor(t, "the interval", func() bool { return w.Stats().Written == start.Written+2 })
+	if n := countReadings(t, readings); n != 2 {
+		t.Fatalf("expected 2 stored readings, got %d", n)
+	}
+}
+
+func TestWriterFinalFlush(t *testing.T) {
+	w, readings := newWriter(t)
+	w.BatchSize = 100
+	w.Interval = time.Hour
+	stop := startWriter(t, w)
+
+	createReadings(t, w, "101", 0, 5)
+	if n := countReadings(t, readings); n != 0 {
+		t.Fatalf("expected no stored readings before stopping, got %d", n)
+	}
+	stop()
+	if stats := w.Stats(); stats.Queued != 0 {
+		t.Fatalf("expected an empty queue after stopping, got %+v", stats)
+	}
+	if n := countReadings(t, readings); n != 5 {
+		t.Fatalf("expected 5 stored readings after stopping, got %d", n)
+	}
+
+	// Readings created after the writer has stopped are written
+	// immediately
+	createReadings(t, w, "101", 5, 1)
+	if n := countReadings(t, readings); n != 6 {
+		t.Fatalf("expected 6 stored readings, got %d", n)
+	}
+}
+
+func TestWriterDropped(t *testing.T) {
+	w, readings := newWriter(t)
+	w.BatchSize = 100
+	w.Interval = time.Hour
+	w.QueueSize = 2
+	stop := startWriter(t, w)
+
+	createReadings(t, w, "101", 0, 2)
+	reading := &goblin.Reading{SensorId: "101", Capability: "temperature", Time: writerBase.Add(time.Hour), Value: 1}
+	if err := w.CreateReading(context.Background(), reading); !errors.Is(err, sqlite.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if stats := w.Stats(); stats.Dropped != 1 || stats.Queued != 2 {
+		t.Fatalf("expected 1 dropped and 2 queued readings, got %+v", stats)
+	}
+	stop()
+	if n := countReadings(t, readings); n != 2 {
+		t.Fatalf("expected the 2 queued readings to be stored, got %d", n)
+	}
+}
+
+func TestWriterFailed(t *testing.T) {
+	w, readings := newWriter(t)
+	w.BatchSize = 3
+	w.Interval = time.Hour
+	startWriter(t, w)
+	start := w.Stats()
+
+	// The reading of an unknown sensor fails the batch, and the others
+	// are written one at a time
+	createReadings(t, w, "101", 0, 1)
+	createReadings(t, w, "999", 1, 1)
+	createReadings(t, w, "101", 2, 1)
+	waitFor(t, "the batch", func() bool {
+		stats := w.Stats()
+		return stats.Written+stats.Failed == start.Written+3
+	})
+	if stats := w.Stats(); stats.Failed != 1 || stats.Written != start.Written+2 || stats.Batches != start.Batches {
+		t.Fatalf("expected 2 written and 1 failed reading outside of batches, got %+v", stats)
+	}
+	if n := countReadings(t, readings); n != 2 {
+		t.Fatalf("expected 2 stored readings, got %d", n)
+	}
+}
@@ -1,6 +1,6 @@
 /** @type {import('tailwindcss').Config} */
 module.exports = {
-  content: ["./http/templates/*.tmpl", "./http/static/input.css"],
+  content: ["./http/templates/**/*.tmpl", "./http/static/input.css"],
   theme: {
     extend: {},
   },